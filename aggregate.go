@@ -0,0 +1,286 @@
+// aggregate
+package websql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A list with metrics= returns one row of metrics per group instead of the
+// rows of the table, e.g.
+//
+//	metrics=count(*),sum(amount) AS revenue&by=status,created_at:month
+//
+// Metrics are count, sum, avg, min and max over a column, named after the
+// alias, or else the function and column, e.g. sum_amount. by lists the
+// group columns, a date column may be bucketed by hour, day, week, month or
+// year and is then named e.g. created_at_month. filter works as in a list,
+// sort takes the names of groups and metrics. The totals are the metrics
+// over all filtered rows, total is the number of groups.
+var aggregateDateUnits = []string{"hour", "day", "week", "month", "year"}
+var aggregateDefaultLimit int64 = 1000
+var aggregateMaxMetrics = 20
+
+type aggregateColumn struct {
+	Name string
+	Expr string
+}
+
+// parseMetrics parses the metrics param into named aggregate expressions.
+func parseMetrics(metrics string, tableId string, columns map[string]string, d Dialect) ([]aggregateColumn, error) {
+	ret := []aggregateColumn{}
+	for _, metric := range strings.Split(metrics, ",") {
+		metric = strings.TrimSpace(metric)
+		if metric == "" {
+			continue
+		}
+		expr := metric
+		name := ""
+		if m := aliasRegexp.FindStringSubmatch(metric); m != nil && aggregateRegexp.MatchString(strings.TrimSpace(m[1])) {
+			expr = strings.TrimSpace(m[1])
+			name = m[2]
+		}
+		m := aggregateRegexp.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, newParamError("metrics", metric, "Invalid metric: "+metric)
+		}
+		_, quoted, err := resolveExpr("metrics", expr, tableId, columns, d)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			parts := []string{strings.ToLower(m[1])}
+			if m[2] != "" {
+				parts = append(parts, "distinct")
+			}
+			if m[3] != "*" {
+				_, column := splitTableId(m[3])
+				parts = append(parts, strings.ToLower(column))
+			}
+			name = strings.Join(parts, "_")
+		}
+		ret = append(ret, aggregateColumn{Name: name, Expr: quoted})
+	}
+	if len(ret) == 0 {
+		return nil, newParamError("metrics", metrics, "No metrics.")
+	}
+	if len(ret) > aggregateMaxMetrics {
+		return nil, newParamError("metrics", metrics, fmt.Sprint("Too many metrics, the limit is ", aggregateMaxMetrics, "."))
+	}
+	return ret, nil
+}
+
+// parseAggregateBy parses the by param into named group expressions.
+func parseAggregateBy(by string, tableId string, columns map[string]string, d Dialect) ([]aggregateColumn, error) {
+	ret := []aggregateColumn{}
+	for _, b := range strings.Split(by, ",") {
+		if strings.TrimSpace(b) == "" {
+			continue
+		}
+		parts := strings.Split(b, ":")
+		if len(parts) > 2 {
+			return nil, newParamError("by", b, "Invalid group: "+b)
+		}
+		column, quoted, err := resolveColumn("by", parts[0], tableId, columns, d)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 1 {
+			ret = append(ret, aggregateColumn{Name: column, Expr: quoted})
+			continue
+		}
+		unit := strings.ToLower(strings.TrimSpace(parts[1]))
+		valid := false
+		for _, u := range aggregateDateUnits {
+			valid = valid || u == unit
+		}
+		if !valid {
+			return nil, newParamError("by", b, "Invalid date unit: "+parts[1])
+		}
+		ret = append(ret, aggregateColumn{Name: column + "_" + unit, Expr: d.DateBucket(quoted, unit)})
+	}
+	return ret, nil
+}
+
+// parseAggregateSort parses the sort param against the names of the groups
+// and metrics, by default the result is sorted by the groups.
+func parseAggregateSort(sort string, groups []aggregateColumn, metrics []aggregateColumn, d Dialect) (string, error) {
+	sortColumns := []sortColumn{}
+	for _, s := range strings.Split(sort, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		if len(parts) > 2 {
+			return "", newParamError("sort", s, "Invalid sort: "+s)
+		}
+		name := ""
+		for _, c := range append(append([]aggregateColumn{}, groups...), metrics...) {
+			if strings.EqualFold(c.Name, strings.TrimSpace(parts[0])) {
+				name = c.Name
+			}
+		}
+		if name == "" {
+			return "", newParamError("sort", s, "Unknown group or metric: "+parts[0])
+		}
+		desc := false
+		if len(parts) > 1 {
+			switch strings.ToUpper(strings.TrimSpace(parts[1])) {
+			case "DESC":
+				desc = true
+			case "ASC", "":
+			default:
+				return "", newParamError("sort", s, "Invalid sort direction: "+parts[1])
+			}
+		}
+		sortColumns = append(sortColumns, sortColumn{Name: name, Expr: d.QuoteIdentifier(name), Desc: desc})
+	}
+	if len(sortColumns) == 0 {
+		for _, g := range groups {
+			sortColumns = append(sortColumns, sortColumn{Name: g.Name, Expr: d.QuoteIdentifier(g.Name)})
+		}
+	}
+	return orderByClause(sortColumns), nil
+}
+
+// Aggregate returns the metrics per group of the filtered rows of a table,
+// the metrics over all of them, and the number of groups.
+func (this *MySqlDataOperator) Aggregate(tableId string, metrics string, by string, filter []string, sort string,
+	start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, map[string]interface{}, int64, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	rollback := func() {
+		if pinnedTx(context) == nil {
+			tx.Rollback()
+		}
+	}
+
+	// The hooks see the metrics as the fields and by as the group, as the
+	// client sent them.
+	where := this.listWhere(tableId, context)
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeListMap(tableId, db, metrics, context, &where, &sort, &by, start, limit)
+		if err != nil {
+			rollback()
+			return nil, nil, -1, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeListMap(tableId, db, metrics, context, &where, &sort, &by, start, limit)
+			if err != nil {
+				rollback()
+				return nil, nil, -1, err
+			}
+		}
+	}
+
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	d := this.GetDialect()
+	columns, err := this.tableColumns(tx, tableId)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	// Metrics and groups only take the columns the user sees in full.
+	loadUserClaims(context)
+	columns = readableColumns(tableId, columns, context)
+	metricColumns, err := parseMetrics(metrics, tableId, columns, d)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	groupColumns, err := parseAggregateBy(by, tableId, columns, d)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	sort, err = parseAggregateSort(sort, groupColumns, metricColumns, d)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+
+	selects := []string{}
+	groups := []string{}
+	for _, g := range groupColumns {
+		selects = append(selects, g.Expr+" AS "+d.QuoteIdentifier(g.Name))
+		groups = append(groups, g.Expr)
+	}
+	metricSelects := []string{}
+	for _, m := range metricColumns {
+		metricSelects = append(metricSelects, m.Expr+" AS "+d.QuoteIdentifier(m.Name))
+	}
+	fields := strings.Join(append(selects, metricSelects...), ",")
+	group := strings.Join(groups, ",")
+
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	c, _ := context["case"].(string)
+	args := whereArgs(context)
+	query := d.ListQuery(fields, tableId, where, parseGroup(group), sort, false)
+	m, err := queryToTypedMap(tx, c, d.Rebind(query), append(append([]interface{}{}, args...), d.PagingArgs(start, limit)...)...)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	totalRows, err := queryToTypedMap(tx, c, d.Rebind(fmt.Sprint("SELECT ", strings.Join(metricSelects, ","), " FROM ", tableId, where)), args...)
+	if err != nil {
+		rollback()
+		return nil, nil, -1, err
+	}
+	totals := map[string]interface{}{}
+	if len(totalRows) > 0 {
+		totals = totalRows[0]
+	}
+	var cnt int64 = 1
+	if countMode(context) == "none" {
+		cnt = -1
+	} else if group != "" {
+		_, cntData, err := queryToTypedArray(tx, "", d.Rebind(fmt.Sprint("SELECT COUNT(*) FROM (SELECT 1 AS G FROM ", tableId, where, parseGroup(group), ") AS T")), args...)
+		if err != nil {
+			rollback()
+			return nil, nil, -1, err
+		}
+		if len(cntData) > 0 {
+			cnt, _ = cntData[0][0].(int64)
+		}
+	}
+
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			dataInterceptor.AfterListMapTyped(tableId, db, fields, context, &m, cnt)
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterListMapTyped(tableId, db, fields, context, &m, cnt)
+	}
+	if masker := newColumnMasker(tableId, context); masker != nil {
+		masker.maskMap(totals)
+	}
+	commitRequestTx(tx, context)
+
+	return m, totals, cnt, nil
+}
@@ -0,0 +1,75 @@
+// aggregate
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSqliteAggregate(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE SALE (ID INTEGER PRIMARY KEY, STATUS TEXT, AMOUNT INTEGER, CREATED_AT TEXT)",
+		`INSERT INTO SALE VALUES (1, 'paid', 10, '2024-01-05'), (2, 'paid', 30, '2024-02-10'),
+			(3, 'open', 5, '2024-02-11'), (4, 'void', 1, '2024-02-12')`)
+
+	rows, totals, total, err := dbo.Aggregate("SALE", "count(*),sum(AMOUNT) AS revenue", "STATUS", []string{"STATUS!=void"},
+		"revenue:desc", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["STATUS"] != "paid" || rows[0]["REVENUE"] != int64(40) || rows[1]["COUNT"] != int64(1) {
+		t.Fatal("Expected the metrics per status:", rows)
+	}
+	if !reflect.DeepEqual(totals, map[string]interface{}{"COUNT": int64(3), "REVENUE": int64(45)}) || total != 2 {
+		t.Fatal("Expected the totals over the filtered rows:", totals, total)
+	}
+
+	rows, _, _, err = dbo.Aggregate("SALE", "max(AMOUNT)", "CREATED_AT:month", nil, "", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1]["CREATED_AT_MONTH"] != "2024-02-01" || rows[1]["MAX_AMOUNT"] != int64(30) {
+		t.Fatal("Expected the metrics per month:", rows)
+	}
+
+	for _, params := range [][3]string{
+		{"median(AMOUNT)", "", ""},
+		{"count(*)", "NOPE", ""},
+		{"count(*)", "CREATED_AT:minute", ""},
+		{"count(*)", "STATUS", "AMOUNT"},
+	} {
+		_, _, _, err := dbo.Aggregate("SALE", params[0], params[1], nil, params[2], 0, 10, testContext(t, app, nil))
+		requireStatus(t, err, 400)
+	}
+}
+
+// The hooks run before the params are checked against the table, and their
+// args come before the args of the filters.
+func TestSqliteAggregateHooks(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, SCORE INTEGER NOT NULL)",
+		"INSERT INTO ITEM VALUES (1, 1), (2, 3), (3, 5)")
+
+	for _, tableId := range []string{"ITEM", "MISSING"} {
+		context := testContext(t, app, nil)
+		delete(context, "user_token")
+		_, _, _, err := dbo.Aggregate(tableId, "sum(NOPE)", "NOPE", nil, "", 0, 10, context)
+		if err == nil || err.Error() != "No user token." {
+			t.Fatal("Expected the missing user token reported for", tableId, "got", err)
+		}
+	}
+
+	Websql.Interceptors.RegisterDataInterceptor("ITEM", 100, &scoreInterceptor{})
+	t.Cleanup(func() {
+		delete(Websql.Interceptors.DataInterceptorRegistry, "ITEM")
+	})
+	_, totals, _, err := dbo.Aggregate("ITEM", "count(*)", "", []string{"ID!=3"}, "", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals["COUNT"] != int64(1) {
+		t.Fatal("Expected the rows of both filters counted:", totals)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/elgs/gosqljson"
 )
@@ -22,23 +24,69 @@ func (this *App) OnAppCreateOrUpdate() error {
 		return errors.New("Data node not found: " + this.DataNodeId)
 	}
 
-	ds := fmt.Sprintf("%v:%v@tcp(%v:%v)/", dn.Username, dn.Password, dn.Host, dn.Port)
-	appDb, err := sql.Open("mysql", ds)
-	defer appDb.Close()
-	if err != nil {
-		return err
+	if dn.DriverName() == "sqlite3" {
+		// The database file is created on first connect.
+		err := os.MkdirAll(dn.SqliteDir(), 0755)
+		if err != nil {
+			return err
+		}
+		appDb, err := sql.Open(dn.DriverName(), this.DataSourceName(dn))
+		if err != nil {
+			return err
+		}
+		defer appDb.Close()
+		return appDb.Ping()
 	}
 
-	_, err = gosqljson.ExecDb(appDb, "CREATE DATABASE IF NOT EXISTS nd_"+this.DbName+
-		" DEFAULT CHARACTER SET utf8 COLLATE utf8_unicode_ci")
+	appDb, err := sql.Open(dn.DriverName(), dn.AdminDataSourceName())
 	if err != nil {
 		return err
 	}
+	defer appDb.Close()
 
-	sqlGrant := fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO `%s`@`%%` IDENTIFIED BY \"%s\";", "nd_"+this.DbName, this.DbName, this.Id)
-	_, err = gosqljson.ExecDb(appDb, sqlGrant)
-	if err != nil {
-		return err
+	switch dn.DriverName() {
+	case "postgres":
+		data, err := gosqljson.QueryDbToMap(appDb, "upper", "SELECT DATNAME FROM pg_database WHERE DATNAME=$1", "nd_"+this.DbName)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			_, err = gosqljson.ExecDb(appDb, "CREATE DATABASE nd_"+this.DbName+" ENCODING 'UTF8'")
+			if err != nil {
+				return err
+			}
+		}
+
+		data, err = gosqljson.QueryDbToMap(appDb, "upper", "SELECT ROLNAME FROM pg_roles WHERE ROLNAME=$1", this.DbName)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			_, err = gosqljson.ExecDb(appDb, fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD '%s'", this.DbName, this.Id))
+		} else {
+			_, err = gosqljson.ExecDb(appDb, fmt.Sprintf("ALTER ROLE %s LOGIN PASSWORD '%s'", this.DbName, this.Id))
+		}
+		if err != nil {
+			return err
+		}
+
+		// The owner of a database also owns its public schema from Postgres 15 on.
+		_, err = gosqljson.ExecDb(appDb, fmt.Sprintf("ALTER DATABASE nd_%s OWNER TO %s", this.DbName, this.DbName))
+		if err != nil {
+			return err
+		}
+	default:
+		_, err = gosqljson.ExecDb(appDb, "CREATE DATABASE IF NOT EXISTS nd_"+this.DbName+
+			" DEFAULT CHARACTER SET utf8 COLLATE utf8_unicode_ci")
+		if err != nil {
+			return err
+		}
+
+		sqlGrant := fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO `%s`@`%%` IDENTIFIED BY \"%s\";", "nd_"+this.DbName, this.DbName, this.Id)
+		_, err = gosqljson.ExecDb(appDb, sqlGrant)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -56,12 +104,20 @@ func (this *App) OnAppRemove() error {
 		return errors.New("Data node not found: " + this.DataNodeId)
 	}
 
-	ds := fmt.Sprintf("%v:%v@tcp(%v:%v)/", dn.Username, dn.Password, dn.Host, dn.Port)
-	appDb, err := sql.Open("mysql", ds)
-	defer appDb.Close()
+	if dn.DriverName() == "sqlite3" {
+		err := os.Remove(dn.SqliteDir() + "/nd_" + this.DbName + ".db")
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println(err)
+			return err
+		}
+		return nil
+	}
+
+	appDb, err := sql.Open(dn.DriverName(), dn.AdminDataSourceName())
 	if err != nil {
 		return err
 	}
+	defer appDb.Close()
 
 	// Drop database
 	_, err = gosqljson.ExecDb(appDb, "DROP DATABASE IF EXISTS nd_"+this.DbName)
@@ -71,6 +127,9 @@ func (this *App) OnAppRemove() error {
 	}
 
 	sqlDropUser := fmt.Sprintf("DROP USER IF EXISTS `%s`", this.DbName)
+	if dn.DriverName() == "postgres" {
+		sqlDropUser = fmt.Sprintf("DROP ROLE IF EXISTS %s", this.DbName)
+	}
 	_, err = gosqljson.ExecDb(appDb, sqlDropUser)
 	if err != nil {
 		fmt.Println(err)
@@ -78,3 +137,54 @@ func (this *App) OnAppRemove() error {
 	}
 	return nil
 }
+
+// DataSourceName returns the connection string the app uses to reach its own
+// database on the data node.
+func (this *App) DataSourceName(dn *DataNode) string {
+	switch dn.DriverName() {
+	case "postgres":
+		return fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=disable", this.DbName, this.Id, dn.Host, dn.Port, "nd_"+this.DbName)
+	case "sqlite3":
+		return fmt.Sprintf("%v/%v.db?_busy_timeout=5000&_journal_mode=WAL", dn.SqliteDir(), "nd_"+this.DbName)
+	default:
+		return fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", this.DbName, this.Id, dn.Host, dn.Port, "nd_"+this.DbName)
+	}
+}
+
+func (this *DataNode) DriverName() string {
+	if len(strings.TrimSpace(this.Type)) == 0 {
+		return "mysql"
+	}
+	return this.Type
+}
+
+// AdminDataSourceName returns the connection string used to create and drop
+// app databases on the data node.
+func (this *DataNode) AdminDataSourceName() string {
+	switch this.DriverName() {
+	case "postgres":
+		return fmt.Sprintf("postgres://%v:%v@%v:%v/postgres?sslmode=disable", this.Username, this.Password, this.Host, this.Port)
+	default:
+		return fmt.Sprintf("%v:%v@tcp(%v:%v)/", this.Username, this.Password, this.Host, this.Port)
+	}
+}
+
+// SqliteDir returns the directory holding the app database files of a sqlite3
+// data node. The host of the data node is used as the directory.
+func (this *DataNode) SqliteDir() string {
+	if len(strings.TrimSpace(this.Host)) == 0 {
+		return homeDir + "/." + Websql.AppName + "/data"
+	}
+	return this.Host
+}
+
+func appDialect(app *App) Dialect {
+	for _, dn := range Websql.masterData.DataNodes {
+		if dn.Id == app.DataNodeId {
+			d, _ := getDialect(dn.DriverName())
+			return d
+		}
+	}
+	d, _ := getDialect("mysql")
+	return d
+}
@@ -210,6 +210,151 @@ func (this *WebSQL) processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+	case "CLI_ROLE_ADD":
+		role := &Role{}
+		err := json.Unmarshal([]byte(cliCommand.Data), role)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.AddRole(role)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_ROLE_UPDATE":
+		role := &Role{}
+		err := json.Unmarshal([]byte(cliCommand.Data), role)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.UpdateRole(role)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_ROLE_REMOVE":
+		role := &Role{}
+		err := json.Unmarshal([]byte(cliCommand.Data), role)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.RemoveRole(role.Id, role.AppId)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_ROLE_GRANT":
+		role := &Role{}
+		err := json.Unmarshal([]byte(cliCommand.Data), role)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.GrantRole(role)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_ROLE_COLUMNS":
+		role := &Role{}
+		err := json.Unmarshal([]byte(cliCommand.Data), role)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.SetRoleColumns(role)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_POLICY_ADD":
+		policy := &Policy{}
+		err := json.Unmarshal([]byte(cliCommand.Data), policy)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.AddPolicy(policy)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_POLICY_UPDATE":
+		policy := &Policy{}
+		err := json.Unmarshal([]byte(cliCommand.Data), policy)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.UpdatePolicy(policy)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_POLICY_REMOVE":
+		policy := &Policy{}
+		err := json.Unmarshal([]byte(cliCommand.Data), policy)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.RemovePolicy(policy.Id, policy.AppId)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_SOFT_DELETE_SET":
+		softDelete := &SoftDelete{}
+		err := json.Unmarshal([]byte(cliCommand.Data), softDelete)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.SetSoftDelete(softDelete)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_SOFT_DELETE_REMOVE":
+		softDelete := &SoftDelete{}
+		err := json.Unmarshal([]byte(cliCommand.Data), softDelete)
+		if err != nil {
+			return "", err
+		}
+		err = this.masterData.RemoveSoftDelete(softDelete.TableId, softDelete.AppId)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_KEY_ROTATE":
+		err := this.masterData.RotateSigningKey(cliCommand.Data)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_KEY_REMOVE":
+		err := this.masterData.RemoveSigningKey(cliCommand.Data)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_SESSION_ADD":
+		session := &Session{}
+		err := json.Unmarshal([]byte(cliCommand.Data), session)
+		if err != nil {
+			return "", err
+		}
+		err = sessions.add(session)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_SESSION_REFRESH":
+		refresh := &sessionRefresh{}
+		err := json.Unmarshal([]byte(cliCommand.Data), refresh)
+		if err != nil || refresh.Session == nil {
+			return "", errors.New("Invalid session refresh.")
+		}
+		claims, err := sessions.refresh(refresh.Session, refresh.NewTokenHash)
+		if err != nil {
+			return "", err
+		}
+		claimsBytes, err := json.Marshal(claims)
+		if err != nil {
+			return "", err
+		}
+		return string(claimsBytes), nil
+	case "CLI_SESSION_REVOKE":
+		session := &Session{}
+		err := json.Unmarshal([]byte(cliCommand.Data), session)
+		if err != nil {
+			return "", err
+		}
+		err = sessions.revoke(session)
+		if err != nil {
+			return "", err
+		}
 	case "CLI_LI_ADD":
 		li := &LocalInterceptor{}
 		err := json.Unmarshal([]byte(cliCommand.Data), li)
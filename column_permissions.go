@@ -0,0 +1,362 @@
+// column_permissions
+package websql
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// A role can be limited to some columns of the tables and queries in the
+// Target of its column rules. A rule hides the columns not in Allow, if set,
+// and those in Deny, and masks those in Mask:
+//
+//	hide: the column is left out
+//	null: the value is null
+//	partial: all but the last 4 characters are replaced by *
+//	hash: the value is replaced by its HMAC-SHA256 keyed with the cluster secret
+//
+// A user sees a column as the least restricted of their roles does, a role
+// without rules for a table sees all of it. Columns a user does not see in
+// full cannot be written. Column names match regardless of case and
+// underscores, so that the rules hold for every case param.
+var columnMaskRanks = map[string]int{"": 0, "partial": 1, "hash": 2, "null": 3, "hide": 4}
+
+// RegisterColumnMask masks columns of a table or query for every caller,
+// whatever their roles, e.g. the verification code a signup query returns.
+// resourceId is either the table or query name or db.table.
+func (this *WebSQL) RegisterColumnMask(resourceId string, mask string, columns ...string) {
+	id := strings.ToUpper(unquoteIdentifier(resourceId))
+	if _, ok := this.ColumnMasks[id]; !ok {
+		this.ColumnMasks[id] = map[string]string{}
+	}
+	for _, column := range columns {
+		this.ColumnMasks[id][columnKey(column)] = mask
+	}
+}
+
+func registeredColumnMasks(resourceId string) map[string]string {
+	id := strings.ToUpper(unquoteIdentifier(resourceId))
+	if masks, ok := Websql.ColumnMasks[id]; ok {
+		return masks
+	}
+	_, table := splitTableId(id)
+	return Websql.ColumnMasks[table]
+}
+
+func columnKey(column string) string {
+	return strings.ToUpper(strings.Replace(unquoteIdentifier(column), "_", "", -1))
+}
+
+func columnMaskRank(mask string) int {
+	if rank, ok := columnMaskRanks[mask]; ok {
+		return rank
+	}
+	// Unknown masks hide rather than show.
+	return columnMaskRanks["hide"]
+}
+
+func columnListed(columns string, column string) bool {
+	for _, c := range strings.Split(columns, ",") {
+		if columnKey(strings.TrimSpace(c)) == columnKey(column) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkColumnRule validates the masks of a rule before it is stored.
+func checkColumnRule(rule *ColumnRule) error {
+	for column, mask := range rule.Mask {
+		if _, ok := columnMaskRanks[mask]; !ok || mask == "" {
+			return &RequestError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_param",
+				Param:   "mask",
+				Value:   column + ":" + mask,
+				Message: "Invalid mask, expected hide, null, partial or hash: " + mask,
+			}
+		}
+	}
+	return nil
+}
+
+// columnMasker decides how the columns of a table or query are shown to the
+// user of a request.
+type columnMasker struct {
+	roles      [][]*ColumnRule
+	registered map[string]string
+	masks      map[string]string
+}
+
+// newColumnMasker returns the masker of a table or query for the user of a
+// request, or nil if every column is shown in full.
+func newColumnMasker(resourceId string, context map[string]interface{}) *columnMasker {
+	ret := &columnMasker{
+		registered: registeredColumnMasks(resourceId),
+		masks:      map[string]string{},
+	}
+	if app := contextApp(context); app != nil {
+		userRoles, _ := context["user_roles"].([]string)
+		for _, role := range app.Roles {
+			if role.AppId != app.Id || role.Status == "disabled" {
+				continue
+			}
+			for _, userRole := range userRoles {
+				if role.Name != userRole {
+					continue
+				}
+				rules := []*ColumnRule{}
+				for _, rule := range role.Columns {
+					if checkAccessPermission(rule.Target, resourceId, "*", "") {
+						rules = append(rules, rule)
+					}
+				}
+				if len(rules) == 0 {
+					// This role sees every column, so the user does.
+					ret.roles = nil
+					return ret.orNil()
+				}
+				ret.roles = append(ret.roles, rules)
+			}
+		}
+	}
+	return ret.orNil()
+}
+
+func (this *columnMasker) orNil() *columnMasker {
+	if len(this.roles) == 0 && len(this.registered) == 0 {
+		return nil
+	}
+	return this
+}
+
+// mask returns how a column is shown, "" if in full.
+func (this *columnMasker) mask(column string) string {
+	key := columnKey(column)
+	if mask, ok := this.masks[key]; ok {
+		return mask
+	}
+	mask := ""
+	for i, rules := range this.roles {
+		roleMask := ""
+		for _, rule := range rules {
+			ruleMask := ""
+			if rule.Allow != "" && !columnListed(rule.Allow, column) || columnListed(rule.Deny, column) {
+				ruleMask = "hide"
+			} else {
+				for c, m := range rule.Mask {
+					if columnKey(c) == key {
+						ruleMask = m
+					}
+				}
+			}
+			if columnMaskRank(ruleMask) > columnMaskRank(roleMask) {
+				roleMask = ruleMask
+			}
+		}
+		if i == 0 || columnMaskRank(roleMask) < columnMaskRank(mask) {
+			mask = roleMask
+		}
+	}
+	if registered, ok := this.registered[key]; ok && columnMaskRank(registered) > columnMaskRank(mask) {
+		mask = registered
+	}
+	this.masks[key] = mask
+	return mask
+}
+
+func maskValue(mask string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch mask {
+	case "":
+		return v
+	case "partial":
+		return maskPartial(csvValue(v))
+	case "hash":
+		h := hmac.New(sha256.New, []byte(Websql.service.Secret))
+		h.Write([]byte(csvValue(v)))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+func maskPartial(s string) string {
+	r := []rune(s)
+	keep := 4
+	if len(r) <= keep*2 {
+		keep = len(r) / 4
+	}
+	return strings.Repeat("*", len(r)-keep) + string(r[len(r)-keep:])
+}
+
+func maskStringValue(mask string, v string) string {
+	if s, ok := maskValue(mask, v).(string); ok {
+		return s
+	}
+	return ""
+}
+
+func (this *columnMasker) maskMap(row map[string]interface{}) {
+	for k, v := range row {
+		switch mask := this.mask(k); mask {
+		case "":
+		case "hide":
+			delete(row, k)
+		default:
+			row[k] = maskValue(mask, v)
+		}
+	}
+}
+
+func (this *columnMasker) maskStringMap(row map[string]string) {
+	for k, v := range row {
+		switch mask := this.mask(k); mask {
+		case "":
+		case "hide":
+			delete(row, k)
+		default:
+			row[k] = maskStringValue(mask, v)
+		}
+	}
+}
+
+// shown returns the indexes of the headers that are not hidden.
+func (this *columnMasker) shown(headers []string) ([]int, []string) {
+	indexes := []int{}
+	shown := []string{}
+	for i, h := range headers {
+		if this.mask(h) != "hide" {
+			indexes = append(indexes, i)
+			shown = append(shown, h)
+		}
+	}
+	return indexes, shown
+}
+
+func (this *columnMasker) maskArray(headers *[]string, data *[][]interface{}) {
+	indexes, shown := this.shown(*headers)
+	for r, row := range *data {
+		masked := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			if index < len(row) {
+				masked[i] = maskValue(this.mask((*headers)[index]), row[index])
+			}
+		}
+		(*data)[r] = masked
+	}
+	*headers = shown
+}
+
+func (this *columnMasker) maskStringArray(headers *[]string, data *[][]string) {
+	indexes, shown := this.shown(*headers)
+	for r, row := range *data {
+		masked := make([]string, len(indexes))
+		for i, index := range indexes {
+			if index < len(row) {
+				masked[i] = maskStringValue(this.mask((*headers)[index]), row[index])
+			}
+		}
+		(*data)[r] = masked
+	}
+	*headers = shown
+}
+
+// maskExecResults masks the rows of the selects of a query, as maps or as
+// arrays led by their header row.
+func (this *columnMasker) maskExecResults(data *[][]interface{}) {
+	for _, results := range *data {
+		for i, result := range results {
+			switch rows := result.(type) {
+			case []map[string]string:
+				for _, row := range rows {
+					this.maskStringMap(row)
+				}
+			case [][]string:
+				if len(rows) > 0 {
+					headers := rows[0]
+					body := rows[1:]
+					this.maskStringArray(&headers, &body)
+					results[i] = append([][]string{headers}, body...)
+				}
+			}
+		}
+	}
+}
+
+// readableColumns returns the columns of a table the user of a request sees
+// in full, the only ones that filter, sort, group, by and metrics may use, so
+// that they cannot tell what the masks hide. The others are unknown.
+func readableColumns(resourceId string, columns map[string]string, context map[string]interface{}) map[string]string {
+	masker := newColumnMasker(resourceId, context)
+	if masker == nil {
+		return columns
+	}
+	ret := map[string]string{}
+	for k, column := range columns {
+		if masker.mask(column) == "" {
+			ret[k] = column
+		}
+	}
+	return ret
+}
+
+// checkColumnWrite makes sure the rows of a create or update only set columns
+// the user sees in full.
+func checkColumnWrite(resourceId string, context map[string]interface{}, data []map[string]interface{}) error {
+	masker := newColumnMasker(resourceId, context)
+	if masker == nil {
+		return nil
+	}
+	for _, row := range data {
+		for k := range row {
+			if masker.mask(k) != "" {
+				return &RequestError{
+					Status:  http.StatusForbidden,
+					Code:    "forbidden",
+					Param:   k,
+					Value:   unquoteIdentifier(resourceId),
+					Message: "Permission denied, column cannot be written: " + k,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maskRowWriter masks the rows of a stream.
+type maskRowWriter struct {
+	RowWriter
+	masker  *columnMasker
+	headers []string
+	indexes []int
+}
+
+// maskRows wraps rw so that the columns of a table or query are masked for
+// the user of a request.
+func maskRows(rw RowWriter, resourceId string, context map[string]interface{}) RowWriter {
+	masker := newColumnMasker(resourceId, context)
+	if masker == nil {
+		return rw
+	}
+	return &maskRowWriter{RowWriter: rw, masker: masker}
+}
+
+func (this *maskRowWriter) Headers(headers []string) error {
+	this.headers = headers
+	indexes, shown := this.masker.shown(headers)
+	this.indexes = indexes
+	return this.RowWriter.Headers(shown)
+}
+
+func (this *maskRowWriter) Row(row []interface{}) error {
+	masked := make([]interface{}, len(this.indexes))
+	for i, index := range this.indexes {
+		masked[i] = maskValue(this.masker.mask(this.headers[index]), row[index])
+	}
+	return this.RowWriter.Row(masked)
+}
@@ -0,0 +1,121 @@
+// column_permissions
+package websql
+
+import (
+	"testing"
+)
+
+func TestMaskValue(t *testing.T) {
+	if v := maskValue("partial", "4111111111111111"); v != "************1111" {
+		t.Error("Expected all but the last 4 characters masked, got", v)
+	}
+	if v := maskValue("partial", "abcdefgh"); v != "******gh" {
+		t.Error("Expected a short value mostly masked, got", v)
+	}
+	if v := maskValue("null", "a"); v != nil {
+		t.Error("Expected null, got", v)
+	}
+	if v := maskValue("hash", "a"); v == "a" || v != maskValue("hash", "a") {
+		t.Error("Expected a stable hash, got", v)
+	}
+	if err := checkColumnRule(&ColumnRule{Mask: map[string]string{"SSN": "blur"}}); err == nil {
+		t.Error("Expected an unknown mask rejected.")
+	}
+}
+
+// testMaskedApp returns an app whose support role sees SSN partially and no
+// SALARY, and whose hr role sees every column of EMPLOYEE.
+func testMaskedApp(t *testing.T) *App {
+	all := []*Permission{{Mode: "*", Target: "*"}}
+	return newTestApp(t, &App{Id: "app", Roles: []*Role{
+		{Id: "support", Name: "support", AppId: "app", Permissions: all, Columns: []*ColumnRule{
+			{Target: "EMPLOYEE", Deny: "salary", Mask: map[string]string{"ssn": "partial"}},
+		}},
+		{Id: "hr", Name: "hr", AppId: "app", Permissions: all},
+	}})
+}
+
+func TestColumnMasker(t *testing.T) {
+	app := testMaskedApp(t)
+	context := testContext(t, app, nil, "support")
+	loadUserClaims(context)
+	masker := newColumnMasker("EMPLOYEE", context)
+	if masker == nil || masker.mask("Ssn") != "partial" || masker.mask("SALARY") != "hide" || masker.mask("NAME") != "" {
+		t.Fatal("Expected SSN masked and SALARY hidden.")
+	}
+	columns := readableColumns("EMPLOYEE", map[string]string{"NAME": "NAME", "SSN": "SSN", "SALARY": "SALARY"}, context)
+	if len(columns) != 1 || columns["NAME"] != "NAME" {
+		t.Fatal("Expected only NAME readable:", columns)
+	}
+
+	// A user sees a column as the least restricted of their roles does.
+	context = testContext(t, app, nil, "support", "hr")
+	loadUserClaims(context)
+	if masker := newColumnMasker("EMPLOYEE", context); masker != nil {
+		t.Fatal("Expected every column shown.")
+	}
+	context = testContext(t, app, nil, "support")
+	loadUserClaims(context)
+	if masker := newColumnMasker("OTHER", context); masker != nil {
+		t.Fatal("Expected the rules limited to their target.")
+	}
+}
+
+func TestSqliteColumnMasks(t *testing.T) {
+	app := testMaskedApp(t)
+	dbo := newTestDbo(t,
+		"CREATE TABLE EMPLOYEE (ID INTEGER PRIMARY KEY, NAME TEXT, SSN TEXT, SALARY INTEGER, "+testAuditColumns+")",
+		"INSERT INTO EMPLOYEE (ID, NAME, SSN, SALARY) VALUES (1, 'alice', '123-45-6789', 100), (2, 'bob', '987-65-4321', 200)")
+
+	rows, _, err := dbo.ListMapTyped("EMPLOYEE", "*", nil, "ID", "", 0, 10, testContext(t, app, nil, "support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["SSN"] != "*******6789" || rows[0]["NAME"] != "alice" {
+		t.Fatal("Expected SSN masked:", rows)
+	}
+	if _, ok := rows[0]["SALARY"]; ok {
+		t.Fatal("Expected SALARY hidden:", rows[0])
+	}
+	row, err := dbo.LoadTyped("EMPLOYEE", "2", "*", testContext(t, app, nil, "support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := row["SALARY"]; ok || row["SSN"] != "*******4321" {
+		t.Fatal("Expected the loaded row masked:", row)
+	}
+	rows, _, err = dbo.ListMapTyped("EMPLOYEE", "*", nil, "ID", "", 0, 10, testContext(t, app, nil, "hr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0]["SSN"] != "123-45-6789" || rows[0]["SALARY"] != int64(100) {
+		t.Fatal("Expected every column in full:", rows[0])
+	}
+
+	// Masked columns cannot be used to tell what they hide, or be written.
+	_, _, err = dbo.ListMapTyped("EMPLOYEE", "*", []string{"SALARY=gt=150"}, "", "", 0, 10, testContext(t, app, nil, "support"))
+	requireStatus(t, err, 400)
+	_, _, err = dbo.ListMapTyped("EMPLOYEE", "*", nil, "SSN", "", 0, 10, testContext(t, app, nil, "support"))
+	requireStatus(t, err, 400)
+	_, _, _, err = dbo.Aggregate("EMPLOYEE", "sum(SALARY)", "", nil, "", 0, 10, testContext(t, app, nil, "support"))
+	requireStatus(t, err, 400)
+	_, err = dbo.Update("EMPLOYEE", []map[string]interface{}{{"ID": "1", "SSN": "x"}}, testContext(t, app, nil, "support"))
+	requireStatus(t, err, 403)
+	_, err = dbo.Update("EMPLOYEE", []map[string]interface{}{{"ID": "1", "NAME": "ann"}}, testContext(t, app, nil, "support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A registered mask applies to every role.
+	Websql.RegisterColumnMask("EMPLOYEE", "null", "NAME")
+	t.Cleanup(func() {
+		delete(Websql.ColumnMasks, "EMPLOYEE")
+	})
+	row, err = dbo.LoadTyped("EMPLOYEE", "1", "*", testContext(t, app, nil, "hr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := row["NAME"]; !ok || v != nil {
+		t.Fatal("Expected NAME null:", row)
+	}
+}
@@ -23,7 +23,7 @@ type DefaultHandlerInterceptor struct{}
 //var HandlerInterceptorRegistry = map[string]HandlerInterceptor{}
 
 func (this *Interceptors) RegisterDataInterceptor(id string, seq int, dataInterceptor DataInterceptor) {
-	id = strings.Replace(strings.ToUpper(id), "`", "", -1)
+	id = unquoteIdentifier(strings.ToUpper(id))
 	if this.DataInterceptorRegistry[id] == nil {
 		this.DataInterceptorRegistry[id] = make(map[int]DataInterceptor)
 	}
@@ -31,7 +31,7 @@ func (this *Interceptors) RegisterDataInterceptor(id string, seq int, dataInterc
 }
 
 func (this *Interceptors) GetDataInterceptors(id string) (map[int]DataInterceptor, []int) {
-	interceptors := this.DataInterceptorRegistry[strings.ToUpper(strings.Replace(id, "`", "", -1))]
+	interceptors := this.DataInterceptorRegistry[strings.ToUpper(unquoteIdentifier(id))]
 	keys := make([]int, 0)
 	for k := range interceptors {
 		keys = append(keys, k)
@@ -53,6 +53,16 @@ func (this *Interceptors) GetGlobalDataInterceptors() (map[int]DataInterceptor,
 	return this.GlobalDataInterceptorRegistry, keys
 }
 
+// ContextTx returns the transaction a create, update, duplicate or delete runs
+// in, or nil if it runs without one. Interceptors write through it to stay in
+// the same unit of work.
+func ContextTx(context map[string]interface{}) *sql.Tx {
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		return tx
+	}
+	return nil
+}
+
 type DataInterceptor interface {
 	BeforeLoad(resourceId string, db *sql.DB, fields string, context map[string]interface{}, id string) error
 	AfterLoad(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data map[string]string) error
@@ -64,10 +74,26 @@ type DataInterceptor interface {
 	AfterDuplicate(resourceId string, db *sql.DB, context map[string]interface{}, oldId []string, newId []string) error
 	BeforeDelete(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error
 	AfterDelete(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error
+	BeforeRestore(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error
+	AfterRestore(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error
+	// BeforeListMap and BeforeListArray may change the where clause of the
+	// list. Its placeholders are bound to context["where_args"], in order, in
+	// both the list and the count query, so a hook that adds a predicate with
+	// placeholders appends their arguments there, and one that replaces the
+	// clause replaces them too.
 	BeforeListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error
 	AfterListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data *[]map[string]string, total int64) error
 	BeforeListArray(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error
 	AfterListArray(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]string, total int64) error
+	// The typed hooks run after AfterLoad, AfterListMap and AfterListArray when
+	// the rows are loaded with their column types. The string hooks then see
+	// the rows as strings, with context["typed"] set, and what they change is
+	// carried over to the typed rows.
+	AfterLoadTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data map[string]interface{}) error
+	AfterListMapTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data *[]map[string]interface{}, total int64) error
+	AfterListArrayTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]interface{}, total int64) error
+	BeforeMetaData(resourceId string, db *sql.DB, context map[string]interface{}) error
+	AfterMetaData(resourceId string, db *sql.DB, context map[string]interface{}, meta *TableMeta) error
 	BeforeExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}) error
 	AfterExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}, data *[][]interface{}) error
 }
@@ -107,6 +133,12 @@ func (this *DefaultDataInterceptor) BeforeDelete(resourceId string, db *sql.DB,
 func (this *DefaultDataInterceptor) AfterDelete(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error {
 	return nil
 }
+func (this *DefaultDataInterceptor) BeforeRestore(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error {
+	return nil
+}
+func (this *DefaultDataInterceptor) AfterRestore(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error {
+	return nil
+}
 func (this *DefaultDataInterceptor) BeforeListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error {
 	return nil
 }
@@ -119,6 +151,21 @@ func (this *DefaultDataInterceptor) BeforeListArray(resourceId string, db *sql.D
 func (this *DefaultDataInterceptor) AfterListArray(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]string, total int64) error {
 	return nil
 }
+func (this *DefaultDataInterceptor) AfterLoadTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data map[string]interface{}) error {
+	return nil
+}
+func (this *DefaultDataInterceptor) AfterListMapTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data *[]map[string]interface{}, total int64) error {
+	return nil
+}
+func (this *DefaultDataInterceptor) AfterListArrayTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]interface{}, total int64) error {
+	return nil
+}
+func (this *DefaultDataInterceptor) BeforeMetaData(resourceId string, db *sql.DB, context map[string]interface{}) error {
+	return nil
+}
+func (this *DefaultDataInterceptor) AfterMetaData(resourceId string, db *sql.DB, context map[string]interface{}, meta *TableMeta) error {
+	return nil
+}
 
 func (this *DefaultDataInterceptor) BeforeExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}) error {
 	return nil
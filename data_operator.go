@@ -8,10 +8,23 @@ type DataOperator interface {
 	Load(resourceId string, id string, fields string, context map[string]interface{}) (map[string]string, error)
 	ListMap(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]map[string]string, int64, error)
 	ListArray(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]string, [][]string, int64, error)
+	LoadTyped(resourceId string, id string, fields string, context map[string]interface{}) (map[string]interface{}, error)
+	ListMapTyped(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, int64, error)
+	ListArrayTyped(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]string, [][]interface{}, int64, error)
+	Aggregate(resourceId string, metrics string, by string, filter []string, sort string, start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, map[string]interface{}, int64, error)
+	ListStream(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, rw RowWriter, context map[string]interface{}) error
 	Create(resourceId string, data []map[string]interface{}, context map[string]interface{}) ([]interface{}, error)
 	Update(resourceId string, data []map[string]interface{}, context map[string]interface{}) ([]int64, error)
 	Duplicate(resourceId string, id []string, context map[string]interface{}) ([]string, error)
 	Delete(resourceId string, id []string, context map[string]interface{}) ([]int64, error)
+	Restore(resourceId string, id []string, context map[string]interface{}) ([]int64, error)
+	Import(resourceId string, reader ImportReader, context map[string]interface{}) (*ImportReport, error)
 	Exec(resourceId string, params [][]interface{}, queryParams map[string]string, array bool, context map[string]interface{}) ([][]interface{}, error)
+	ExecStream(resourceId string, params [][]interface{}, queryParams map[string]string, rw RowWriter, context map[string]interface{}) error
+	KeyColumns(resourceId string) ([]string, error)
+	MetaData(resourceId string, context map[string]interface{}) (*TableMeta, error)
+	ListTables(context map[string]interface{}) ([]*TableMeta, error)
 	GetConn() (*sql.DB, error)
+	GetDialect() Dialect
+	Close() error
 }
@@ -17,6 +17,21 @@ func (this *DefaultDataOperator) ListMap(resourceId string, fields string, filte
 func (this *DefaultDataOperator) ListArray(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]string, [][]string, int64, error) {
 	return nil, nil, -1, nil
 }
+func (this *DefaultDataOperator) LoadTyped(resourceId string, id string, fields string, context map[string]interface{}) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (this *DefaultDataOperator) ListMapTyped(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, int64, error) {
+	return nil, -1, nil
+}
+func (this *DefaultDataOperator) ListArrayTyped(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, context map[string]interface{}) ([]string, [][]interface{}, int64, error) {
+	return nil, nil, -1, nil
+}
+func (this *DefaultDataOperator) Aggregate(resourceId string, metrics string, by string, filter []string, sort string, start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, map[string]interface{}, int64, error) {
+	return nil, nil, -1, nil
+}
+func (this *DefaultDataOperator) ListStream(resourceId string, fields string, filter []string, sort string, group string, start int64, limit int64, rw RowWriter, context map[string]interface{}) error {
+	return nil
+}
 func (this *DefaultDataOperator) Create(resourceId string, data map[string]interface{}, context map[string]interface{}) (interface{}, error) {
 	return nil, nil
 }
@@ -29,14 +44,38 @@ func (this *DefaultDataOperator) Duplicate(resourceId string, id string, context
 func (this *DefaultDataOperator) Delete(resourceId string, id string, context map[string]interface{}) (int64, error) {
 	return -1, nil
 }
-func (this *DefaultDataOperator) MetaData(resourceId string) ([]map[string]string, error) {
+func (this *DefaultDataOperator) Restore(resourceId string, id []string, context map[string]interface{}) ([]int64, error) {
+	return nil, nil
+}
+func (this *DefaultDataOperator) Import(resourceId string, reader ImportReader, context map[string]interface{}) (*ImportReport, error) {
+	return nil, nil
+}
+func (this *DefaultDataOperator) KeyColumns(resourceId string) ([]string, error) {
+	return []string{"ID"}, nil
+}
+func (this *DefaultDataOperator) MetaData(resourceId string, context map[string]interface{}) (*TableMeta, error) {
+	return nil, nil
+}
+func (this *DefaultDataOperator) ListTables(context map[string]interface{}) ([]*TableMeta, error) {
 	return nil, nil
 }
 
 func (this *DefaultDataOperator) Exec(resourceId string, params [][]interface{}, queryParams map[string]string, array bool, context map[string]interface{}) ([][]interface{}, error) {
 	return nil, nil
 }
+func (this *DefaultDataOperator) ExecStream(resourceId string, params [][]interface{}, queryParams map[string]string, rw RowWriter, context map[string]interface{}) error {
+	return nil
+}
 
 func (this *DefaultDataOperator) GetConn() (*sql.DB, error) {
 	return nil, nil
 }
+
+func (this *DefaultDataOperator) GetDialect() Dialect {
+	d, _ := getDialect("mysql")
+	return d
+}
+
+func (this *DefaultDataOperator) Close() error {
+	return nil
+}
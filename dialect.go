@@ -0,0 +1,433 @@
+// dialect
+package websql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dialect hides the SQL differences between the database types a data node
+// can run on.
+type Dialect interface {
+	// QuoteIdentifier quotes a single table or column name.
+	QuoteIdentifier(id string) string
+	// TableId builds the qualified table name for a table in the app database.
+	TableId(db string, table string) string
+	// Rebind rewrites ? placeholders into the form the driver expects.
+	Rebind(query string) string
+	// ListQuery builds a paged select. The paging placeholders come last.
+	// foundRows tells the query that CountQuery will follow.
+	ListQuery(fields string, tableId string, where string, group string, sort string, foundRows bool) string
+	// PagingArgs returns the arguments for the paging placeholders of ListQuery.
+	PagingArgs(start int64, limit int64) []interface{}
+	// CountQuery returns a single row, single column query with the total
+	// number of rows the last ListQuery would return without paging, and
+	// whether the query embeds where, so that its arguments must be bound.
+	// It runs in the same transaction right after ListQuery.
+	CountQuery(fields string, tableId string, where string, group string) (string, bool)
+	// EstimateQuery returns a single row, single column query with the
+	// approximate number of rows in the table, read from table statistics.
+	EstimateQuery(tableId string) (string, []interface{})
+	// ColumnsQuery returns a query listing the column names of a table, one
+	// per row.
+	ColumnsQuery(tableId string) (string, []interface{})
+	// The metadata queries below return rows of a fixed shape, so MetaData
+	// reads them the same way on every database:
+	// TablesQuery: name, type ('table' or 'view')
+	// ColumnsMetaQuery: name, data type, column type, nullable, default, auto increment
+	// IndexesQuery: index name, column name, unique, primary
+	// ForeignKeysQuery: constraint name, column name, referenced table, referenced column
+	TablesQuery(db string) (string, []interface{})
+	ColumnsMetaQuery(tableId string) (string, []interface{})
+	IndexesQuery(tableId string) (string, []interface{})
+	ForeignKeysQuery(tableId string) (string, []interface{})
+	// UpsertQuery builds an insert of fields with the placeholders qms that
+	// updates the quoted update columns of the row it conflicts with on the
+	// quoted conflict columns, or leaves that row alone if update is empty.
+	// generated is the quoted auto increment column whose value LastInsertId
+	// should also report for an updated row, where the driver needs that.
+	// guard, if not empty, is the predicate the conflicting row must meet to
+	// be updated, its arguments follow those of qms. A database without a
+	// conflict target ignores it, the row is checked before.
+	UpsertQuery(tableId string, fields string, qms string, conflict []string, update []string, generated string, guard string) string
+	// UpsertConflictTarget tells if an upsert conflicts only on its conflict
+	// columns. If not, it fires on any unique key, and reports 1 affected
+	// row for an insert, 2 for an update and 0 for a row left unchanged.
+	UpsertConflictTarget() bool
+	// UpsertReturning returns the clause that makes an upsert return true
+	// for an inserted row and false for an updated one, followed by the
+	// quoted columns, or "" if the database cannot tell.
+	UpsertReturning(columns []string) string
+	// ReturningClause returns the clause that makes an insert return the
+	// generated value of column, or "" if the driver reports it through
+	// LastInsertId instead.
+	ReturningClause(column string) string
+	// LockClause returns the clause that makes a select lock its rows until
+	// the transaction ends, or "" if the database locks otherwise.
+	LockClause() string
+	// MaxParams returns the most placeholders a single statement may have.
+	MaxParams() int
+	// DateBucket truncates a date or time expression to the start of its
+	// hour, day, week (from Monday), month or year.
+	DateBucket(expr string, unit string) string
+}
+
+var dialects = map[string]Dialect{
+	"mysql":    &MySqlDialect{},
+	"postgres": &PostgresDialect{},
+	"sqlite3":  &SqliteDialect{},
+}
+
+// getDialect returns the dialect of a database type, an error if the type is
+// not supported.
+func getDialect(dbType string) (Dialect, error) {
+	if d, ok := dialects[dbType]; ok {
+		return d, nil
+	}
+	return nil, errors.New("Unsupported database type, expected mysql, postgres or sqlite3: " + dbType)
+}
+
+func unquoteIdentifier(id string) string {
+	return strings.Replace(strings.Replace(id, "`", "", -1), "\"", "", -1)
+}
+
+// splitTableId returns the database and table name of a table id. The
+// database is empty when the id is not qualified.
+func splitTableId(tableId string) (string, string) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	if len(ts) > 1 {
+		return ts[0], ts[1]
+	}
+	return "", ts[0]
+}
+
+type MySqlDialect struct{}
+
+func (this *MySqlDialect) QuoteIdentifier(id string) string {
+	return "`" + strings.Replace(id, "`", "", -1) + "`"
+}
+func (this *MySqlDialect) TableId(db string, table string) string {
+	if len(strings.TrimSpace(db)) == 0 {
+		return this.QuoteIdentifier(table)
+	}
+	return fmt.Sprint(this.QuoteIdentifier(db), ".", this.QuoteIdentifier(table))
+}
+func (this *MySqlDialect) Rebind(query string) string {
+	return query
+}
+func (this *MySqlDialect) ListQuery(fields string, tableId string, where string, group string, sort string, foundRows bool) string {
+	if foundRows {
+		return fmt.Sprint("SELECT SQL_CALC_FOUND_ROWS ", fields, " FROM ", tableId, where, group, sort, " LIMIT ?,?")
+	}
+	return fmt.Sprint("SELECT ", fields, " FROM ", tableId, where, group, sort, " LIMIT ?,?")
+}
+func (this *MySqlDialect) PagingArgs(start int64, limit int64) []interface{} {
+	return []interface{}{start, limit}
+}
+
+// MySQL counts with FOUND_ROWS(), without repeating the filter.
+func (this *MySqlDialect) CountQuery(fields string, tableId string, where string, group string) (string, bool) {
+	return "SELECT FOUND_ROWS()", false
+}
+func (this *MySqlDialect) EstimateQuery(tableId string) (string, []interface{}) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	if len(ts) > 1 {
+		return "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA=? AND TABLE_NAME=?", []interface{}{ts[0], ts[1]}
+	}
+	return "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?", []interface{}{ts[0]}
+}
+func (this *MySqlDialect) ColumnsQuery(tableId string) (string, []interface{}) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	if len(ts) > 1 {
+		return "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", []interface{}{ts[0], ts[1]}
+	}
+	return "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", []interface{}{ts[0]}
+}
+func (this *MySqlDialect) TablesQuery(db string) (string, []interface{}) {
+	return "SELECT TABLE_NAME, CASE TABLE_TYPE WHEN 'VIEW' THEN 'view' ELSE 'table' END FROM information_schema.TABLES WHERE TABLE_SCHEMA=? ORDER BY TABLE_NAME", []interface{}{db}
+}
+func (this *MySqlDialect) ColumnsMetaQuery(tableId string) (string, []interface{}) {
+	db, table := splitTableId(tableId)
+	return "SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE='YES', COLUMN_DEFAULT, EXTRA LIKE '%auto_increment%' " +
+		"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", []interface{}{db, table}
+}
+func (this *MySqlDialect) IndexesQuery(tableId string) (string, []interface{}) {
+	db, table := splitTableId(tableId)
+	return "SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE=0, INDEX_NAME='PRIMARY' " +
+		"FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? ORDER BY INDEX_NAME, SEQ_IN_INDEX", []interface{}{db, table}
+}
+func (this *MySqlDialect) ForeignKeysQuery(tableId string) (string, []interface{}) {
+	db, table := splitTableId(tableId)
+	return "SELECT CONSTRAINT_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME " +
+		"FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA=? AND TABLE_NAME=? AND REFERENCED_TABLE_NAME IS NOT NULL " +
+		"ORDER BY CONSTRAINT_NAME, ORDINAL_POSITION", []interface{}{db, table}
+}
+
+// MySQL has no conflict target, ON DUPLICATE KEY fires on any unique key.
+func (this *MySqlDialect) UpsertQuery(tableId string, fields string, qms string, conflict []string, update []string, generated string, guard string) string {
+	sets := []string{}
+	for _, u := range update {
+		sets = append(sets, fmt.Sprint(u, "=VALUES(", u, ")"))
+	}
+	if generated != "" {
+		sets = append(sets, fmt.Sprint(generated, "=LAST_INSERT_ID(", generated, ")"))
+	}
+	if len(sets) == 0 {
+		sets = append(sets, fmt.Sprint(conflict[0], "=", conflict[0]))
+	}
+	return fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ") ON DUPLICATE KEY UPDATE ", strings.Join(sets, ","))
+}
+func (this *MySqlDialect) UpsertConflictTarget() bool {
+	return false
+}
+func (this *MySqlDialect) UpsertReturning(columns []string) string {
+	return ""
+}
+func (this *MySqlDialect) ReturningClause(column string) string {
+	return ""
+}
+func (this *MySqlDialect) LockClause() string {
+	return " FOR UPDATE"
+}
+func (this *MySqlDialect) MaxParams() int {
+	return 65535
+}
+func (this *MySqlDialect) DateBucket(expr string, unit string) string {
+	switch unit {
+	case "hour":
+		return "CAST(DATE_FORMAT(" + expr + ",'%Y-%m-%d %H:00:00') AS DATETIME)"
+	case "week":
+		return "DATE_SUB(DATE(" + expr + "),INTERVAL WEEKDAY(" + expr + ") DAY)"
+	case "month":
+		return "CAST(DATE_FORMAT(" + expr + ",'%Y-%m-01') AS DATE)"
+	case "year":
+		return "CAST(DATE_FORMAT(" + expr + ",'%Y-01-01') AS DATE)"
+	}
+	return "DATE(" + expr + ")"
+}
+
+func onConflictUpsertQuery(tableId string, fields string, qms string, conflict []string, update []string, guard string) string {
+	query := fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ") ON CONFLICT (", strings.Join(conflict, ","), ")")
+	if len(update) == 0 {
+		return query + " DO NOTHING"
+	}
+	sets := []string{}
+	for _, u := range update {
+		sets = append(sets, fmt.Sprint(u, "=EXCLUDED.", u))
+	}
+	query = fmt.Sprint(query, " DO UPDATE SET ", strings.Join(sets, ","))
+	if guard != "" {
+		query = fmt.Sprint(query, " WHERE ", guard)
+	}
+	return query
+}
+
+type PostgresDialect struct{}
+
+func (this *PostgresDialect) QuoteIdentifier(id string) string {
+	return "\"" + strings.Replace(id, "\"", "", -1) + "\""
+}
+
+// Postgres connects to the app database directly, so the database name is not
+// a valid qualifier. Tables live in the default search path.
+func (this *PostgresDialect) TableId(db string, table string) string {
+	return this.QuoteIdentifier(table)
+}
+func (this *PostgresDialect) Rebind(query string) string {
+	var buffer bytes.Buffer
+	n := 0
+	var quote rune = 0
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			buffer.WriteString(fmt.Sprint("$", n))
+			continue
+		}
+		buffer.WriteRune(c)
+	}
+	return buffer.String()
+}
+func (this *PostgresDialect) ListQuery(fields string, tableId string, where string, group string, sort string, foundRows bool) string {
+	return fmt.Sprint("SELECT ", fields, " FROM ", tableId, where, group, sort, " LIMIT ? OFFSET ?")
+}
+func (this *PostgresDialect) PagingArgs(start int64, limit int64) []interface{} {
+	return []interface{}{limit, start}
+}
+func (this *PostgresDialect) CountQuery(fields string, tableId string, where string, group string) (string, bool) {
+	return fmt.Sprint("SELECT COUNT(*) FROM (SELECT ", fields, " FROM ", tableId, where, group, ") AS T"), true
+}
+func (this *PostgresDialect) EstimateQuery(tableId string) (string, []interface{}) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	return "SELECT CAST(reltuples AS BIGINT) FROM pg_class WHERE relname=?", []interface{}{ts[len(ts)-1]}
+}
+func (this *PostgresDialect) ColumnsQuery(tableId string) (string, []interface{}) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	return "SELECT column_name FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=? ORDER BY ordinal_position", []interface{}{ts[len(ts)-1]}
+}
+func (this *PostgresDialect) TablesQuery(db string) (string, []interface{}) {
+	return "SELECT table_name, CASE table_type WHEN 'VIEW' THEN 'view' ELSE 'table' END FROM information_schema.tables " +
+		"WHERE table_schema=current_schema() ORDER BY table_name", []interface{}{}
+}
+func (this *PostgresDialect) ColumnsMetaQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT column_name, data_type, udt_name, is_nullable='YES', column_default, " +
+		"(is_identity='YES' OR COALESCE(column_default, '') LIKE 'nextval(%') " +
+		"FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=? ORDER BY ordinal_position", []interface{}{table}
+}
+func (this *PostgresDialect) IndexesQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT i.relname, a.attname, ix.indisunique, ix.indisprimary FROM pg_index ix " +
+		"JOIN pg_class t ON t.oid=ix.indrelid JOIN pg_class i ON i.oid=ix.indexrelid " +
+		"JOIN pg_attribute a ON a.attrelid=t.oid AND a.attnum=ANY(ix.indkey) " +
+		"WHERE t.relname=? AND t.relnamespace=current_schema()::regnamespace " +
+		"ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)", []interface{}{table}
+}
+func (this *PostgresDialect) ForeignKeysQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT kcu.constraint_name, kcu.column_name, rku.table_name, rku.column_name " +
+		"FROM information_schema.referential_constraints rc " +
+		"JOIN information_schema.key_column_usage kcu ON kcu.constraint_schema=rc.constraint_schema AND kcu.constraint_name=rc.constraint_name " +
+		"JOIN information_schema.key_column_usage rku ON rku.constraint_schema=rc.unique_constraint_schema " +
+		"AND rku.constraint_name=rc.unique_constraint_name AND rku.ordinal_position=kcu.position_in_unique_constraint " +
+		"WHERE kcu.table_schema=current_schema() AND kcu.table_name=? " +
+		"ORDER BY kcu.constraint_name, kcu.ordinal_position", []interface{}{table}
+}
+func (this *PostgresDialect) UpsertQuery(tableId string, fields string, qms string, conflict []string, update []string, generated string, guard string) string {
+	return onConflictUpsertQuery(tableId, fields, qms, conflict, update, guard)
+}
+func (this *PostgresDialect) UpsertConflictTarget() bool {
+	return true
+}
+
+// xmax is 0 in a row version no transaction has locked or deleted, which a
+// freshly inserted row is and a conflicting row just updated is not.
+func (this *PostgresDialect) UpsertReturning(columns []string) string {
+	return " RETURNING (xmax = 0)" + strings.Join(append([]string{""}, columns...), ",")
+}
+
+// lib/pq does not implement LastInsertId.
+func (this *PostgresDialect) ReturningClause(column string) string {
+	return " RETURNING " + this.QuoteIdentifier(column)
+}
+func (this *PostgresDialect) LockClause() string {
+	return " FOR UPDATE"
+}
+func (this *PostgresDialect) MaxParams() int {
+	return 65535
+}
+func (this *PostgresDialect) DateBucket(expr string, unit string) string {
+	return fmt.Sprint("date_trunc('", unit, "',", expr, ")")
+}
+
+type SqliteDialect struct{}
+
+func (this *SqliteDialect) QuoteIdentifier(id string) string {
+	return "\"" + strings.Replace(id, "\"", "", -1) + "\""
+}
+
+// Each app has its own database file, which is always attached as main.
+func (this *SqliteDialect) TableId(db string, table string) string {
+	return this.QuoteIdentifier(table)
+}
+func (this *SqliteDialect) Rebind(query string) string {
+	return query
+}
+func (this *SqliteDialect) ListQuery(fields string, tableId string, where string, group string, sort string, foundRows bool) string {
+	return fmt.Sprint("SELECT ", fields, " FROM ", tableId, where, group, sort, " LIMIT ? OFFSET ?")
+}
+func (this *SqliteDialect) PagingArgs(start int64, limit int64) []interface{} {
+	return []interface{}{limit, start}
+}
+func (this *SqliteDialect) CountQuery(fields string, tableId string, where string, group string) (string, bool) {
+	return fmt.Sprint("SELECT COUNT(*) FROM (SELECT ", fields, " FROM ", tableId, where, group, ") AS T"), true
+}
+
+// SQLite keeps no row statistics. The largest rowid is a close upper bound for
+// tables that are rarely deleted from.
+func (this *SqliteDialect) EstimateQuery(tableId string) (string, []interface{}) {
+	return fmt.Sprint("SELECT COALESCE(MAX(ROWID), 0) FROM ", tableId), []interface{}{}
+}
+
+// SQLite has no information_schema. pragma_table_info is a table valued
+// function since 3.16.
+func (this *SqliteDialect) ColumnsQuery(tableId string) (string, []interface{}) {
+	ts := strings.Split(unquoteIdentifier(tableId), ".")
+	return "SELECT name FROM pragma_table_info(?) ORDER BY cid", []interface{}{ts[len(ts)-1]}
+}
+func (this *SqliteDialect) TablesQuery(db string) (string, []interface{}) {
+	return "SELECT name, type FROM sqlite_master WHERE type IN ('table','view') AND name NOT LIKE 'sqlite_%' ORDER BY name", []interface{}{}
+}
+
+// A column is an alias of the rowid, and so auto increments, when it is the
+// only primary key column and declared as INTEGER.
+func (this *SqliteDialect) ColumnsMetaQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT name, LOWER(type), type, \"notnull\"=0 AND pk=0, dflt_value, " +
+		"pk=1 AND UPPER(type)='INTEGER' AND (SELECT COUNT(*) FROM pragma_table_info(?) WHERE pk>0)=1 " +
+		"FROM pragma_table_info(?) ORDER BY cid", []interface{}{table, table}
+}
+
+// An INTEGER PRIMARY KEY is the rowid itself and has no index of its own, so
+// it is read from the table info instead.
+func (this *SqliteDialect) IndexesQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT n, c, u, p FROM (" +
+		"SELECT il.name AS n, ii.name AS c, il.\"unique\" AS u, il.origin='pk' AS p, ii.seqno AS s " +
+		"FROM pragma_index_list(?) il, pragma_index_info(il.name) ii " +
+		"UNION ALL SELECT 'PRIMARY', name, 1, 1, pk FROM pragma_table_info(?) WHERE pk>0 " +
+		"AND NOT EXISTS (SELECT 1 FROM pragma_index_list(?) WHERE origin='pk')) " +
+		"ORDER BY n, s", []interface{}{table, table, table}
+}
+func (this *SqliteDialect) ForeignKeysQuery(tableId string) (string, []interface{}) {
+	_, table := splitTableId(tableId)
+	return "SELECT 'fk_' || id, \"from\", \"table\", \"to\" FROM pragma_foreign_key_list(?) ORDER BY id, seq", []interface{}{table}
+}
+
+// SQLite supports the Postgres upsert syntax since 3.24.
+func (this *SqliteDialect) UpsertQuery(tableId string, fields string, qms string, conflict []string, update []string, generated string, guard string) string {
+	return onConflictUpsertQuery(tableId, fields, qms, conflict, update, guard)
+}
+func (this *SqliteDialect) UpsertConflictTarget() bool {
+	return true
+}
+
+// SQLite reports no difference, an upsert inserts with DO NOTHING first and
+// updates the row it conflicted with separately.
+func (this *SqliteDialect) UpsertReturning(columns []string) string {
+	return ""
+}
+func (this *SqliteDialect) ReturningClause(column string) string {
+	return ""
+}
+
+// SQLite locks the whole database on the first write of a transaction.
+func (this *SqliteDialect) LockClause() string {
+	return ""
+}
+
+// SQLITE_MAX_VARIABLE_NUMBER defaults to 999 before 3.32.
+func (this *SqliteDialect) MaxParams() int {
+	return 999
+}
+func (this *SqliteDialect) DateBucket(expr string, unit string) string {
+	switch unit {
+	case "hour":
+		return "strftime('%Y-%m-%d %H:00:00'," + expr + ")"
+	case "week":
+		return "date(" + expr + ",'-6 days','weekday 1')"
+	case "month":
+		return "date(" + expr + ",'start of month')"
+	case "year":
+		return "date(" + expr + ",'start of year')"
+	}
+	return "date(" + expr + ")"
+}
@@ -0,0 +1,149 @@
+// dialect
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetDialect(t *testing.T) {
+	cases := map[string]Dialect{
+		"mysql":    &MySqlDialect{},
+		"postgres": &PostgresDialect{},
+		"sqlite3":  &SqliteDialect{},
+	}
+	for dbType, expected := range cases {
+		d, err := getDialect(dbType)
+		if err != nil || reflect.TypeOf(d) != reflect.TypeOf(expected) {
+			t.Error("Expected the dialect of", dbType, "got", d, err)
+		}
+	}
+	for _, dbType := range []string{"", "mssql", "MySQL"} {
+		if _, err := getDialect(dbType); err == nil {
+			t.Error("Expected an unsupported database type rejected:", dbType)
+		}
+	}
+	if _, err := NewDbo("", "postgresql", &DataNode{}); err == nil {
+		t.Error("Expected no data operator for an unsupported database type.")
+	}
+}
+
+func TestDataNodeType(t *testing.T) {
+	masterData := &MasterData{DataNodes: []*DataNode{{Id: "dn", Name: "dn"}}}
+	if err := masterData.AddDataNode(&DataNode{Name: "other", Type: "oracle"}); err == nil {
+		t.Error("Expected a data node of an unsupported type rejected.")
+	}
+	if err := masterData.UpdateDataNode(&DataNode{Id: "dn", Type: "postgresql"}); err == nil {
+		t.Error("Expected a data node changed to an unsupported type rejected.")
+	}
+	if len(masterData.DataNodes) != 1 || masterData.DataNodes[0].Type != "" || masterData.Version != 0 {
+		t.Error("Expected the data nodes unchanged.")
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	cases := []struct {
+		d        Dialect
+		db       string
+		table    string
+		expected string
+	}{
+		{&MySqlDialect{}, "app", "ITEM", "`app`.`ITEM`"},
+		{&MySqlDialect{}, "", "IT`EM", "`ITEM`"},
+		{&PostgresDialect{}, "app", "ITEM", `"ITEM"`},
+		{&SqliteDialect{}, "app", `IT"EM`, `"ITEM"`},
+	}
+	for _, c := range cases {
+		if tableId := c.d.TableId(c.db, c.table); tableId != c.expected {
+			t.Error("Expected", c.expected, "got", tableId)
+		}
+	}
+	if db, table := splitTableId("`app`.`ITEM`"); db != "app" || table != "ITEM" {
+		t.Error("Expected app and ITEM, got", db, table)
+	}
+	if db, table := splitTableId(`"ITEM"`); db != "" || table != "ITEM" {
+		t.Error("Expected ITEM only, got", db, table)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT * FROM T WHERE A=? AND B='?' AND \"C?\"=?"
+	if rebound := (&PostgresDialect{}).Rebind(query); rebound != "SELECT * FROM T WHERE A=$1 AND B='?' AND \"C?\"=$2" {
+		t.Error("Expected placeholders outside quotes numbered, got", rebound)
+	}
+	if rebound := (&SqliteDialect{}).Rebind(query); rebound != query {
+		t.Error("Expected the query unchanged, got", rebound)
+	}
+}
+
+func TestUpsertQuery(t *testing.T) {
+	query := (&SqliteDialect{}).UpsertQuery(`"ITEM"`, "ID,NAME", "?,?", []string{"ID"}, []string{"NAME"}, "", "DELETED_AT IS NULL")
+	expected := `INSERT INTO "ITEM" (ID,NAME) VALUES (?,?) ON CONFLICT (ID) DO UPDATE SET NAME=EXCLUDED.NAME WHERE DELETED_AT IS NULL`
+	if query != expected {
+		t.Error("Expected", expected, "got", query)
+	}
+	query = (&PostgresDialect{}).UpsertQuery(`"ITEM"`, "ID", "?", []string{"ID"}, nil, "", "")
+	if expected = `INSERT INTO "ITEM" (ID) VALUES (?) ON CONFLICT (ID) DO NOTHING`; query != expected {
+		t.Error("Expected", expected, "got", query)
+	}
+	// MySQL has no conflict target, and so no guard.
+	query = (&MySqlDialect{}).UpsertQuery("`ITEM`", "ID,NAME", "?,?", []string{"ID"}, []string{"NAME"}, "ID", "DELETED_AT IS NULL")
+	if expected = "INSERT INTO `ITEM` (ID,NAME) VALUES (?,?) ON DUPLICATE KEY UPDATE NAME=VALUES(NAME),ID=LAST_INSERT_ID(ID)"; query != expected {
+		t.Error("Expected", expected, "got", query)
+	}
+}
+
+// The queries of the SQLite dialect run as they are generated.
+func TestSqliteDialectQueries(t *testing.T) {
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, CREATED_AT TEXT, DELETED_AT TEXT)",
+		"CREATE UNIQUE INDEX ITEM_NAME ON ITEM (NAME)",
+		"INSERT INTO ITEM VALUES (1, 'a', '2024-03-15 10:20:30', NULL), (2, 'b', '2024-03-16 00:00:00', '2024-04-01')")
+	db, _ := dbo.GetConn()
+	d := dbo.GetDialect()
+	tableId := d.TableId("app", "ITEM")
+
+	query := d.ListQuery("ID,NAME", tableId, " WHERE ID>?", "", " ORDER BY ID", false)
+	_, rows, err := queryToTypedArray(db, "", query, append([]interface{}{0}, d.PagingArgs(1, 1)...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0][0] != int64(2) {
+		t.Fatal("Expected the second row only:", rows)
+	}
+
+	query, filtered := d.CountQuery("ID", tableId, " WHERE ID>?", "")
+	if !filtered {
+		t.Fatal("Expected the count to repeat the filter.")
+	}
+	var count int64
+	if err := db.QueryRow(query, 0).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("Expected 2 rows counted, got", count)
+	}
+
+	var bucket string
+	if err := db.QueryRow("SELECT " + d.DateBucket("CREATED_AT", "month") + " FROM ITEM WHERE ID=1").Scan(&bucket); err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "2024-03-01" {
+		t.Fatal("Expected the start of the month, got", bucket)
+	}
+
+	// The guard keeps the deleted row from being updated.
+	query = d.UpsertQuery(tableId, "ID,NAME", "?,?", []string{"ID"}, []string{"NAME"}, "", "DELETED_AT IS NULL")
+	for _, values := range [][]interface{}{{1, "c"}, {2, "d"}} {
+		if _, err := db.Exec(query, values...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, rows, err = queryToTypedArray(db, "", "SELECT NAME FROM ITEM ORDER BY ID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != "c" || rows[1][0] != "b" {
+		t.Fatal("Expected only the row not deleted updated:", rows)
+	}
+}
@@ -0,0 +1,220 @@
+// expand
+package websql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The expand parameter lists relations whose rows are nested into the rows of
+// a list or load, e.g. expand=customer,lines.product. A relation to one row
+// nests that row or null, a relation to many rows nests a list of at most
+// expand_limit rows.
+var expandMaxDepth = 3
+var expandMaxRelations = 10
+var expandDefaultLimit int64 = 10
+var expandMaxLimit int64 = 100
+
+type expandNode struct {
+	Name     string
+	Children []*expandNode
+}
+
+func parseExpand(expand string) ([]*expandNode, error) {
+	ret := []*expandNode{}
+	count := 0
+	for _, path := range strings.Split(expand, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if len(names) > expandMaxDepth {
+			return nil, newParamError("expand", path, fmt.Sprint("Expand is nested deeper than ", expandMaxDepth, ": ", path))
+		}
+		nodes := &ret
+		for _, name := range names {
+			if !columnRegexp.MatchString(name) {
+				return nil, newParamError("expand", path, "Invalid relation: "+path)
+			}
+			var node *expandNode
+			for _, n := range *nodes {
+				if strings.EqualFold(n.Name, name) {
+					node = n
+				}
+			}
+			if node == nil {
+				count++
+				node = &expandNode{Name: name, Children: []*expandNode{}}
+				*nodes = append(*nodes, node)
+			}
+			nodes = &node.Children
+		}
+	}
+	if count > expandMaxRelations {
+		return nil, newParamError("expand", expand, fmt.Sprint("Too many relations to expand, the limit is ", expandMaxRelations, "."))
+	}
+	return ret, nil
+}
+
+// rowValues returns the values of columns in a row, or false if the row does
+// not have all of them.
+func rowValues(row map[string]interface{}, columns []string) ([]interface{}, bool) {
+	ret := []interface{}{}
+	for _, c := range columns {
+		found := false
+		for k, v := range row {
+			if sameColumn(k, c) {
+				ret = append(ret, v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return ret, true
+}
+
+// expandRows nests the related rows listed in context["expand"] into rows.
+func (this *MySqlDataOperator) expandRows(q sqlQuerier, tableId string, rows []map[string]interface{}, context map[string]interface{}) error {
+	expand, _ := context["expand"].(string)
+	if strings.TrimSpace(expand) == "" || len(rows) == 0 {
+		return nil
+	}
+	nodes, err := parseExpand(expand)
+	if err != nil {
+		return err
+	}
+	return this.expandNodes(q, tableId, rows, nodes, context)
+}
+
+func (this *MySqlDataOperator) expandNodes(q sqlQuerier, tableId string, rows []map[string]interface{}, nodes []*expandNode, context map[string]interface{}) error {
+	d := this.GetDialect()
+	c, _ := context["case"].(string)
+	limit, ok := context["expand_limit"].(int64)
+	if !ok {
+		limit = expandDefaultLimit
+	}
+	for _, node := range nodes {
+		relation, err := this.findRelation(q, tableId, node.Name)
+		if err != nil {
+			return err
+		}
+		err = this.checkReadable(relation.Table, context)
+		if err != nil {
+			return err
+		}
+		where := []string{}
+		for _, rc := range relation.RefColumns {
+			where = append(where, d.QuoteIdentifier(rc)+"=?")
+		}
+		deleted := this.softDeleteFilter(relation.Table, "", context)
+		if deleted != "" {
+			deleted = " AND " + deleted
+		}
+		// Only the related rows the user may read are expanded.
+		policy, policyArgs, err := policyPredicate(relation.Table, false, context)
+		if err != nil {
+			return err
+		}
+		if policy != "" {
+			deleted = fmt.Sprint(deleted, " AND ", policy)
+		}
+		// The related rows of all rows are read in one query.
+		args := []interface{}{}
+		predicates := []string{}
+		seen := map[string]bool{}
+		for _, row := range rows {
+			values, ok := rowValues(row, relation.Columns)
+			if !ok {
+				return newParamError("expand", node.Name, "Expand needs the columns "+strings.Join(relation.Columns, ",")+" in fields.")
+			}
+			id := joinKey(values)
+			if hasNilValue(values) || seen[id] {
+				continue
+			}
+			seen[id] = true
+			predicates = append(predicates, "("+strings.Join(where, " AND ")+")")
+			args = append(args, values...)
+		}
+		sort := ""
+		if relation.Many {
+			// Sorted by key, so that each row gets its first related rows.
+			key, err := this.tableKey(q, relation.Table)
+			if err != nil {
+				return err
+			}
+			if len(key.Columns) > 0 {
+				sortColumns := []sortColumn{}
+				for _, kc := range key.Columns {
+					sortColumns = append(sortColumns, sortColumn{Name: kc, Expr: d.QuoteIdentifier(kc)})
+				}
+				sort = orderByClause(sortColumns)
+			}
+		}
+		args = append(args, policyArgs...)
+		found := []map[string]interface{}{}
+		if len(predicates) > 0 {
+			found, err = queryToTypedMap(q, c, d.Rebind(fmt.Sprint("SELECT * FROM ", relation.Table, " WHERE (", strings.Join(predicates, " OR "), ")", deleted, sort)), args...)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+		}
+		grouped := map[string][]map[string]interface{}{}
+		for _, r := range found {
+			values, _ := rowValues(r, relation.RefColumns)
+			id := joinKey(values)
+			if relation.Many && int64(len(grouped[id])) >= limit {
+				continue
+			}
+			grouped[id] = append(grouped[id], r)
+		}
+
+		related := []map[string]interface{}{}
+		for _, row := range rows {
+			values, _ := rowValues(row, relation.Columns)
+			children := []map[string]interface{}{}
+			if !hasNilValue(values) {
+				if g, ok := grouped[joinKey(values)]; ok {
+					children = g
+				}
+			}
+			if relation.Many {
+				row[node.Name] = children
+			} else if len(children) > 0 {
+				row[node.Name] = children[0]
+			} else {
+				row[node.Name] = nil
+			}
+		}
+		for _, g := range grouped {
+			related = append(related, g...)
+		}
+
+		if len(node.Children) > 0 && len(related) > 0 {
+			err = this.expandNodes(q, relation.Table, related, node.Children, context)
+			if err != nil {
+				return err
+			}
+		}
+		// The related rows are masked as a list of their table would be.
+		if masker := newColumnMasker(relation.Table, context); masker != nil {
+			for _, r := range related {
+				masker.maskMap(r)
+			}
+		}
+	}
+	return nil
+}
+
+func hasNilValue(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
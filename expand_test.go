@@ -0,0 +1,82 @@
+// expand
+package websql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseExpand(t *testing.T) {
+	nodes, err := parseExpand("customer,lines.product,lines.unit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[1].Name != "lines" || len(nodes[1].Children) != 2 {
+		t.Fatal("Expected the paths merged into a tree:", nodes)
+	}
+	_, err = parseExpand("a.b.c.d")
+	requireStatus(t, err, 400)
+	_, err = parseExpand("a;b")
+	requireStatus(t, err, 400)
+	names := []string{}
+	for i := 0; i <= expandMaxRelations; i++ {
+		names = append(names, fmt.Sprint("r", i))
+	}
+	_, err = parseExpand(strings.Join(names, ","))
+	requireStatus(t, err, 400)
+}
+
+func TestSqliteExpand(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", Roles: []*Role{
+		{Name: "clerk", AppId: "app", Permissions: []*Permission{{Target: "ORDERS,CUSTOMER", Mode: "load,list"}}},
+		{Name: "admin", AppId: "app", Permissions: []*Permission{{Target: "*", Mode: "*"}}},
+	}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE CUSTOMER (ID INTEGER PRIMARY KEY, NAME TEXT)",
+		"CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, CUSTOMER_ID INTEGER REFERENCES CUSTOMER (ID))",
+		"CREATE TABLE LINE (ID INTEGER PRIMARY KEY, ORDER_ID INTEGER NOT NULL REFERENCES ORDERS (ID))",
+		"INSERT INTO CUSTOMER VALUES (1, 'a')",
+		"INSERT INTO ORDERS VALUES (1, 1), (2, NULL)",
+		"INSERT INTO LINE VALUES (1, 1), (2, 1), (3, 1)")
+
+	context := testContext(t, app, nil, "admin")
+	context["expand"] = "CUSTOMER,LINE"
+	context["expand_limit"] = int64(2)
+	rows, _, err := dbo.ListMapTyped("ORDERS", "*", nil, "ID", "", 0, 10, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer, _ := rows[0]["CUSTOMER"].(map[string]interface{})
+	if customer == nil || customer["NAME"] != "a" || rows[1]["CUSTOMER"] != nil {
+		t.Fatal("Expected the customer of the first order only:", rows)
+	}
+	lines, _ := rows[0]["LINE"].([]map[string]interface{})
+	if len(lines) != 2 || lines[0]["ID"] != int64(1) {
+		t.Fatal("Expected the first lines of the order:", rows[0]["LINE"])
+	}
+	if lines, _ := rows[1]["LINE"].([]map[string]interface{}); len(lines) != 0 {
+		t.Fatal("Expected no lines of the second order:", rows[1]["LINE"])
+	}
+
+	// The related table is checked against the user's roles.
+	context = testContext(t, app, nil, "clerk")
+	context["expand"] = "CUSTOMER"
+	row, err := dbo.LoadTyped("ORDERS", "1", "*", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer, _ := row["CUSTOMER"].(map[string]interface{}); customer == nil || customer["ID"] != int64(1) {
+		t.Fatal("Expected the customer of the order:", row)
+	}
+	context = testContext(t, app, nil, "clerk")
+	context["expand"] = "LINE"
+	_, err = dbo.LoadTyped("ORDERS", "1", "*", context)
+	requireStatus(t, err, 403)
+
+	// The columns of the relation must be selected.
+	context = testContext(t, app, nil, "admin")
+	context["expand"] = "CUSTOMER"
+	_, _, err = dbo.ListMapTyped("ORDERS", "ID", nil, "", "", 0, 10, context)
+	requireStatus(t, err, 400)
+}
@@ -0,0 +1,228 @@
+// export
+package websql
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lists and query results can be exported as CSV or TSV, asked for with
+// format= or the Accept header. The options are:
+//
+//	delimiter: the field separator, one character or "tab", "," for CSV
+//	quote: minimal, all or none, minimal by default
+//	header: 0 leaves out the header row
+//	bom: 1 starts the file with a UTF-8 byte order mark, for Excel
+//	filename: the download name, the table or query name by default
+//	escape: 0 leaves values starting with =, +, -, @, tab or CR as they
+//	are, by default they get a ' in front so spreadsheets do not run them
+//	as formulas. Numbers are left alone.
+//
+// Lines end with CRLF. An error after the first row is reported in the
+// X-Stream-Error trailer, and in a last line starting with #ERROR, as a
+// spreadsheet ignores the trailer.
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"tsv":    "text/tab-separated-values; charset=utf-8",
+	"ndjson": "application/x-ndjson; charset=utf-8",
+}
+
+var exportFilenameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type csvRowWriter struct {
+	w         http.ResponseWriter
+	format    string
+	delimiter string
+	quote     string
+	header    bool
+	bom       bool
+	escape    bool
+	filename  string
+	started   bool
+	rows      int
+}
+
+func newCsvRowWriter(r *http.Request, w http.ResponseWriter, format string, name string) (*csvRowWriter, error) {
+	query := r.URL.Query()
+	ret := &csvRowWriter{
+		w:         w,
+		format:    format,
+		delimiter: ",",
+		quote:     "minimal",
+		header:    translateBoolParam(query.Get("header"), true),
+		bom:       translateBoolParam(query.Get("bom"), false),
+		escape:    translateBoolParam(query.Get("escape"), true),
+		filename:  name,
+	}
+	if format == "tsv" {
+		ret.delimiter = "\t"
+	}
+	delimiter, err := parseDelimiter(query.Get("delimiter"), ret.delimiter)
+	if err != nil {
+		return nil, err
+	}
+	ret.delimiter = delimiter
+	switch quote := query.Get("quote"); quote {
+	case "":
+	case "minimal", "all", "none":
+		ret.quote = quote
+	default:
+		return nil, newParamError("quote", quote, "Invalid quote mode: "+quote)
+	}
+	if filename := query.Get("filename"); filename != "" {
+		ret.filename = filename
+	}
+	return ret, nil
+}
+
+// parseDelimiter checks the delimiter= param, "tab" stands for a tab.
+func parseDelimiter(delimiter string, defaultValue string) (string, error) {
+	if delimiter == "" {
+		return defaultValue, nil
+	}
+	if delimiter == "tab" || delimiter == `\t` {
+		delimiter = "\t"
+	}
+	if len([]rune(delimiter)) != 1 || delimiter == `"` || delimiter == "\r" || delimiter == "\n" {
+		return "", newParamError("delimiter", delimiter, "Invalid delimiter: "+delimiter)
+	}
+	return delimiter, nil
+}
+
+// contentDisposition returns an attachment header for a download name,
+// adding the extension of format if missing.
+func contentDisposition(filename string, format string) string {
+	filename = strings.Trim(exportFilenameRegexp.ReplaceAllString(unquoteIdentifier(filename), "_"), "._")
+	if filename == "" {
+		filename = "export"
+	}
+	if !strings.HasSuffix(strings.ToLower(filename), "."+format) {
+		filename += "." + format
+	}
+	return `attachment; filename="` + filename + `"`
+}
+
+func (this *csvRowWriter) Started() bool {
+	return this.started
+}
+
+func (this *csvRowWriter) start() error {
+	if this.started {
+		return nil
+	}
+	this.started = true
+	this.w.Header().Set("Content-Type", exportContentTypes[this.format])
+	this.w.Header().Set("Content-Disposition", contentDisposition(this.filename, this.format))
+	this.w.Header().Set("Trailer", "X-Stream-Error")
+	if this.bom {
+		_, err := fmt.Fprint(this.w, "\ufeff")
+		return err
+	}
+	return nil
+}
+
+// csvValue formats a typed value the way spreadsheets read it.
+func csvValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999999")
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// escapeFormula keeps a spreadsheet from reading a value as a formula.
+func escapeFormula(s string) string {
+	if s == "" || !strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	return "'" + s
+}
+
+func (this *csvRowWriter) field(s string) string {
+	if this.escape {
+		s = escapeFormula(s)
+	}
+	switch this.quote {
+	case "none":
+		return s
+	case "minimal":
+		if !strings.ContainsAny(s, this.delimiter+"\"\r\n") && !strings.HasPrefix(s, " ") && !strings.HasSuffix(s, " ") {
+			return s
+		}
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+func (this *csvRowWriter) write(values []interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = this.field(csvValue(v))
+	}
+	_, err := fmt.Fprint(this.w, strings.Join(fields, this.delimiter), "\r\n")
+	if err != nil {
+		return err
+	}
+	this.rows++
+	if this.rows%streamFlushRows == 0 {
+		if flusher, ok := this.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+func (this *csvRowWriter) Headers(headers []string) error {
+	err := this.start()
+	if err != nil || !this.header {
+		return err
+	}
+	values := make([]interface{}, len(headers))
+	for i, h := range headers {
+		values[i] = h
+	}
+	return this.write(values)
+}
+
+func (this *csvRowWriter) Row(row []interface{}) error {
+	err := this.start()
+	if err != nil {
+		return err
+	}
+	return this.write(row)
+}
+
+func (this *csvRowWriter) Close(err error) error {
+	errStart := this.start()
+	if errStart != nil {
+		return errStart
+	}
+	if err != nil {
+		message := strings.Join(strings.Fields(err.Error()), " ")
+		this.w.Header().Set("X-Stream-Error", message)
+		_, errWrite := fmt.Fprint(this.w, this.field("#ERROR: "+message), "\r\n")
+		if errWrite != nil {
+			log.Println(errWrite)
+		}
+	}
+	if flusher, ok := this.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// export
+package websql
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCsvRowWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	rw, err := newCsvRowWriter(httptest.NewRequest("GET", "/api/ITEM?bom=1&filename=items", nil), w, "csv", "ITEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.Headers([]string{"ID", "NAME"})
+	rw.Row([]interface{}{int64(1), "a,b"})
+	rw.Row([]interface{}{-1.5, "=SUM(A1)"})
+	rw.Row([]interface{}{nil, `say "hi"`})
+	rw.Close(errors.New("Query\ntimed out."))
+
+	expected := "\ufeffID,NAME\r\n1,\"a,b\"\r\n-1.5,'=SUM(A1)\r\n,\"say \"\"hi\"\"\"\r\n#ERROR: Query timed out.\r\n"
+	if w.Body.String() != expected {
+		t.Fatalf("Expected the rows as CSV: %q", w.Body.String())
+	}
+	if w.Header().Get("Content-Disposition") != `attachment; filename="items.csv"` {
+		t.Fatal("Expected the download name:", w.Header())
+	}
+	if w.Header().Get("X-Stream-Error") != "Query timed out." {
+		t.Fatal("Expected the error in the trailer:", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	rw, err = newCsvRowWriter(httptest.NewRequest("GET", "/api/ITEM?header=0&escape=0&quote=all&delimiter=;", nil), w, "tsv", "ITEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.Headers([]string{"ID"})
+	rw.Row([]interface{}{"=1+1"})
+	rw.Close(nil)
+	if w.Body.String() != "\"=1+1\"\r\n" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/tab-separated-values") {
+		t.Fatalf("Expected the options applied: %q", w.Body.String())
+	}
+
+	for _, query := range []string{"delimiter=ab", `delimiter="`, "quote=some"} {
+		_, err := newCsvRowWriter(httptest.NewRequest("GET", "/api/ITEM?"+query, nil), httptest.NewRecorder(), "csv", "ITEM")
+		requireStatus(t, err, 400)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, c := range [][3]string{
+		{`"ITEM"`, "csv", `attachment; filename="ITEM.csv"`},
+		{"../../etc/passwd", "csv", `attachment; filename="etc_passwd.csv"`},
+		{"report.CSV", "csv", `attachment; filename="report.CSV"`},
+		{"", "ndjson", `attachment; filename="export.ndjson"`},
+	} {
+		if v := contentDisposition(c[0], c[1]); v != c[2] {
+			t.Fatal("Expected", c[2], "got", v)
+		}
+	}
+}
+
+func TestSqliteRestExport(t *testing.T) {
+	app := newTestApp(t, &App{Id: testRestAppId})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT)",
+		"INSERT INTO ITEM VALUES (1, 'a'), (2, '@b')")
+	apiToken := newTestRest(t, app, dbo)
+
+	w := getTestRest(t, "/api/ITEM?format=tsv&sort=ID", apiToken, testContext(t, app, nil))
+	if w.Code != 200 || w.Body.String() != "ID\tNAME\r\n1\ta\r\n2\t'@b\r\n" {
+		t.Fatalf("Expected the table as TSV: %d %q", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/api/ITEM?sort=ID&limit=1", nil)
+	r.Header.Set("api-token", apiToken)
+	r.Header.Set("user-token", testContext(t, app, nil)["user_token"].(string))
+	r.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Body.String() != "ID,NAME\r\n1,a\r\n" {
+		t.Fatalf("Expected CSV asked for by the Accept header: %q", w.Body.String())
+	}
+}
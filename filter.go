@@ -0,0 +1,581 @@
+// filter
+package websql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A filter is given in the filter parameter, which may repeat, either as JSON
+// or as an RSQL string. Repeated filters are joined with AND.
+//
+// JSON: a condition is {"field": "age", "op": "gt", "value": 30}. Conditions
+// combine as {"and": [...]}, {"or": [...]} and {"not": {...}}. A top level
+// array is the same as "and".
+//
+// RSQL: age=gt=30;(name==bob,name==alice). ";" is AND, "," is OR and binds
+// weaker, parentheses group. Values are plain or quoted with ' or ", lists
+// are (a,b,c).
+//
+// Operators, with their RSQL spelling:
+//   eq ==, ne !=, lt =lt= or <, le =le= or <=, gt =gt= or >, ge =ge= or >=,
+//   in =in=, nin =out=, like =like=, nlike =notlike=, between =between=,
+//   isnull =isnull=
+// in and nin take a list, between a list of two values, isnull true or false.
+// eq and ne with a null JSON value test for null.
+//
+// A field is a column of the table, or relation.column where relation is a
+// registered relation or a table linked to this one by a foreign key in either
+// direction. A condition on a relation holds when some related row satisfies
+// it.
+
+type filterNode struct {
+	// Op is and, or, not or one of the comparison operators.
+	Op       string
+	Field    string
+	Value    interface{}
+	Children []*filterNode
+}
+
+var filterMaxDepth = 16
+
+var filterOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"in": true, "nin": true, "like": true, "nlike": true, "between": true, "isnull": true,
+}
+
+var rsqlOps = map[string]string{
+	"==": "eq", "!=": "ne", "<": "lt", "<=": "le", ">": "gt", ">=": "ge",
+	"=lt=": "lt", "=le=": "le", "=gt=": "gt", "=ge=": "ge",
+	"=in=": "in", "=out=": "nin", "=like=": "like", "=notlike=": "nlike",
+	"=between=": "between", "=isnull=": "isnull",
+}
+
+type filterError struct {
+	message string
+}
+
+func (this *filterError) Error() string {
+	return this.message
+}
+
+func parseJsonFilter(v interface{}, depth int) (*filterNode, error) {
+	if depth > filterMaxDepth {
+		return nil, &filterError{"Filter is nested too deep."}
+	}
+	switch x := v.(type) {
+	case []interface{}:
+		return parseJsonFilter(map[string]interface{}{"and": x}, depth)
+	case map[string]interface{}:
+		for _, op := range []string{"and", "or"} {
+			if children, ok := x[op]; ok {
+				list, ok := children.([]interface{})
+				if !ok || len(list) == 0 || len(x) != 1 {
+					return nil, &filterError{fmt.Sprint("Invalid ", op, ", expected a non empty list.")}
+				}
+				node := &filterNode{Op: op}
+				for _, child := range list {
+					childNode, err := parseJsonFilter(child, depth+1)
+					if err != nil {
+						return nil, err
+					}
+					node.Children = append(node.Children, childNode)
+				}
+				return node, nil
+			}
+		}
+		if child, ok := x["not"]; ok {
+			if len(x) != 1 {
+				return nil, &filterError{"Invalid not, expected a single condition."}
+			}
+			childNode, err := parseJsonFilter(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return &filterNode{Op: "not", Children: []*filterNode{childNode}}, nil
+		}
+		field, _ := x["field"].(string)
+		op, _ := x["op"].(string)
+		op = strings.ToLower(op)
+		if field == "" {
+			return nil, &filterError{"Condition is missing its field."}
+		}
+		if !filterOps[op] {
+			return nil, &filterError{fmt.Sprint("Invalid operator: ", x["op"])}
+		}
+		return &filterNode{Op: op, Field: field, Value: x["value"]}, nil
+	}
+	return nil, &filterError{"Invalid filter, expected an object or a list."}
+}
+
+type rsqlParser struct {
+	s     string
+	pos   int
+	depth int
+}
+
+func parseRsqlFilter(s string) (*filterNode, error) {
+	p := &rsqlParser{s: s}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("Unexpected %q", p.s[p.pos:p.pos+1])
+	}
+	return node, nil
+}
+
+func (this *rsqlParser) errorf(format string, a ...interface{}) error {
+	return &filterError{fmt.Sprintf(format, a...) + fmt.Sprint(" at ", this.pos, ".")}
+}
+
+func (this *rsqlParser) skipSpace() {
+	for this.pos < len(this.s) && (this.s[this.pos] == ' ' || this.s[this.pos] == '\t') {
+		this.pos++
+	}
+}
+
+func (this *rsqlParser) peek() byte {
+	this.skipSpace()
+	if this.pos < len(this.s) {
+		return this.s[this.pos]
+	}
+	return 0
+}
+
+func (this *rsqlParser) parseOr() (*filterNode, error) {
+	return this.parseList("or", ',', this.parseAnd)
+}
+
+func (this *rsqlParser) parseAnd() (*filterNode, error) {
+	return this.parseList("and", ';', this.parseTerm)
+}
+
+func (this *rsqlParser) parseList(op string, sep byte, next func() (*filterNode, error)) (*filterNode, error) {
+	node, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if this.peek() != sep {
+		return node, nil
+	}
+	ret := &filterNode{Op: op, Children: []*filterNode{node}}
+	for this.peek() == sep {
+		this.pos++
+		node, err = next()
+		if err != nil {
+			return nil, err
+		}
+		ret.Children = append(ret.Children, node)
+	}
+	return ret, nil
+}
+
+func (this *rsqlParser) parseTerm() (*filterNode, error) {
+	if this.peek() == '(' {
+		this.depth++
+		if this.depth > filterMaxDepth {
+			return nil, &filterError{"Filter is nested too deep."}
+		}
+		this.pos++
+		node, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if this.peek() != ')' {
+			return nil, this.errorf("Expected )")
+		}
+		this.pos++
+		this.depth--
+		return node, nil
+	}
+	return this.parseComparison()
+}
+
+func (this *rsqlParser) parseComparison() (*filterNode, error) {
+	this.skipSpace()
+	start := this.pos
+	for this.pos < len(this.s) && strings.IndexByte("=!<>();, \t'\"", this.s[this.pos]) < 0 {
+		this.pos++
+	}
+	field := this.s[start:this.pos]
+	if field == "" {
+		return nil, this.errorf("Expected a field")
+	}
+
+	op := ""
+	rest := this.s[this.pos:]
+	switch {
+	case strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="), strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+		op = rest[:2]
+	case strings.HasPrefix(rest, "<"), strings.HasPrefix(rest, ">"):
+		op = rest[:1]
+	case strings.HasPrefix(rest, "="):
+		end := strings.IndexByte(rest[1:], '=')
+		if end >= 0 {
+			op = rest[:end+2]
+		}
+	}
+	name, ok := rsqlOps[strings.ToLower(op)]
+	if !ok {
+		return nil, this.errorf("Invalid operator after %s", field)
+	}
+	this.pos += len(op)
+
+	var value interface{}
+	var err error
+	if this.peek() == '(' {
+		this.pos++
+		list := []interface{}{}
+		for {
+			v, err := this.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if this.peek() != ',' {
+				break
+			}
+			this.pos++
+		}
+		if this.peek() != ')' {
+			return nil, this.errorf("Expected )")
+		}
+		this.pos++
+		value = list
+	} else {
+		value, err = this.parseValue()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if name == "isnull" {
+		switch strings.ToLower(fmt.Sprint(value)) {
+		case "true", "1":
+			value = true
+		case "false", "0":
+			value = false
+		}
+	}
+	return &filterNode{Op: name, Field: field, Value: value}, nil
+}
+
+func (this *rsqlParser) parseValue() (interface{}, error) {
+	c := this.peek()
+	if c == '\'' || c == '"' {
+		var buffer []byte
+		this.pos++
+		for this.pos < len(this.s) {
+			ch := this.s[this.pos]
+			if ch == '\\' && this.pos+1 < len(this.s) {
+				buffer = append(buffer, this.s[this.pos+1])
+				this.pos += 2
+				continue
+			}
+			if ch == c {
+				this.pos++
+				return string(buffer), nil
+			}
+			buffer = append(buffer, ch)
+			this.pos++
+		}
+		return nil, this.errorf("Unterminated string")
+	}
+	start := this.pos
+	for this.pos < len(this.s) && strings.IndexByte("();, \t'\"", this.s[this.pos]) < 0 {
+		this.pos++
+	}
+	if start == this.pos {
+		return nil, this.errorf("Expected a value")
+	}
+	return this.s[start:this.pos], nil
+}
+
+// parseFilterParam parses one filter parameter, JSON if it starts with { or [,
+// RSQL otherwise.
+func parseFilterParam(filter string) (*filterNode, error) {
+	filter = strings.TrimSpace(filter)
+	if strings.HasPrefix(filter, "{") || strings.HasPrefix(filter, "[") {
+		var v interface{}
+		err := json.Unmarshal([]byte(filter), &v)
+		if err != nil {
+			return nil, &filterError{"Invalid JSON filter: " + err.Error()}
+		}
+		return parseJsonFilter(v, 0)
+	}
+	return parseRsqlFilter(filter)
+}
+
+// filterBuilder turns a filter into a SQL predicate with ? placeholders.
+type filterBuilder struct {
+	dbo     *MySqlDataOperator
+	q       sqlQuerier
+	tableId string
+	columns map[string]string
+	context map[string]interface{}
+	args    []interface{}
+	// Related tables are resolved once per filter.
+	relations map[string]*filterRelation
+}
+
+type filterRelation struct {
+	TableId string
+	Columns map[string]string
+	// Join is the predicate linking a row of the relation, aliased R, to the
+	// row of the filtered table, and limiting it to the rows the user may
+	// read, whose arguments are JoinArgs.
+	Join     string
+	JoinArgs []interface{}
+}
+
+func (this *filterBuilder) build(node *filterNode) (string, error) {
+	switch node.Op {
+	case "and", "or":
+		parts := []string{}
+		for _, child := range node.Children {
+			part, err := this.build(child)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(node.Op)+" ") + ")", nil
+	case "not":
+		part, err := this.build(node.Children[0])
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + part, nil
+	}
+
+	d := this.dbo.GetDialect()
+	refs := strings.Split(node.Field, ".")
+	_, table := splitTableId(this.tableId)
+	if len(refs) == 2 && !strings.EqualFold(refs[0], table) {
+		relation, err := this.relation(refs[0])
+		if err != nil {
+			return "", err
+		}
+		_, column, err := resolveColumn("filter", refs[1], relation.TableId, relation.Columns, d)
+		if err != nil {
+			return "", err
+		}
+		this.args = append(this.args, relation.JoinArgs...)
+		predicate, err := this.comparison("R."+column, node)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint("EXISTS (SELECT 1 FROM ", relation.TableId, " R WHERE ", relation.Join, " AND ", predicate, ")"), nil
+	}
+	_, column, err := resolveColumn("filter", node.Field, this.tableId, this.columns, d)
+	if err != nil {
+		return "", err
+	}
+	return this.comparison(column, node)
+}
+
+func (this *filterBuilder) comparison(column string, node *filterNode) (string, error) {
+	for _, v := range append([]interface{}{node.Value}, filterList(node.Value)...) {
+		switch v.(type) {
+		case map[string]interface{}:
+			return "", &filterError{"Invalid value of " + node.Field + ", objects are not allowed."}
+		}
+	}
+	list, isList := node.Value.([]interface{})
+	if isList && node.Op != "in" && node.Op != "nin" && node.Op != "between" {
+		return "", &filterError{"Invalid value of " + node.Field + ", " + node.Op + " takes a single value."}
+	}
+	switch node.Op {
+	case "eq", "ne":
+		if node.Value == nil {
+			if node.Op == "eq" {
+				return column + " IS NULL", nil
+			}
+			return column + " IS NOT NULL", nil
+		}
+		this.args = append(this.args, node.Value)
+		if node.Op == "eq" {
+			return column + "=?", nil
+		}
+		return column + "<>?", nil
+	case "lt", "le", "gt", "ge", "like", "nlike":
+		if node.Value == nil {
+			return "", &filterError{"Missing value of " + node.Field + "."}
+		}
+		this.args = append(this.args, node.Value)
+		return column + map[string]string{
+			"lt": "<?", "le": "<=?", "gt": ">?", "ge": ">=?", "like": " LIKE ?", "nlike": " NOT LIKE ?",
+		}[node.Op], nil
+	case "in", "nin":
+		if !isList {
+			list = []interface{}{node.Value}
+		}
+		if len(list) == 0 || node.Value == nil {
+			return "", &filterError{"Missing values of " + node.Field + "."}
+		}
+		qms := strings.TrimSuffix(strings.Repeat("?,", len(list)), ",")
+		this.args = append(this.args, list...)
+		if node.Op == "in" {
+			return column + " IN (" + qms + ")", nil
+		}
+		return column + " NOT IN (" + qms + ")", nil
+	case "between":
+		if len(list) != 2 {
+			return "", &filterError{"Invalid value of " + node.Field + ", between takes two values."}
+		}
+		this.args = append(this.args, list...)
+		return column + " BETWEEN ? AND ?", nil
+	case "isnull":
+		isNull, ok := node.Value.(bool)
+		if !ok {
+			return "", &filterError{"Invalid value of " + node.Field + ", isnull takes true or false."}
+		}
+		if isNull {
+			return column + " IS NULL", nil
+		}
+		return column + " IS NOT NULL", nil
+	}
+	return "", &filterError{"Invalid operator: " + node.Op}
+}
+
+func filterList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	return nil
+}
+
+// relation resolves a related table of the filtered table. The caller must be
+// allowed to read it.
+func (this *filterBuilder) relation(name string) (*filterRelation, error) {
+	key := strings.ToUpper(name)
+	if relation, ok := this.relations[key]; ok {
+		return relation, nil
+	}
+	found, err := this.dbo.findRelation(this.q, this.tableId, name)
+	if err != nil {
+		return nil, err
+	}
+	err = this.dbo.checkReadable(found.Table, this.context)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := this.dbo.tableColumns(this.q, found.Table)
+	if err != nil {
+		return nil, err
+	}
+	relation := &filterRelation{
+		TableId: found.Table,
+		Columns: readableColumns(found.Table, columns, this.context),
+		Join:    found.join(this.tableId, "R", this.dbo.GetDialect()),
+	}
+	if deleted := this.dbo.softDeleteFilter(found.Table, "R", this.context); deleted != "" {
+		relation.Join = fmt.Sprint(relation.Join, " AND ", deleted)
+	}
+	policy, policyArgs, err := policyPredicate(found.Table, false, this.context)
+	if err != nil {
+		return nil, err
+	}
+	if policy != "" {
+		relation.Join = fmt.Sprint(relation.Join, " AND ", policy)
+		relation.JoinArgs = policyArgs
+	}
+	this.relations[key] = relation
+	return relation, nil
+}
+
+// checkReadable runs the BeforeMetaData hooks of a table, which decide whether
+// the caller may see the table at all.
+func (this *MySqlDataOperator) checkReadable(tableId string, context map[string]interface{}) error {
+	db, err := this.GetConn()
+	if err != nil {
+		return err
+	}
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeMetaData(tableId, db, context)
+		if err != nil {
+			return err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeMetaData(tableId, db, context)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFilters builds the WHERE clause of a list request from its filter
+// parameters. The values are bound, their arguments are stored in
+// context["where_args"] for the list and count queries. Interceptors that add
+// predicates with placeholders to the clause append their arguments there.
+// listWhere returns the where clause a list starts with, before the
+// interceptors and the filters add to it.
+func (this *MySqlDataOperator) listWhere(tableId string, context map[string]interface{}) string {
+	context["where_args"] = []interface{}{}
+	where := " WHERE 1=1 "
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		where = fmt.Sprint(where, "AND ", deleted, " ")
+	}
+	return where
+}
+
+// parseFilters adds the filters to where, their args after the where_args
+// already in the context.
+func (this *MySqlDataOperator) parseFilters(q sqlQuerier, tableId string, where string, filters []string, context map[string]interface{}) (string, error) {
+	// Related tables are filtered by the user's read policies, columns by
+	// the user's column rules.
+	loadUserClaims(context)
+	if len(filters) == 0 {
+		return where, nil
+	}
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return "", err
+	}
+	builder := &filterBuilder{
+		dbo:       this,
+		q:         q,
+		tableId:   tableId,
+		columns:   readableColumns(tableId, columns, context),
+		context:   context,
+		args:      append([]interface{}{}, whereArgs(context)...),
+		relations: map[string]*filterRelation{},
+	}
+	for _, filter := range filters {
+		if strings.TrimSpace(filter) == "" {
+			continue
+		}
+		node, err := parseFilterParam(filter)
+		if err == nil {
+			var predicate string
+			predicate, err = builder.build(node)
+			where = fmt.Sprint(where, "AND ", predicate, " ")
+		}
+		if fe, ok := err.(*filterError); ok {
+			return "", newParamError("filter", filter, fe.message)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	context["where_args"] = builder.args
+	return where, nil
+}
+
+func whereArgs(context map[string]interface{}) []interface{} {
+	if args, ok := context["where_args"].([]interface{}); ok {
+		return args
+	}
+	return []interface{}{}
+}
@@ -0,0 +1,83 @@
+// filter
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilterParam(t *testing.T) {
+	cases := []struct {
+		filter   string
+		expected *filterNode
+	}{
+		{"AGE=gt=30", &filterNode{Op: "gt", Field: "AGE", Value: "30"}},
+		{`{"field": "AGE", "op": "gt", "value": 30}`, &filterNode{Op: "gt", Field: "AGE", Value: float64(30)}},
+		{"NAME=in=(a,'b c')", &filterNode{Op: "in", Field: "NAME", Value: []interface{}{"a", "b c"}}},
+		{"A==1;B==2,C==3", &filterNode{Op: "or", Children: []*filterNode{
+			{Op: "and", Children: []*filterNode{
+				{Op: "eq", Field: "A", Value: "1"},
+				{Op: "eq", Field: "B", Value: "2"},
+			}},
+			{Op: "eq", Field: "C", Value: "3"},
+		}}},
+		{`[{"field": "A", "op": "eq", "value": null}]`, &filterNode{Op: "and", Children: []*filterNode{
+			{Op: "eq", Field: "A"},
+		}}},
+	}
+	for _, c := range cases {
+		node, err := parseFilterParam(c.filter)
+		if err != nil {
+			t.Error(c.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(node, c.expected) {
+			t.Errorf("Expected %+v for %s, got %+v", c.expected, c.filter, node)
+		}
+	}
+	for _, filter := range []string{"A=", "A==1;", "(A==1", `{"and": []}`, `{"field": "A", "op": "near", "value": 1}`, "{"} {
+		if _, err := parseFilterParam(filter); err == nil {
+			t.Error("Expected an error for", filter)
+		}
+	}
+}
+
+func TestSqliteFilters(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE CUSTOMER (ID INTEGER PRIMARY KEY, NAME TEXT, AGE INTEGER, NOTE TEXT)",
+		"CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, CUSTOMER_ID INTEGER REFERENCES CUSTOMER (ID), TOTAL INTEGER)",
+		"INSERT INTO CUSTOMER VALUES (1, 'alice', 30, NULL), (2, 'bob', 40, 'vip'), (3, 'carol', 50, NULL)",
+		"INSERT INTO ORDERS VALUES (1, 1, 10), (2, 2, 500), (3, 2, 20)")
+
+	cases := []struct {
+		filter   []string
+		expected []interface{}
+	}{
+		{[]string{"AGE=gt=30"}, []interface{}{int64(2), int64(3)}},
+		{[]string{"AGE>=40;NAME==bob,NAME==alice"}, []interface{}{int64(1), int64(2)}},
+		{[]string{"NAME=like=%o%", "AGE=between=(20,45)"}, []interface{}{int64(2)}},
+		{[]string{"NOTE=isnull=true"}, []interface{}{int64(1), int64(3)}},
+		{[]string{`{"not": {"field": "name", "op": "in", "value": ["alice", "bob"]}}`}, []interface{}{int64(3)}},
+		{[]string{`{"field": "NOTE", "op": "ne", "value": null}`}, []interface{}{int64(2)}},
+		// A related row in either direction of the foreign key.
+		{[]string{"ORDERS.TOTAL=gt=100"}, []interface{}{int64(2)}},
+		// The value is bound, not spliced into the query.
+		{[]string{`{"field": "NAME", "op": "eq", "value": "x' OR 1=1 --"}`}, []interface{}{}},
+	}
+	for _, c := range cases {
+		ids := listIds(t, dbo, "CUSTOMER", c.filter, "ID", testContext(t, app, nil))
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Error("Expected", c.expected, "for", c.filter, "got", ids)
+		}
+	}
+	ids := listIds(t, dbo, "ORDERS", []string{"CUSTOMER.NAME==bob"}, "ID", testContext(t, app, nil))
+	if !reflect.DeepEqual(ids, []interface{}{int64(2), int64(3)}) {
+		t.Error("Expected the orders of bob, got", ids)
+	}
+
+	for _, filter := range []string{"MISSING==1", "SUPPLIER.NAME==x", "AGE=between=(1)", `{"field": "AGE", "op": "eq", "value": {"a": 1}}`} {
+		_, _, err := dbo.ListMapTyped("CUSTOMER", "*", []string{filter}, "", "", 0, 10, testContext(t, app, nil))
+		requireStatus(t, err, 400)
+	}
+}
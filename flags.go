@@ -27,6 +27,12 @@ type CliService struct {
 	MailPort     int
 	MailUsername string
 	MailPassword string
+	// JwtKeyRotation is the cron spec user token signing keys are rotated on,
+	// never if empty. JwtKeyAlg is RS256 or ES256. JwtLegacyHs256 signs user
+	// tokens with the secret while there is no key.
+	JwtKeyRotation string
+	JwtKeyAlg      string
+	JwtLegacyHs256 bool
 }
 
 func (this *CliService) Flags() []cli.Flag {
@@ -90,6 +96,22 @@ func (this *CliService) Flags() []cli.Flag {
 			Usage:       "secret password for server client communication.",
 			Destination: &this.Secret,
 		},
+		cli.StringFlag{
+			Name:        "jwt_key_rotation",
+			Usage:       "cron spec to rotate the user token signing key on, e.g. @weekly, never if empty",
+			Destination: &this.JwtKeyRotation,
+		},
+		cli.StringFlag{
+			Name:        "jwt_key_alg",
+			Value:       "ES256",
+			Usage:       "algorithm of the user token signing keys, RS256 or ES256",
+			Destination: &this.JwtKeyAlg,
+		},
+		cli.BoolFlag{
+			Name:        "jwt_legacy_hs256",
+			Usage:       "true to sign user tokens with HS256 and the secret instead of making a signing key, false by default",
+			Destination: &this.JwtLegacyHs256,
+		},
 	}
 }
 
@@ -188,6 +210,24 @@ func (this *CliService) LoadConfig(file string, c *cli.Context) {
 			this.Secret = v
 		}
 	}
+	if !c.IsSet("jwt_key_rotation") {
+		v, err := jqConf.QueryToString("jwt_key_rotation")
+		if err == nil {
+			this.JwtKeyRotation = v
+		}
+	}
+	if !c.IsSet("jwt_key_alg") {
+		v, err := jqConf.QueryToString("jwt_key_alg")
+		if err == nil {
+			this.JwtKeyAlg = v
+		}
+	}
+	if !c.IsSet("jwt_legacy_hs256") {
+		v, err := jqConf.QueryToBool("jwt_legacy_hs256")
+		if err == nil {
+			this.JwtLegacyHs256 = v
+		}
+	}
 	if !c.IsSet("mail_port") {
 		v, err := jqConf.QueryToInt64("mail_port")
 		if err == nil {
@@ -8,6 +8,8 @@ import (
 func init() {
 	tableId := "forget_password"
 	Websql.Interceptors.RegisterDataInterceptor(tableId, 0, &ForgetPasswordInterceptor{Id: tableId})
+	// The verification code goes out by mail only, the password never.
+	Websql.RegisterColumnMask(tableId, "hide", "VERIFICATION_CODE", "PASSWORD")
 }
 
 type ForgetPasswordInterceptor struct {
@@ -22,6 +24,5 @@ func (this *ForgetPasswordInterceptor) AfterExec(resourceId string, script strin
 			SendMail("UpRun User Verification", userMap[0]["VERIFICATION_CODE"], userMap[0]["EMAIL"])
 		}
 	}
-	(*data)[0][3] = ""
 	return nil
 }
@@ -23,7 +23,7 @@ func (this *GlobalLocalInterceptor) executeLocalInterceptor(tx *sql.Tx, db *sql.
 	scripts := sqlScript
 	replaceContext := buildReplaceContext(context)
 
-	_, err = batchExecuteTx(tx, db, &scripts, queryParams, data, false, "", replaceContext)
+	_, err = batchExecuteTx(tx, db, &scripts, queryParams, data, false, "", replaceContext, appDialect(context["app"].(*App)))
 	if err != nil {
 		return err
 	}
@@ -31,7 +31,7 @@ func (this *GlobalLocalInterceptor) executeLocalInterceptor(tx *sql.Tx, db *sql.
 }
 
 func (this *GlobalLocalInterceptor) commonBefore(tx *sql.Tx, db *sql.DB, resourceId string, context map[string]interface{}, action string, queryParams map[string]string, data [][]interface{}) error {
-	rts := strings.Split(strings.Replace(resourceId, "`", "", -1), ".")
+	rts := strings.Split(unquoteIdentifier(resourceId), ".")
 	resourceId = rts[len(rts)-1]
 	app := context["app"].(*App)
 	for _, li := range app.LocalInterceptors {
@@ -46,7 +46,7 @@ func (this *GlobalLocalInterceptor) commonBefore(tx *sql.Tx, db *sql.DB, resourc
 }
 
 func (this *GlobalLocalInterceptor) commonAfter(tx *sql.Tx, db *sql.DB, resourceId string, context map[string]interface{}, action string, queryParams map[string]string, data [][]interface{}) error {
-	rts := strings.Split(strings.Replace(resourceId, "`", "", -1), ".")
+	rts := strings.Split(unquoteIdentifier(resourceId), ".")
 	resourceId = rts[len(rts)-1]
 	app := context["app"].(*App)
 	for _, li := range app.LocalInterceptors {
@@ -39,7 +39,7 @@ func (this *GlobalRemoteInterceptor) executeRemoteInterceptor(tx *sql.Tx, db *sq
 	if err != nil {
 		return err
 	}
-	_, err = batchExecuteTx(tx, db, &scripts, queryParams, params, false, "", replaceContext)
+	_, err = batchExecuteTx(tx, db, &scripts, queryParams, params, false, "", replaceContext, appDialect(context["app"].(*App)))
 	if err != nil {
 		return err
 	}
@@ -47,7 +47,7 @@ func (this *GlobalRemoteInterceptor) executeRemoteInterceptor(tx *sql.Tx, db *sq
 }
 
 func (this *GlobalRemoteInterceptor) commonBefore(tx *sql.Tx, db *sql.DB, resourceId string, context map[string]interface{}, action string, data interface{}) error {
-	rts := strings.Split(strings.Replace(resourceId, "`", "", -1), ".")
+	rts := strings.Split(unquoteIdentifier(resourceId), ".")
 	resourceId = rts[len(rts)-1]
 	app := context["app"].(*App)
 	for _, ri := range app.RemoteInterceptors {
@@ -66,7 +66,7 @@ func (this *GlobalRemoteInterceptor) commonBefore(tx *sql.Tx, db *sql.DB, resour
 }
 
 func (this *GlobalRemoteInterceptor) commonAfter(tx *sql.Tx, db *sql.DB, resourceId string, context map[string]interface{}, action string, data interface{}) error {
-	rts := strings.Split(strings.Replace(resourceId, "`", "", -1), ".")
+	rts := strings.Split(unquoteIdentifier(resourceId), ".")
 	resourceId = rts[len(rts)-1]
 	app := context["app"].(*App)
 	for _, ri := range app.RemoteInterceptors {
@@ -85,7 +85,7 @@ func (this *GlobalRemoteInterceptor) commonAfter(tx *sql.Tx, db *sql.DB, resourc
 }
 
 func (this *GlobalRemoteInterceptor) createPayload(target string, action string, data interface{}) (string, error) {
-	rts := strings.Split(strings.Replace(target, "`", "", -1), ".")
+	rts := strings.Split(unquoteIdentifier(target), ".")
 	target = rts[len(rts)-1]
 	m := map[string]interface{}{
 		"target": target,
@@ -4,10 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net/http"
 	"strings"
 	"time"
-
-	"github.com/dvsekhvalnov/jose2go"
 )
 
 func init() {
@@ -24,7 +23,7 @@ func checkAccessPermission(targets, tableId, mode, op string) bool {
 	if targets == "*" {
 		tableMatch = true
 	} else {
-		ts := strings.Split(strings.Replace(tableId, "`", "", -1), ".")
+		ts := strings.Split(unquoteIdentifier(tableId), ".")
 		tableName := ts[len(ts)-1]
 
 		targetsArray := strings.Split(targets, ",")
@@ -46,11 +45,10 @@ func checkAccessPermission(targets, tableId, mode, op string) bool {
 	return true
 }
 
-func checkProjectToken(context map[string]interface{}, tableId string, op string) error {
-
-	token := context["api_token"].(string)
+// contextApp returns the app of a request, caching it in context["app"].
+func contextApp(context map[string]interface{}) *App {
 	if _, ok := context["app"]; !ok {
-		appId := context["app_id"].(string)
+		appId, _ := context["app_id"].(string)
 		for _, a := range Websql.masterData.Apps {
 			if a.Id == appId {
 				context["app"] = a
@@ -58,8 +56,17 @@ func checkProjectToken(context map[string]interface{}, tableId string, op string
 			}
 		}
 	}
+	app, _ := context["app"].(*App)
+	return app
+}
+
+func checkProjectToken(context map[string]interface{}, tableId string, op string) error {
 
-	app := context["app"].(*App)
+	token := context["api_token"].(string)
+	app := contextApp(context)
+	if app == nil {
+		return errors.New("Authentication failed.")
+	}
 
 	for _, t := range app.Tokens {
 		if t.AppId == app.Id && t.Id == token {
@@ -77,20 +84,34 @@ func checkProjectToken(context map[string]interface{}, tableId string, op string
 func checkUserToken(context map[string]interface{}) error {
 	if userToken, ok := context["user_token"]; ok {
 		if v, ok := userToken.(string); ok {
-			sharedKey := []byte(Websql.service.Secret)
-
-			payload, _, err := jose.Decode(v, sharedKey)
+			payload, err := decodeJwtToken(v)
 			if err != nil {
 				return err
 			}
 			userInfo := map[string]interface{}{}
 			json.Unmarshal([]byte(payload), &userInfo)
+			if exp, ok := userInfo["exp"].(float64); !ok || int64(exp) < time.Now().Unix() {
+				return errors.New("User token expired.")
+			}
+			if jti, ok := userInfo["jti"].(string); ok && tokenRevoked(jti) {
+				return errors.New("User token revoked.")
+			}
+			// Roles are per app, a token of another app grants nothing here.
+			if aud, _ := userInfo["aud"].(string); aud == "" || aud != context["app_id"] {
+				return errors.New("User token is not valid in this app.")
+			}
+			context["user_claims"] = userInfo
 
 			if email, ok := userInfo["email"]; ok {
 				context["user_email"] = email
 			} else {
 				context["user_email"] = userInfo["EMAIL"]
 			}
+			roles, ok := userInfo["roles"]
+			if !ok {
+				roles = userInfo["ROLES"]
+			}
+			context["user_roles"] = parseRolesClaim(roles)
 
 			return nil
 		} else {
@@ -100,15 +121,100 @@ func checkUserToken(context map[string]interface{}) error {
 	return errors.New("No user token.")
 }
 
+// loadUserClaims reads the user token into context ahead of the Before hooks,
+// for the params of a request that are checked against the user's policies
+// and column rules. An invalid token is left for the hooks to reject.
+func loadUserClaims(context map[string]interface{}) {
+	if _, ok := context["user_claims"]; ok {
+		return
+	}
+	if _, ok := context["user_token"]; ok {
+		checkUserToken(context)
+	}
+}
+
+// parseRolesClaim reads the role names of a user token, either an array or a
+// comma separated string as a login query returns it.
+func parseRolesClaim(roles interface{}) []string {
+	ret := []string{}
+	switch v := roles.(type) {
+	case string:
+		for _, role := range strings.Split(v, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				ret = append(ret, role)
+			}
+		}
+	case []interface{}:
+		for _, role := range v {
+			if r, ok := role.(string); ok && strings.TrimSpace(r) != "" {
+				ret = append(ret, strings.TrimSpace(r))
+			}
+		}
+	}
+	return ret
+}
+
+// checkRolePermission makes sure one of the user's roles grants op on a table
+// or query. In an app without roles every user may do what the API token
+// allows.
+func checkRolePermission(context map[string]interface{}, resourceId string, op string) error {
+	app := contextApp(context)
+	if app == nil || len(app.Roles) == 0 {
+		return nil
+	}
+	userRoles, _ := context["user_roles"].([]string)
+	for _, role := range app.Roles {
+		if role.AppId != app.Id || role.Status == "disabled" {
+			continue
+		}
+		for _, userRole := range userRoles {
+			if role.Name != userRole {
+				continue
+			}
+			for _, p := range role.Permissions {
+				if checkAccessPermission(p.Target, resourceId, p.Mode, op) {
+					return nil
+				}
+			}
+		}
+	}
+	return &RequestError{
+		Status:  http.StatusForbidden,
+		Code:    "forbidden",
+		Value:   unquoteIdentifier(resourceId),
+		Message: "Permission denied: " + op + " " + unquoteIdentifier(resourceId),
+	}
+}
+
 func (this *GlobalTokenInterceptor) BeforeCreate(resourceId string, db *sql.DB, context map[string]interface{}, data []map[string]interface{}) error {
 	err := checkProjectToken(context, resourceId, "create")
 	if err != nil {
 		return err
 	}
+	if mode, _ := context["mode"].(string); mode == "upsert" {
+		err = checkProjectToken(context, resourceId, "update")
+		if err != nil {
+			return err
+		}
+	}
 	err = checkUserToken(context)
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "create")
+	if err != nil {
+		return err
+	}
+	if mode, _ := context["mode"].(string); mode == "upsert" {
+		err = checkRolePermission(context, resourceId, "update")
+		if err != nil {
+			return err
+		}
+	}
+	err = checkColumnWrite(resourceId, context, data)
+	if err != nil {
+		return err
+	}
 	for _, data1 := range data {
 		data1["CREATED_AT"] = time.Now().UTC()
 		data1["UPDATED_AT"] = time.Now().UTC()
@@ -131,9 +237,26 @@ func (this *GlobalTokenInterceptor) BeforeLoad(resourceId string, db *sql.DB, fi
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "load")
+	if err != nil {
+		return err
+	}
 	return checkProjectToken(context, resourceId, "load")
 }
 func (this *GlobalTokenInterceptor) AfterLoad(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data map[string]string) error {
+	if _, typed := context["typed"]; typed {
+		// The typed hook masks the rows.
+		return nil
+	}
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		masker.maskStringMap(data)
+	}
+	return nil
+}
+func (this *GlobalTokenInterceptor) AfterLoadTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data map[string]interface{}) error {
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		masker.maskMap(data)
+	}
 	return nil
 }
 func (this *GlobalTokenInterceptor) BeforeUpdate(resourceId string, db *sql.DB, context map[string]interface{}, data []map[string]interface{}) error {
@@ -145,6 +268,14 @@ func (this *GlobalTokenInterceptor) BeforeUpdate(resourceId string, db *sql.DB,
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "update")
+	if err != nil {
+		return err
+	}
+	err = checkColumnWrite(resourceId, context, data)
+	if err != nil {
+		return err
+	}
 	for _, data1 := range data {
 		data1["UPDATED_AT"] = time.Now().UTC()
 		if userId, found := context["user_email"]; found {
@@ -164,6 +295,10 @@ func (this *GlobalTokenInterceptor) BeforeDuplicate(resourceId string, db *sql.D
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "duplicate")
+	if err != nil {
+		return err
+	}
 	return checkProjectToken(context, resourceId, "duplicate")
 }
 func (this *GlobalTokenInterceptor) AfterDuplicate(resourceId string, db *sql.DB, context map[string]interface{}, id []string, newId []string) error {
@@ -174,19 +309,55 @@ func (this *GlobalTokenInterceptor) BeforeDelete(resourceId string, db *sql.DB,
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "delete")
+	if err != nil {
+		return err
+	}
 	return checkProjectToken(context, resourceId, "delete")
 }
 func (this *GlobalTokenInterceptor) AfterDelete(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error {
 	return nil
 }
+func (this *GlobalTokenInterceptor) BeforeRestore(resourceId string, db *sql.DB, context map[string]interface{}, id []string) error {
+	err := checkUserToken(context)
+	if err != nil {
+		return err
+	}
+	err = checkRolePermission(context, resourceId, "delete")
+	if err != nil {
+		return err
+	}
+	return checkProjectToken(context, resourceId, "delete")
+}
 func (this *GlobalTokenInterceptor) BeforeListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error {
 	err := checkUserToken(context)
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "list")
+	if err != nil {
+		return err
+	}
 	return checkProjectToken(context, resourceId, "list")
 }
 func (this *GlobalTokenInterceptor) AfterListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data *[]map[string]string, total int64) error {
+	if _, typed := context["typed"]; typed {
+		// The typed hook masks the rows.
+		return nil
+	}
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		for _, row := range *data {
+			masker.maskStringMap(row)
+		}
+	}
+	return nil
+}
+func (this *GlobalTokenInterceptor) AfterListMapTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, data *[]map[string]interface{}, total int64) error {
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		for _, row := range *data {
+			masker.maskMap(row)
+		}
+	}
 	return nil
 }
 func (this *GlobalTokenInterceptor) BeforeListArray(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error {
@@ -194,11 +365,39 @@ func (this *GlobalTokenInterceptor) BeforeListArray(resourceId string, db *sql.D
 	if err != nil {
 		return err
 	}
+	err = checkRolePermission(context, resourceId, "list")
+	if err != nil {
+		return err
+	}
 	return checkProjectToken(context, resourceId, "list")
 }
 func (this *GlobalTokenInterceptor) AfterListArray(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]string, total int64) error {
+	if _, typed := context["typed"]; typed {
+		// The typed hook masks the rows.
+		return nil
+	}
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		masker.maskStringArray(headers, data)
+	}
+	return nil
+}
+func (this *GlobalTokenInterceptor) AfterListArrayTyped(resourceId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string, data *[][]interface{}, total int64) error {
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		masker.maskArray(headers, data)
+	}
 	return nil
 }
+func (this *GlobalTokenInterceptor) BeforeMetaData(resourceId string, db *sql.DB, context map[string]interface{}) error {
+	err := checkUserToken(context)
+	if err != nil {
+		return err
+	}
+	err = checkRolePermission(context, resourceId, "load")
+	if err != nil {
+		return err
+	}
+	return checkProjectToken(context, resourceId, "load")
+}
 func (this *GlobalTokenInterceptor) BeforeExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}) error {
 	if appId, ok := context["app_id"].(string); ok {
 		for iApp, _ := range Websql.masterData.Apps {
@@ -210,6 +409,10 @@ func (this *GlobalTokenInterceptor) BeforeExec(resourceId string, script string,
 							if err != nil {
 								return err
 							}
+							err = checkRolePermission(context, resourceId, "exec")
+							if err != nil {
+								return err
+							}
 						}
 						break
 					}
@@ -220,5 +423,8 @@ func (this *GlobalTokenInterceptor) BeforeExec(resourceId string, script string,
 	return checkProjectToken(context, resourceId, "exec")
 }
 func (this *GlobalTokenInterceptor) AfterExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}, data *[][]interface{}) error {
+	if masker := newColumnMasker(resourceId, context); masker != nil {
+		masker.maskExecResults(data)
+	}
 	return nil
 }
@@ -0,0 +1,123 @@
+// global_token_interceptor
+package websql
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRolesClaim(t *testing.T) {
+	if roles := parseRolesClaim(" clerk, ,admin"); !reflect.DeepEqual(roles, []string{"clerk", "admin"}) {
+		t.Fatal("Expected the roles of a string claim:", roles)
+	}
+	if roles := parseRolesClaim([]interface{}{"clerk", 1, " "}); !reflect.DeepEqual(roles, []string{"clerk"}) {
+		t.Fatal("Expected the roles of an array claim:", roles)
+	}
+	if roles := parseRolesClaim(nil); len(roles) != 0 {
+		t.Fatal("Expected no roles:", roles)
+	}
+}
+
+func TestSqliteRoles(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", Roles: []*Role{
+		{Id: "1", Name: "reader", AppId: "app", Permissions: []*Permission{{Target: "ITEM", Mode: "list,load"}}},
+		{Id: "2", Name: "writer", AppId: "app", Permissions: []*Permission{{Target: "ITEM", Mode: "*"}}},
+		{Id: "3", Name: "retired", AppId: "app", Status: "disabled", Permissions: []*Permission{{Target: "*", Mode: "*"}}},
+	}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, "+testAuditColumns+")",
+		"CREATE TABLE SECRET (ID INTEGER PRIMARY KEY)",
+		"INSERT INTO ITEM (ID, NAME) VALUES (1, 'a')")
+
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil, "reader")); len(ids) != 1 {
+		t.Fatal("Expected the reader to list:", ids)
+	}
+	if _, err := dbo.LoadTyped("ITEM", "1", "*", testContext(t, app, nil, "reader")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "b"}}, testContext(t, app, nil, "reader"))
+	requireStatus(t, err, 403)
+	_, err = dbo.Delete("ITEM", []string{"1"}, testContext(t, app, nil, "reader"))
+	requireStatus(t, err, 403)
+	_, _, err = dbo.ListMapTyped("SECRET", "*", nil, "", "", 0, 10, testContext(t, app, nil, "reader", "writer"))
+	requireStatus(t, err, 403)
+
+	if _, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "b"}}, testContext(t, app, nil, "writer")); err != nil {
+		t.Fatal(err)
+	}
+	// Roles also come as a comma separated claim, as a login query returns
+	// them.
+	context := testContext(t, app, map[string]interface{}{"roles": "reader,writer"})
+	if _, err := dbo.Delete("ITEM", []string{"2"}, context); err != nil {
+		t.Fatal(err)
+	}
+
+	// A disabled role grants nothing, and neither does no role.
+	_, _, err = dbo.ListMapTyped("ITEM", "*", nil, "", "", 0, 10, testContext(t, app, nil, "retired"))
+	requireStatus(t, err, 403)
+	_, _, err = dbo.ListMapTyped("ITEM", "*", nil, "", "", 0, 10, testContext(t, app, nil))
+	requireStatus(t, err, 403)
+
+	// A token of another app grants nothing here.
+	context = testContext(t, &App{Id: "other"}, nil, "writer")
+	context["app_id"] = app.Id
+	_, _, err = dbo.ListMapTyped("ITEM", "*", nil, "", "", 0, 10, context)
+	if err == nil || err.Error() != "User token is not valid in this app." {
+		t.Fatal("Expected the token of another app rejected:", err)
+	}
+
+	// In an app without roles every user may do what the API token allows.
+	app.Roles = nil
+	if ids := listIds(t, dbo, "SECRET", nil, "", testContext(t, app, nil)); len(ids) != 0 {
+		t.Fatal("Expected the table listed:", ids)
+	}
+}
+
+func TestGrantRole(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dataFile := Websql.service.DataFile
+	Websql.service.DataFile = filepath.Join(t.TempDir(), "data.json")
+	t.Cleanup(func() {
+		Websql.service.DataFile = dataFile
+	})
+	masterData := Websql.masterData
+
+	if err := masterData.AddRole(&Role{Id: "1", Name: "clerk", AppId: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := masterData.AddRole(&Role{Id: "2", Name: "clerk", AppId: "app"}); err == nil {
+		t.Fatal("Expected a duplicate role rejected.")
+	}
+	grant := func(target string, mode string) {
+		t.Helper()
+		err := masterData.GrantRole(&Role{Id: "1", AppId: "app", Permissions: []*Permission{{Target: target, Mode: mode}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	grant("ITEM", "list")
+	grant("ORDERS", "*")
+	grant("ITEM", "list,load")
+	permissions := app.Roles[0].Permissions
+	if len(permissions) != 2 || permissions[1].Target != "ITEM" || permissions[1].Mode != "list,load" {
+		t.Fatal("Expected the grant on a target replaced:", permissions)
+	}
+	grant("ORDERS", "")
+	if permissions := app.Roles[0].Permissions; len(permissions) != 1 || permissions[0].Target != "ITEM" {
+		t.Fatal("Expected the target revoked:", permissions)
+	}
+
+	if err := masterData.UpdateRole(&Role{Id: "1", AppId: "app", Name: "__not_set__", Note: "__not_set__", Status: "disabled"}); err != nil {
+		t.Fatal(err)
+	}
+	if app.Roles[0].Name != "clerk" || app.Roles[0].Status != "disabled" {
+		t.Fatal("Expected the status of the role changed only:", app.Roles[0])
+	}
+	if err := masterData.RemoveRole("1", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Roles) != 0 {
+		t.Fatal("Expected the role removed.")
+	}
+}
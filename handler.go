@@ -8,6 +8,7 @@ import (
 type Handlers struct {
 	handlerRegistry map[string]func(w http.ResponseWriter, r *http.Request)
 	DboRegistry     map[string]DataOperator
+	dboFingerprints map[string]string
 }
 
 //var handlerRegistry = make(map[string]func(w http.ResponseWriter, r *http.Request))
@@ -18,15 +18,140 @@ var RequestWrites = map[string]int{}
 var RequestReads = map[string]int{}
 
 var translateBoolParam = func(field string, defaultValue bool) bool {
-	if field == "1" {
+	if field == "1" || field == "true" {
 		return true
-	} else if field == "0" {
+	} else if field == "0" || field == "false" {
 		return false
 	} else {
 		return defaultValue
 	}
 }
 
+// writeError reports a RequestError with its status and a JSON body, anything
+// else as an internal server error.
+var writeError = func(w http.ResponseWriter, err error) {
+	if re, ok := err.(*RequestError); ok {
+		body := map[string]interface{}{
+			"err":   re.Message,
+			"code":  re.Code,
+			"param": re.Param,
+			"value": re.Value,
+		}
+		if re.Data != nil {
+			body["data"] = re.Data
+		}
+		jsonData, _ := json.Marshal(body)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(re.Status)
+		fmt.Fprint(w, string(jsonData))
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// beginWriteTx runs a write request in one transaction, unless the client
+// asks for atomic=false. The data operator commits it when the write succeeds.
+var beginWriteTx = func(r *http.Request, dbo DataOperator, context map[string]interface{}) error {
+	if !translateBoolParam(r.FormValue("atomic"), true) {
+		return nil
+	}
+	if _, ok := context["tx"]; ok {
+		return nil
+	}
+	db, err := dbo.GetConn()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	context["tx"] = tx
+	return nil
+}
+
+// decodeBody decodes the JSON body of a request. A body that is not valid JSON
+// is reported as the client's error.
+var decodeBody = func(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		return newParamError("body", "", "Invalid JSON body: "+err.Error())
+	}
+	return nil
+}
+
+// rollbackWriteTx rolls back the transaction of a failed write. The data
+// operator has usually done so already, which makes this a no-op.
+var rollbackWriteTx = func(context map[string]interface{}) {
+	rollbackContextTx(context)
+}
+
+// parseExpandParams reads the expand and expand_limit parameters of a list or
+// load into the context.
+var parseExpandParams = func(r *http.Request, context map[string]interface{}) error {
+	expand := r.FormValue("expand")
+	if expand == "" {
+		return nil
+	}
+	context["expand"] = expand
+	limit := expandDefaultLimit
+	if l := r.FormValue("expand_limit"); l != "" {
+		var err error
+		limit, err = strconv.ParseInt(l, 10, 64)
+		if err != nil || limit <= 0 {
+			return newParamError("expand_limit", l, "Invalid expand limit: "+l)
+		}
+		if limit > expandMaxLimit {
+			limit = expandMaxLimit
+		}
+	}
+	context["expand_limit"] = limit
+	return nil
+}
+
+// urlDataId returns the id in the url path. A composite key is taken from the
+// escaped path, so that escaped commas in its values survive.
+var urlDataId = func(r *http.Request, keyColumns []string) string {
+	if len(keyColumns) > 1 {
+		escapedPathData := strings.Split(r.URL.EscapedPath()[1:], "/")
+		if len(escapedPathData) > 2 {
+			return escapedPathData[2]
+		}
+	}
+	return strings.Split(r.URL.Path[1:], "/")[2]
+}
+
+// requestDataIds returns the id in the url, or the list of keys in the body of
+// a COPY, DELETE or restore request.
+var requestDataIds = func(r *http.Request, dbo DataOperator, tableId string) ([]string, error) {
+	dataIds := []string{}
+	keyColumns, err := dbo.KeyColumns(tableId)
+	if err != nil {
+		return nil, err
+	}
+	urlPathData := strings.Split(r.URL.Path[1:], "/")
+	if len(urlPathData) >= 3 && len(urlPathData[2]) > 0 {
+		return append(dataIds, urlDataId(r, keyColumns)), nil
+	}
+	var postData interface{}
+	err = decodeBody(r, &postData)
+	if err != nil {
+		return nil, err
+	}
+	postDataArray, ok := postData.([]interface{})
+	if !ok {
+		return nil, newParamError("body", "", "Error parsing post data.")
+	}
+	for _, postData := range postDataArray {
+		dataId, ok := formatKeyValue(postData, keyColumns)
+		if !ok {
+			return nil, newParamError("id", fmt.Sprint(postData), "Invalid key.")
+		}
+		dataIds = append(dataIds, dataId)
+	}
+	return dataIds, nil
+}
+
 //var convertMapOfInterfacesToMapOfStrings = func(data map[string]interface{}) (map[string]string, error) {
 //	if data == nil {
 //		return nil, errors.New("Cannot convert nil.")
@@ -169,9 +294,60 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 	urlPathData := strings.Split(urlPath[1:], "/")
 	tableId := urlPathData[1]
 
+	if tableId == "_tx" {
+		restTxFunc(w, r, dbo, context)
+		return
+	}
+	if tableId == "_batch" {
+		restBatchFunc(w, r, dbo, context)
+		return
+	}
+	if tableId == "_refresh" || tableId == "_logout" {
+		restSessionFunc(w, r, tableId, context)
+		return
+	}
+	if txId := r.Header.Get("tx-id"); txId != "" {
+		// Run the request in a transaction opened by POST /api/_tx.
+		rt, err := acquireRestTx(txId, appId, apiToken)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rt.release()
+		done := rt.watchClient(r)
+		defer done()
+		context["tx"] = rt.Tx
+		context["tx_id"] = rt.Id
+	}
+
 	switch r.Method {
 	case "GET":
-		if len(urlPathData) == 2 || len(urlPathData[2]) == 0 {
+		context["include_deleted"] = translateBoolParam(r.FormValue("include_deleted"), false)
+		if tableId == "_meta" {
+			// List the tables of the app.
+			tables, err := dbo.ListTables(context)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			jsonData, _ := json.Marshal(map[string]interface{}{
+				"data": tables,
+			})
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, string(jsonData))
+		} else if translateBoolParam(r.FormValue("meta"), false) {
+			// Describe the table.
+			meta, err := dbo.MetaData(tableId, context)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			jsonData, _ := json.Marshal(map[string]interface{}{
+				"data": meta,
+			})
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, string(jsonData))
+		} else if len(urlPathData) == 2 || len(urlPathData[2]) == 0 {
 			//List records.
 			fields := strings.ToUpper(r.FormValue("fields"))
 			sort := r.FormValue("sort")
@@ -184,6 +360,28 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 			context["case"] = c
 			filter := r.Form["filter"]
 			array := translateBoolParam(r.FormValue("array"), false)
+			legacy := translateBoolParam(r.FormValue("legacy"), false)
+			if (array || legacy) && r.FormValue("expand") != "" {
+				writeError(w, newParamError("expand", r.FormValue("expand"), "Expand is not supported with array or legacy output."))
+				return
+			}
+			err := parseExpandParams(r, context)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			count := r.FormValue("count")
+			switch count {
+			case "", "exact", "estimate", "none":
+				context["count"] = count
+			default:
+				writeError(w, newParamError("count", count, "Invalid count mode: "+count))
+				return
+			}
+			if _, ok := r.Form["cursor"]; ok {
+				// Cursor mode, an empty cursor asks for the first page.
+				context["cursor"] = r.FormValue("cursor")
+			}
 			start, err := strconv.ParseInt(s, 10, 0)
 			if err != nil {
 				start = 0
@@ -199,7 +397,7 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 			}
 			params, err := gosplitargs.SplitArgs(p, ",", false)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, err)
 				return
 			}
 			parameters := make([]interface{}, len(params))
@@ -210,39 +408,129 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 			queryParams, err := gosplitargs.SplitArgs(qp, ",", false)
 			_ = queryParams
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, err)
+				return
+			}
+			if metrics := r.FormValue("metrics"); metrics != "" {
+				// Aggregate the rows per group.
+				if array || legacy {
+					writeError(w, newParamError("metrics", metrics, "Metrics are not supported with array or legacy output."))
+					return
+				}
+				if _, ok := context["cursor"]; ok {
+					writeError(w, newParamError("cursor", r.FormValue("cursor"), "Cursor is not supported with metrics."))
+					return
+				}
+				if r.FormValue("expand") != "" {
+					writeError(w, newParamError("expand", r.FormValue("expand"), "Expand is not supported with metrics."))
+					return
+				}
+				if l == "" {
+					limit = aggregateDefaultLimit
+				}
+				data, totals, total, err := dbo.Aggregate(tableId, metrics, r.FormValue("by"), filter, sort, start, limit, context)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				jsonData, _ := json.Marshal(map[string]interface{}{
+					"data":   data,
+					"totals": totals,
+					"total":  total,
+				})
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				fmt.Fprint(w, string(jsonData))
+				return
+			}
+			stream, err := streamFormat(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if stream != "" {
+				// Write the rows as they are scanned, as JSON, NDJSON, CSV or TSV.
+				if _, ok := context["cursor"]; ok {
+					writeError(w, newParamError("cursor", r.FormValue("cursor"), "Cursor is not supported with stream."))
+					return
+				}
+				if r.FormValue("expand") != "" {
+					writeError(w, newParamError("expand", r.FormValue("expand"), "Expand is not supported with stream."))
+					return
+				}
+				if l == "" {
+					limit = streamDefaultLimit
+				}
+				context["request_ctx"] = r.Context()
+				rw, err := newStreamWriter(r, w, stream, array, tableId)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				err = dbo.ListStream(tableId, fields, filter, sort, group, start, limit, rw, context)
+				if err != nil && !rw.Started() {
+					writeError(w, err)
+					return
+				}
+				rw.Close(err)
 				return
 			}
 			var data interface{}
 			var total int64 = -1
 			m := map[string]interface{}{}
-			if array {
+			if array && legacy {
 				headers, dataArray, total, err := dbo.ListArray(tableId, fields, filter, sort, group, start, limit, context)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, err)
 					return
 				} else {
 					m["headers"] = headers
 					m["data"] = dataArray
 					m["total"] = total
 				}
-			} else {
+			} else if array {
+				headers, dataArray, total, err := dbo.ListArrayTyped(tableId, fields, filter, sort, group, start, limit, context)
+				if err != nil {
+					writeError(w, err)
+					return
+				} else {
+					m["headers"] = headers
+					m["data"] = dataArray
+					m["total"] = total
+				}
+			} else if legacy {
 				data, total, err = dbo.ListMap(tableId, fields, filter, sort, group, start, limit, context)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, err)
+					return
+				} else {
+					m["data"] = data
+					m["total"] = total
+				}
+			} else {
+				data, total, err = dbo.ListMapTyped(tableId, fields, filter, sort, group, start, limit, context)
+				if err != nil {
+					writeError(w, err)
 					return
 				} else {
 					m["data"] = data
 					m["total"] = total
 				}
 			}
+			if _, ok := context["cursor"]; ok {
+				m["next_cursor"] = context["next_cursor"]
+			}
 			jsonData, err := json.Marshal(m)
 			jsonString := string(jsonData)
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			fmt.Fprint(w, jsonString)
 		} else {
 			// Load record by id.
-			dataId := urlPathData[2]
+			keyColumns, err := dbo.KeyColumns(tableId)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			dataId := urlDataId(r, keyColumns)
 			c := r.FormValue("case")
 			context["case"] = c
 
@@ -251,29 +539,52 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 				fields = "*"
 			}
 
-			data, err := dbo.Load(tableId, dataId, fields, context)
+			legacy := translateBoolParam(r.FormValue("legacy"), false)
+			if legacy && r.FormValue("expand") != "" {
+				writeError(w, newParamError("expand", r.FormValue("expand"), "Expand is not supported with legacy output."))
+				return
+			}
+			err = parseExpandParams(r, context)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			var data interface{}
+			if legacy {
+				data, err = dbo.Load(tableId, dataId, fields, context)
+			} else {
+				data, err = dbo.LoadTyped(tableId, dataId, fields, context)
+			}
 
 			m := map[string]interface{}{
 				"data": data,
 			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, err)
 				return
 			}
+			if etag, ok := context["etag"].(string); ok && etag != "" {
+				w.Header().Set("ETag", etag)
+			}
 			jsonData, _ := json.Marshal(m)
 			jsonString := string(jsonData)
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			fmt.Fprint(w, jsonString)
 		}
 	case "POST":
+		if len(urlPathData) >= 3 && urlPathData[2] == "_import" {
+			// Load the rows of a CSV, TSV or NDJSON body.
+			restImportFunc(w, r, dbo, tableId, context)
+			return
+		}
 		// Create the record.
 
 		m := map[string]interface{}{}
 		var postData interface{}
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&postData)
+		err := decodeBody(r, &postData)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
@@ -286,11 +597,12 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		case map[string]interface{}:
 			postDataArray = append(postDataArray, v)
 		default:
-			http.Error(w, "Error parsing post data.", http.StatusInternalServerError)
+			writeError(w, newParamError("body", "", "Error parsing post data."))
 			return
 		}
 
 		upperCasePostDataArray := []map[string]interface{}{}
+		hasVersion := false
 		for _, m := range postDataArray {
 			mUpper := map[string]interface{}{}
 			if m1, ok := m.(map[string]interface{}); ok {
@@ -299,9 +611,30 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 						mUpper[strings.ToUpper(k)] = v
 					}
 				}
+				hasVersion = hasVersion || m1["_version"] != nil
 				upperCasePostDataArray = append(upperCasePostDataArray, mUpper)
 			}
 		}
+		mode := r.FormValue("mode")
+		switch mode {
+		case "", "insert":
+		case "upsert":
+			if r.Header.Get("If-Match") != "" || hasVersion {
+				writeError(w, newParamError("If-Match", r.Header.Get("If-Match"), "Versions cannot be checked with mode=upsert."))
+				return
+			}
+			context["mode"] = mode
+			context["conflict"] = r.FormValue("conflict")
+			context["update"] = r.FormValue("update")
+		default:
+			writeError(w, newParamError("mode", mode, "Invalid mode: "+mode))
+			return
+		}
+		err = beginWriteTx(r, dbo, context)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 		data, err := dbo.Create(tableId, upperCasePostDataArray, context)
 		if inputMode == 1 && data != nil && len(data) == 1 {
 			m["data"] = data[0]
@@ -309,12 +642,13 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 			m["data"] = data
 		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			rollbackWriteTx(context)
+			writeError(w, err)
 			return
 		}
 		jsonData, err := json.Marshal(m)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 		jsonString := string(jsonData)
@@ -324,24 +658,50 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		m := map[string]interface{}{}
 		result, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 		qp, p, array, theCase, err := parseExecParams(string(result))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, newParamError("body", "", "Invalid JSON body: "+err.Error()))
 			return
 		}
 		context["case"] = theCase
+		stream, err := streamFormat(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if stream != "" {
+			// Write the rows of the selects as they are scanned.
+			context["request_ctx"] = r.Context()
+			rw, err := newStreamWriter(r, w, stream, array, tableId)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			err = dbo.ExecStream(tableId, p, qp, rw, context)
+			if err != nil && !rw.Started() {
+				writeError(w, err)
+				return
+			}
+			rw.Close(err)
+			return
+		}
 		data, err := dbo.Exec(tableId, p, qp, array, context)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 		m["data"] = data
+		if refreshToken, ok := context["refresh_token"]; ok {
+			// The login query opened a session.
+			m["refresh_token"] = refreshToken
+			m["expires_in"] = context["expires_in"]
+		}
 		jsonData, err := json.Marshal(m)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 		jsonString := string(jsonData)
@@ -349,31 +709,15 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, jsonString)
 	case "COPY":
 		// Duplicate a new record.
-		dataIds := []string{}
-		if len(urlPathData) >= 3 && len(urlPathData[2]) > 0 {
-			dataIds = append(dataIds, urlPathData[2])
-		} else {
-			var postData interface{}
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&postData)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			postDataArray := []interface{}{}
-			switch v := postData.(type) {
-			case []interface{}:
-				postDataArray = v
-			default:
-				http.Error(w, "Error parsing post data.", http.StatusInternalServerError)
-				return
-			}
-
-			for _, postData := range postDataArray {
-				if dataId, ok := postData.(string); ok {
-					dataIds = append(dataIds, dataId)
-				}
-			}
+		dataIds, err := requestDataIds(r, dbo, tableId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = beginWriteTx(r, dbo, context)
+		if err != nil {
+			writeError(w, err)
+			return
 		}
 		data, err := dbo.Duplicate(tableId, dataIds, context)
 
@@ -385,7 +729,8 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			rollbackWriteTx(context)
+			writeError(w, err)
 			return
 		}
 		jsonData, err := json.Marshal(m)
@@ -395,12 +740,40 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 	case "PUT":
 		// Update an existing record.
 
-		dataId := ""
+		if r.FormValue("mode") == "restore" {
+			// Undelete soft deleted records.
+			dataIds, err := requestDataIds(r, dbo, tableId)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			err = beginWriteTx(r, dbo, context)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			data, err := dbo.Restore(tableId, dataIds, context)
+			if err != nil {
+				rollbackWriteTx(context)
+				writeError(w, err)
+				return
+			}
+			m := map[string]interface{}{}
+			if data != nil && len(data) == 1 {
+				m["data"] = data[0]
+			} else {
+				m["data"] = data
+			}
+			jsonData, _ := json.Marshal(m)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, string(jsonData))
+			return
+		}
+
 		var postData interface{}
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&postData)
+		err := decodeBody(r, &postData)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
@@ -411,15 +784,32 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 			inputMode = 2
 			postDataArray = v
 		case map[string]interface{}:
-			if len(urlPathData) >= 3 && len(urlPathData[2]) > 0 {
-				dataId = urlPathData[2]
-			}
 			postDataArray = append(postDataArray, v)
 		default:
-			http.Error(w, "Error parsing post data.", http.StatusInternalServerError)
+			writeError(w, newParamError("body", "", "Error parsing post data."))
 			return
 		}
 
+		// The id in the url sets the key columns of a single record.
+		dataKey := []interface{}{}
+		keyColumns := []string{}
+		if inputMode == 1 && len(urlPathData) >= 3 && len(urlPathData[2]) > 0 {
+			keyColumns, err = dbo.KeyColumns(tableId)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			dataKey, err = splitKey(urlDataId(r, keyColumns), len(keyColumns))
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		// The version each record is expected to have, from If-Match for a
+		// single record, or from _version in the record.
+		ifMatch := []interface{}{}
+		hasIfMatch := false
 		upperCasePostDataArray := []map[string]interface{}{}
 		for _, m := range postDataArray {
 			mUpper := map[string]interface{}{}
@@ -429,23 +819,71 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 						mUpper[strings.ToUpper(k)] = v
 					}
 				}
-				if inputMode == 1 && dataId != "" {
-					mUpper["ID"] = dataId
+				if inputMode == 1 && r.Header.Get("If-Match") != "" {
+					m1["_version"] = r.Header.Get("If-Match")
+				}
+				ifMatch = append(ifMatch, m1["_version"])
+				hasIfMatch = hasIfMatch || m1["_version"] != nil
+				for i, c := range keyColumns {
+					mUpper[strings.ToUpper(c)] = dataKey[i]
 				}
 				upperCasePostDataArray = append(upperCasePostDataArray, mUpper)
 			}
 		}
-		data, err := dbo.Update(tableId, upperCasePostDataArray, context)
 		m := map[string]interface{}{}
-		if inputMode == 1 && data != nil && len(data) == 1 {
-			m["data"] = data[0]
-		} else {
-			m["data"] = data
+		mode := r.FormValue("mode")
+		switch mode {
+		case "", "update", "upsert":
+		default:
+			writeError(w, newParamError("mode", mode, "Invalid mode: "+mode))
+			return
 		}
+		err = beginWriteTx(r, dbo, context)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
+		switch mode {
+		case "", "update":
+			if hasIfMatch {
+				context["if_match"] = ifMatch
+			}
+			data, err := dbo.Update(tableId, upperCasePostDataArray, context)
+			if err != nil {
+				rollbackWriteTx(context)
+				writeError(w, err)
+				return
+			}
+			if etag, ok := context["etag"].(string); ok && etag != "" && inputMode == 1 {
+				w.Header().Set("ETag", etag)
+			}
+			if inputMode == 1 && data != nil && len(data) == 1 {
+				m["data"] = data[0]
+			} else {
+				m["data"] = data
+			}
+		case "upsert":
+			// Create the records, or update the ones that already exist.
+			if hasIfMatch {
+				rollbackWriteTx(context)
+				writeError(w, newParamError("If-Match", "", "Versions cannot be checked with mode=upsert."))
+				return
+			}
+			context["mode"] = mode
+			context["conflict"] = r.FormValue("conflict")
+			context["update"] = r.FormValue("update")
+			data, err := dbo.Create(tableId, upperCasePostDataArray, context)
+			if err != nil {
+				rollbackWriteTx(context)
+				writeError(w, err)
+				return
+			}
+			if inputMode == 1 && data != nil && len(data) == 1 {
+				m["data"] = data[0]
+			} else {
+				m["data"] = data
+			}
+		}
 
 		jsonData, err := json.Marshal(m)
 		jsonString := string(jsonData)
@@ -453,31 +891,22 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, jsonString)
 	case "DELETE":
 		// Remove the record.
-		dataIds := []string{}
-		if len(urlPathData) >= 3 && len(urlPathData[2]) > 0 {
-			dataIds = append(dataIds, urlPathData[2])
-		} else {
-			var postData interface{}
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&postData)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			postDataArray := []interface{}{}
-			switch v := postData.(type) {
-			case []interface{}:
-				postDataArray = v
-			default:
-				http.Error(w, "Error parsing post data.", http.StatusInternalServerError)
+		dataIds, err := requestDataIds(r, dbo, tableId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			if len(dataIds) != 1 {
+				writeError(w, newParamError("If-Match", ifMatch, "If-Match needs a single record id."))
 				return
 			}
-
-			for _, postData := range postDataArray {
-				if dataId, ok := postData.(string); ok {
-					dataIds = append(dataIds, dataId)
-				}
-			}
+			context["if_match"] = []interface{}{ifMatch}
+		}
+		err = beginWriteTx(r, dbo, context)
+		if err != nil {
+			writeError(w, err)
+			return
 		}
 		data, err := dbo.Delete(tableId, dataIds, context)
 
@@ -489,7 +918,8 @@ var RestFunc = func(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			rollbackWriteTx(context)
+			writeError(w, err)
 			return
 		}
 		jsonData, err := json.Marshal(m)
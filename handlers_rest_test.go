@@ -0,0 +1,93 @@
+// handlers_rest
+package websql
+
+import (
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type auditInterceptor struct {
+	DefaultDataInterceptor
+}
+
+func (this *auditInterceptor) AfterCreate(resourceId string, db *sql.DB, context map[string]interface{}, data []map[string]interface{}) error {
+	tx := ContextTx(context)
+	if tx == nil {
+		return errors.New("No transaction.")
+	}
+	_, err := tx.Exec("INSERT INTO AUDIT (NOTE) VALUES ('created')")
+	return err
+}
+
+// A bulk write either writes all rows, and what its interceptors write in
+// the same transaction, or nothing.
+func TestSqliteAtomicWrite(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testAuditColumns+")",
+		"CREATE TABLE AUDIT (NOTE TEXT)")
+	db, _ := dbo.GetConn()
+	Websql.Interceptors.RegisterDataInterceptor("ITEM", 100, &auditInterceptor{})
+	t.Cleanup(func() {
+		delete(Websql.Interceptors.DataInterceptorRegistry, "ITEM")
+	})
+	count := func(table string) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	context := testContext(t, app, nil)
+	if err := beginWriteTx(httptest.NewRequest("POST", "/api/ITEM", nil), dbo, context); err != nil {
+		t.Fatal(err)
+	}
+	if ContextTx(context) == nil {
+		t.Fatal("Expected the write to run in a transaction.")
+	}
+	_, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}, {"NAME": nil}}, context)
+	if err == nil {
+		t.Fatal("Expected the row without a name rejected.")
+	}
+	rollbackWriteTx(context)
+	if count("ITEM") != 0 || count("AUDIT") != 0 {
+		t.Fatal("Expected nothing written.")
+	}
+
+	context = testContext(t, app, nil)
+	if err := beginWriteTx(httptest.NewRequest("POST", "/api/ITEM", nil), dbo, context); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}, {"NAME": "b"}}, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []interface{}{int64(1), int64(2)}) || count("ITEM") != 2 || count("AUDIT") != 1 {
+		t.Fatal("Expected the rows and the audit written:", ids)
+	}
+}
+
+// With atomic=false each row is written on its own.
+func TestSqliteNonAtomicWrite(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testAuditColumns+")")
+
+	context := testContext(t, app, nil)
+	if err := beginWriteTx(httptest.NewRequest("POST", "/api/ITEM?atomic=false", nil), dbo, context); err != nil {
+		t.Fatal(err)
+	}
+	if ContextTx(context) != nil {
+		t.Fatal("Expected no transaction.")
+	}
+	_, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}, {"NAME": nil}}, context)
+	if err == nil {
+		t.Fatal("Expected the row without a name rejected.")
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 1 {
+		t.Fatal("Expected the first row written:", ids)
+	}
+}
@@ -0,0 +1,633 @@
+// import
+package websql
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// POST /api/<table>/_import loads the rows of a CSV, TSV or NDJSON body into
+// a table, with multi-row inserts in one transaction. The format comes from
+// format= or the Content-Type. The params are:
+//
+//	map: source:FIELD pairs renaming columns, source: leaves a column out
+//	columns: the column names of a CSV without a header row, with header=0
+//	delimiter, null: the CSV field separator, and the text read as NULL
+//	batch: the rows per insert, importDefaultBatch by default
+//	on_error: abort, the default, imports nothing if a row fails, continue
+//	  imports the rows that do not fail
+//	dry_run: 1 checks every row against the database, then rolls back
+//
+// The report counts the rows inserted, skipped because they are empty, and
+// failed, with the line and error of each failed row. The AfterCreate hooks
+// run on the inserted rows once they are kept, not on a dry run or an
+// aborted import.
+var importDefaultBatch = 500
+var importMaxBatch = 5000
+var importMaxErrors = 1000
+
+// ImportReader reads the rows of an import one at a time. Next returns io.EOF
+// after the last row, a nil row for an empty one, and an *ImportError for a
+// row that cannot be read, after which it goes on with the next row.
+type ImportReader interface {
+	Next() (line int, row map[string]interface{}, err error)
+}
+
+type ImportError struct {
+	Line int
+	Err  string
+}
+
+func (this *ImportError) Error() string {
+	return fmt.Sprint("Line ", this.Line, ": ", this.Err)
+}
+
+type ImportReport struct {
+	Inserted int64
+	Skipped  int64
+	Failed   int64
+	Errors   []*ImportError
+	DryRun   bool
+	Aborted  bool
+}
+
+func (this *ImportReport) fail(line int, message string) {
+	this.Failed++
+	if len(this.Errors) < importMaxErrors {
+		this.Errors = append(this.Errors, &ImportError{Line: line, Err: message})
+	}
+}
+
+// parseImportMap parses the map= param into upper case source and field names.
+func parseImportMap(value string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		names := strings.SplitN(pair, ":", 2)
+		if len(names) != 2 {
+			return nil, newParamError("map", pair, "Invalid column mapping: "+pair)
+		}
+		source := strings.ToUpper(strings.TrimSpace(names[0]))
+		field := strings.ToUpper(strings.TrimSpace(names[1]))
+		if source == "" || (field != "" && !columnRegexp.MatchString(field)) || strings.Contains(field, ".") {
+			return nil, newParamError("map", pair, "Invalid column mapping: "+pair)
+		}
+		ret[source] = field
+	}
+	return ret, nil
+}
+
+// importField returns the field a source column is imported into, "" to leave
+// it out.
+func importField(mapping map[string]string, column string) string {
+	column = strings.ToUpper(strings.TrimSpace(column))
+	if field, ok := mapping[column]; ok {
+		return field
+	}
+	if strings.HasPrefix(column, "_") {
+		return ""
+	}
+	return column
+}
+
+type csvImportReader struct {
+	reader *csv.Reader
+	fields []string
+	null   string
+}
+
+func newCsvImportReader(body io.Reader, delimiter string, header bool, columns string, null string, mapping map[string]string) (*csvImportReader, error) {
+	reader := csv.NewReader(body)
+	reader.Comma = []rune(delimiter)[0]
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = delimiter == "\t"
+	var names []string
+	if header {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, newParamError("header", "", "The import has no header row.")
+		}
+		if err != nil {
+			return nil, newParamError("header", "", err.Error())
+		}
+		names = append([]string{}, record...)
+		if len(names) > 0 {
+			names[0] = strings.TrimPrefix(names[0], "\ufeff")
+		}
+	} else {
+		if strings.TrimSpace(columns) == "" {
+			return nil, newParamError("columns", columns, "An import without a header row needs columns.")
+		}
+		names = strings.Split(columns, ",")
+	}
+	ret := &csvImportReader{
+		reader: reader,
+		null:   null,
+	}
+	for _, name := range names {
+		field := importField(mapping, name)
+		if field != "" && (!columnRegexp.MatchString(field) || strings.Contains(field, ".")) {
+			return nil, newParamError("columns", name, "Invalid column: "+name)
+		}
+		ret.fields = append(ret.fields, field)
+	}
+	return ret, nil
+}
+
+func (this *csvImportReader) Next() (int, map[string]interface{}, error) {
+	record, err := this.reader.Read()
+	if err == io.EOF {
+		return 0, nil, err
+	}
+	if perr, ok := err.(*csv.ParseError); ok {
+		return perr.StartLine, nil, &ImportError{Line: perr.StartLine, Err: perr.Err.Error()}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	line, _ := this.reader.FieldPos(0)
+	if len(record) != len(this.fields) {
+		return line, nil, &ImportError{Line: line, Err: fmt.Sprint("Expected ", len(this.fields), " fields, got ", len(record), ".")}
+	}
+	row := map[string]interface{}{}
+	empty := true
+	for i, v := range record {
+		if v != "" {
+			empty = false
+		}
+		if this.fields[i] == "" {
+			continue
+		}
+		if v == this.null {
+			row[this.fields[i]] = nil
+		} else {
+			row[this.fields[i]] = v
+		}
+	}
+	if empty {
+		return line, nil, nil
+	}
+	return line, row, nil
+}
+
+type ndjsonImportReader struct {
+	reader  *bufio.Reader
+	line    int
+	mapping map[string]string
+}
+
+func newNdjsonImportReader(body io.Reader, mapping map[string]string) *ndjsonImportReader {
+	return &ndjsonImportReader{
+		reader:  bufio.NewReader(body),
+		mapping: mapping,
+	}
+}
+
+func (this *ndjsonImportReader) Next() (int, map[string]interface{}, error) {
+	b, err := this.reader.ReadBytes('\n')
+	if err == io.EOF && len(b) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	this.line++
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return this.line, nil, nil
+	}
+	var v map[string]interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return this.line, nil, &ImportError{Line: this.line, Err: "Invalid JSON object: " + err.Error()}
+	}
+	row := map[string]interface{}{}
+	for k, v1 := range v {
+		field := importField(this.mapping, k)
+		if field == "" {
+			continue
+		}
+		if !columnRegexp.MatchString(field) || strings.Contains(field, ".") {
+			return this.line, nil, &ImportError{Line: this.line, Err: "Invalid column: " + k}
+		}
+		switch v1.(type) {
+		case map[string]interface{}, []interface{}:
+			return this.line, nil, &ImportError{Line: this.line, Err: "Invalid value of " + k + "."}
+		}
+		row[field] = v1
+	}
+	if len(row) == 0 {
+		return this.line, nil, nil
+	}
+	return this.line, row, nil
+}
+
+func sameFields(a map[string]interface{}, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Import inserts the rows read by reader into a table in batches, and reports
+// on every row. Options come in context: dry_run, on_error and batch.
+func (this *MySqlDataOperator) Import(tableId string, reader ImportReader, context map[string]interface{}) (*ImportReport, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	dryRun, _ := context["dry_run"].(bool)
+	onError, _ := context["on_error"].(string)
+	batchSize, ok := context["batch"].(int)
+	if !ok || batchSize <= 0 {
+		batchSize = importDefaultBatch
+	}
+
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return nil, err
+	}
+	// Dry runs and aborted imports roll back to here, which leaves a pinned
+	// transaction as it was.
+	_, err = tx.Exec("SAVEPOINT websql_import")
+	if err != nil {
+		if pinnedTx(context) == nil {
+			tx.Rollback()
+		}
+		return nil, err
+	}
+	rollback := func() {
+		if pinnedTx(context) == nil {
+			tx.Rollback()
+		} else {
+			tx.Exec("ROLLBACK TO SAVEPOINT websql_import")
+		}
+	}
+	// Check the permissions before telling anything about the table.
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeCreate(tableId, db, context, []map[string]interface{}{})
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	key, err := this.tableKey(tx, tableId)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	columns, err := this.tableColumns(tx, tableId)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	report := &ImportReport{
+		Errors: []*ImportError{},
+		DryRun: dryRun,
+	}
+	batch := []map[string]interface{}{}
+	lines := []int{}
+	inserted := []map[string]interface{}{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows, err := this.importBatch(tx, db, tableId, key, batch, lines, report, onError != "continue", context)
+		if !dryRun {
+			inserted = append(inserted, rows...)
+		}
+		batch = []map[string]interface{}{}
+		lines = []int{}
+		return err
+	}
+
+	for !report.Aborted {
+		line, row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if ie, ok := err.(*ImportError); ok {
+			report.fail(line, ie.Err)
+			report.Aborted = onError != "continue"
+			continue
+		}
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		if row == nil {
+			report.Skipped++
+			continue
+		}
+		unknown := ""
+		for k := range row {
+			if _, ok := columns[k]; !ok {
+				unknown = k
+				break
+			}
+		}
+		if unknown != "" {
+			report.fail(line, "Unknown column: "+unknown)
+			report.Aborted = onError != "continue"
+			continue
+		}
+		if len(batch) > 0 && (len(batch) >= batchSize || !sameFields(batch[0], row)) {
+			err = flush()
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+		}
+		batch = append(batch, row)
+		lines = append(lines, line)
+	}
+	if !report.Aborted {
+		err = flush()
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	// Rows of a dry run or an aborted import are not kept, their After hooks
+	// do not run.
+	if !report.Aborted && !dryRun && len(inserted) > 0 {
+		err = importAfterCreate(tableId, db, inserted, context)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	if report.Aborted || dryRun {
+		_, err = tx.Exec("ROLLBACK TO SAVEPOINT websql_import")
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	if report.Aborted {
+		report.Inserted = 0
+	}
+	_, err = tx.Exec("RELEASE SAVEPOINT websql_import")
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if pinnedTx(context) == nil {
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// importBatch inserts a batch of rows and returns the ones inserted. If a
+// multi-row insert fails, its rows are inserted one by one to find the ones
+// that fail. The After hooks run once the whole import is known to be kept.
+func (this *MySqlDataOperator) importBatch(tx *sql.Tx, db *sql.DB, tableId string, key *tableKey, batch []map[string]interface{},
+	lines []int, report *ImportReport, abort bool, context map[string]interface{}) ([]map[string]interface{}, error) {
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeCreate(tableId, db, context, batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeCreate(tableId, db, context, batch)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// A string ID is filled with a UUID, as in Create.
+	if !key.AutoIncrement && len(key.Columns) == 1 && strings.EqualFold(key.Columns[0], "ID") {
+		for _, row := range batch {
+			if id, ok := row["ID"]; !ok || id == nil || id == "" {
+				row["ID"] = strings.Replace(uuid.NewV4().String(), "-", "", -1)
+			}
+		}
+	}
+
+	// The hooks may stamp other fields in some rows, the rows are inserted
+	// in groups with the same fields.
+	groups := map[string][]int{}
+	order := []string{}
+	for i, row := range batch {
+		fields := []string{}
+		for k := range row {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		group := strings.Join(fields, ",")
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], i)
+	}
+	inserted := []map[string]interface{}{}
+	for _, group := range order {
+		rows := []map[string]interface{}{}
+		rowLines := []int{}
+		for _, i := range groups[group] {
+			rows = append(rows, batch[i])
+			rowLines = append(rowLines, lines[i])
+		}
+		err := this.importRows(tx, tableId, strings.Split(group, ","), rows, rowLines, report, abort, &inserted)
+		if err != nil || report.Aborted {
+			return inserted, err
+		}
+	}
+	return inserted, nil
+}
+
+// importRows inserts rows with the same fields, adding the inserted ones to
+// inserted.
+func (this *MySqlDataOperator) importRows(tx *sql.Tx, tableId string, fields []string, batch []map[string]interface{},
+	lines []int, report *ImportReport, abort bool, inserted *[]map[string]interface{}) error {
+	d := this.GetDialect()
+	qms := "(" + strings.TrimSuffix(strings.Repeat("?,", len(fields)), ",") + ")"
+	insert := func(rows []map[string]interface{}) error {
+		values := make([]interface{}, 0, len(rows)*len(fields))
+		for _, row := range rows {
+			for _, f := range fields {
+				values = append(values, row[f])
+			}
+		}
+		query := fmt.Sprint("INSERT INTO ", tableId, " (", strings.Join(fields, ","), ") VALUES ",
+			strings.TrimSuffix(strings.Repeat(qms+",", len(rows)), ","))
+		_, err := tx.Exec(d.Rebind(query), values...)
+		return err
+	}
+	savepoint := func(name string, f func() error) error {
+		_, err := tx.Exec("SAVEPOINT " + name)
+		if err != nil {
+			return err
+		}
+		err = f()
+		if err != nil {
+			tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+		}
+		tx.Exec("RELEASE SAVEPOINT " + name)
+		return err
+	}
+
+	chunk := d.MaxParams() / len(fields)
+	if chunk < 1 {
+		chunk = 1
+	}
+	for i := 0; i < len(batch); i += chunk {
+		end := i + chunk
+		if end > len(batch) {
+			end = len(batch)
+		}
+		err := savepoint("websql_import_batch", func() error {
+			return insert(batch[i:end])
+		})
+		if err == nil {
+			report.Inserted += int64(end - i)
+			*inserted = append(*inserted, batch[i:end]...)
+			continue
+		}
+		for j := i; j < end; j++ {
+			err := savepoint("websql_import_row", func() error {
+				return insert(batch[j : j+1])
+			})
+			if err != nil {
+				report.fail(lines[j], err.Error())
+				if abort {
+					report.Aborted = true
+					return nil
+				}
+				continue
+			}
+			report.Inserted++
+			*inserted = append(*inserted, batch[j])
+		}
+	}
+	return nil
+}
+
+// importAfterCreate runs the After hooks on the rows an import keeps.
+func importAfterCreate(tableId string, db *sql.DB, inserted []map[string]interface{}, context map[string]interface{}) error {
+	for _, dataInterceptor := range afterInterceptors(tableId) {
+		err := dataInterceptor.AfterCreate(tableId, db, context, inserted)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restImportFunc serves POST /api/<table>/_import. Its params are read from
+// the url only, the body is the data.
+func restImportFunc(w http.ResponseWriter, r *http.Request, dbo DataOperator, tableId string, context map[string]interface{}) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+		for f, t := range exportContentTypes {
+			if strings.HasPrefix(t, contentType+";") {
+				format = f
+			}
+		}
+	}
+	if format == "" {
+		format = "csv"
+	}
+	mapping, err := parseImportMap(query.Get("map"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var reader ImportReader
+	switch format {
+	case "csv", "tsv":
+		delimiter := ","
+		if format == "tsv" {
+			delimiter = "\t"
+		}
+		delimiter, err = parseDelimiter(query.Get("delimiter"), delimiter)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		reader, err = newCsvImportReader(r.Body, delimiter, translateBoolParam(query.Get("header"), true), query.Get("columns"), query.Get("null"), mapping)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	case "ndjson":
+		reader = newNdjsonImportReader(r.Body, mapping)
+	default:
+		writeError(w, newParamError("format", format, "Invalid import format: "+format))
+		return
+	}
+
+	onError := query.Get("on_error")
+	switch onError {
+	case "", "abort", "continue":
+		context["on_error"] = onError
+	default:
+		writeError(w, newParamError("on_error", onError, "Invalid on_error mode: "+onError))
+		return
+	}
+	if b := query.Get("batch"); b != "" {
+		batch, err := strconv.Atoi(b)
+		if err != nil || batch < 1 || batch > importMaxBatch {
+			writeError(w, newParamError("batch", b, fmt.Sprint("Batch must be between 1 and ", importMaxBatch, ".")))
+			return
+		}
+		context["batch"] = batch
+	}
+	context["dry_run"] = translateBoolParam(query.Get("dry_run"), false)
+
+	report, err := dbo.Import(tableId, reader, context)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if report.Aborted {
+		writeError(w, &RequestError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "import_failed",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Nothing was imported, a row failed: " + report.Errors[0].Error(),
+			Data:    report,
+		})
+		return
+	}
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"data": report,
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsonData))
+}
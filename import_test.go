@@ -0,0 +1,127 @@
+// import
+package websql
+
+import (
+	"database/sql"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type importCountInterceptor struct {
+	DefaultDataInterceptor
+	created int
+}
+
+func (this *importCountInterceptor) AfterCreate(resourceId string, db *sql.DB, context map[string]interface{}, data []map[string]interface{}) error {
+	this.created += len(data)
+	return nil
+}
+
+func TestCsvImportReader(t *testing.T) {
+	mapping, err := parseImportMap("name:NAME, note:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := newCsvImportReader(strings.NewReader("\ufeffid,name,note\n1,a,x\n,,\n2,\\N,y\n3\n"), ",", true, "", `\N`, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, row, err := reader.Next()
+	if err != nil || line != 2 || !reflect.DeepEqual(row, map[string]interface{}{"ID": "1", "NAME": "a"}) {
+		t.Fatal("Expected the first row mapped:", line, row, err)
+	}
+	if _, row, err = reader.Next(); err != nil || row != nil {
+		t.Fatal("Expected an empty row:", row, err)
+	}
+	if _, row, err = reader.Next(); err != nil || row["NAME"] != nil || row["ID"] != "2" {
+		t.Fatal("Expected the null text read as NULL:", row, err)
+	}
+	if line, _, err = reader.Next(); err == nil || line != 5 {
+		t.Fatal("Expected the short row reported:", line, err)
+	}
+	if _, _, err = reader.Next(); err != io.EOF {
+		t.Fatal("Expected the end of the import:", err)
+	}
+
+	_, err = newCsvImportReader(strings.NewReader("1,a\n"), ",", false, "", "", nil)
+	requireStatus(t, err, 400)
+	_, err = parseImportMap("name:NA ME")
+	requireStatus(t, err, 400)
+}
+
+func TestSqliteImport(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testAuditColumns+")")
+	interceptor := &importCountInterceptor{}
+	Websql.Interceptors.RegisterDataInterceptor("ITEM", 100, interceptor)
+	t.Cleanup(func() {
+		delete(Websql.Interceptors.DataInterceptorRegistry, "ITEM")
+	})
+	body := `{"NAME": "a"}` + "\n\n" + `{"NAME": null}` + "\n" + `{"NAME": "b"}` + "\n" + `{"COLOR": "c"}`
+	importRows := func(options map[string]interface{}) *ImportReport {
+		t.Helper()
+		context := testContext(t, app, nil)
+		for k, v := range options {
+			context[k] = v
+		}
+		report, err := dbo.Import("ITEM", newNdjsonImportReader(strings.NewReader(body), nil), context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := importRows(map[string]interface{}{"dry_run": true, "on_error": "continue", "batch": 1})
+	if report.Inserted != 2 || report.Failed != 2 || len(listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil))) != 0 {
+		t.Fatal("Expected a dry run to check the rows and keep none:", report)
+	}
+
+	report = importRows(nil)
+	if !report.Aborted || report.Inserted != 0 || len(listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil))) != 0 {
+		t.Fatal("Expected the import aborted at the failed row:", report)
+	}
+	if interceptor.created != 0 {
+		t.Fatal("Expected no After hooks on rows not kept:", interceptor.created)
+	}
+
+	report = importRows(map[string]interface{}{"on_error": "continue"})
+	if report.Inserted != 2 || report.Skipped != 1 || report.Failed != 2 {
+		t.Fatal("Expected the rows that do not fail imported:", report)
+	}
+	// Rows are checked as they are read, inserted once their batch is full.
+	if report.Errors[0].Err != "Unknown column: COLOR" || report.Errors[0].Line != 5 || report.Errors[1].Line != 3 {
+		t.Fatal("Expected the failed lines reported:", report.Errors)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 2 || interceptor.created != 2 {
+		t.Fatal("Expected the rows kept and their After hooks run:", ids, interceptor.created)
+	}
+}
+
+func TestSqliteRestImport(t *testing.T) {
+	app := newTestApp(t, &App{Id: testRestAppId})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testRestAuditColumns+")")
+	apiToken := newTestRest(t, app, dbo)
+
+	status, body := serveTestRest(t, "POST", "/api/ITEM/_import?format=tsv&map=label:NAME", "LABEL\na\nb\n", apiToken, testContext(t, app, nil))
+	data, _ := body["data"].(map[string]interface{})
+	if status != 200 || data["Inserted"] != float64(2) {
+		t.Fatal("Expected the rows imported:", status, body)
+	}
+
+	status, body = serveTestRest(t, "POST", "/api/ITEM/_import?format=csv", "ID,NAME\n3,c\n1,a\n", apiToken, testContext(t, app, nil))
+	if status != 422 || body["code"] != "import_failed" {
+		t.Fatal("Expected the import failed:", status, body)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 2 {
+		t.Fatal("Expected nothing of the failed import kept:", ids)
+	}
+
+	for _, query := range []string{"format=xml", "on_error=skip", "batch=0", "format=csv&header=0"} {
+		status, body = serveTestRest(t, "POST", "/api/ITEM/_import?"+query, "", apiToken, testContext(t, app, nil))
+		if status != 400 {
+			t.Fatal("Expected the params refused:", query, status, body)
+		}
+	}
+}
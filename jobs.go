@@ -114,6 +114,18 @@ func (this *WebSQL) StartJobs() {
 			}
 		}
 	}
+	err := this.startPurgeJob()
+	if err != nil {
+		log.Println(err)
+	}
+	err = this.startKeyRotationJob()
+	if err != nil {
+		log.Println(err)
+	}
+	err = this.startSessionJob()
+	if err != nil {
+		log.Println(err)
+	}
 	this.Sched.Start()
 }
 
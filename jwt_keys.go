@@ -0,0 +1,293 @@
+// jwt_keys
+package websql
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvsekhvalnov/jose2go"
+	"github.com/satori/go.uuid"
+)
+
+// User tokens are signed with the newest key of the master data, RS256 or
+// ES256, and carry its id in the kid header. Rotating adds a new key and
+// retires the others, which still verify the tokens they signed until these
+// expire, and are then dropped at the next rotation. The public keys are
+// published at /.well-known/jwks.json.
+//
+// The master makes the first key when it starts without one. Only with the
+// jwt_legacy_hs256 flag, and as long as there is no key, tokens are signed
+// with HS256 and the cluster secret, as before keys were supported. Such
+// tokens are rejected once there is a key.
+var jwtKeyAlgs = []string{jose.RS256, jose.ES256}
+
+type SigningKey struct {
+	Id         string
+	Alg        string
+	PrivateKey string
+	PublicKey  string
+	Created    time.Time
+	// Retired is when the key stopped signing, zero while it signs.
+	Retired time.Time
+}
+
+var parsedKeys = map[string]interface{}{}
+var parsedKeysMutex = &sync.Mutex{}
+
+// newSigningKey generates a key for alg.
+func newSigningKey(alg string) (*SigningKey, error) {
+	var privateKey interface{}
+	var publicKey interface{}
+	switch alg {
+	case jose.RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, publicKey = key, &key.PublicKey
+	case jose.ES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, publicKey = key, &key.PublicKey
+	default:
+		return nil, errors.New("Unsupported key algorithm, expected " + strings.Join(jwtKeyAlgs, " or ") + ": " + alg)
+	}
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		Id:         strings.Replace(uuid.NewV4().String(), "-", "", -1),
+		Alg:        alg,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})),
+		Created:    time.Now().UTC(),
+	}, nil
+}
+
+// parseKey parses the private or public key of a signing key, cached by its
+// PEM.
+func (this *SigningKey) parseKey(private bool) (interface{}, error) {
+	data := this.PublicKey
+	if private {
+		data = this.PrivateKey
+	}
+	parsedKeysMutex.Lock()
+	defer parsedKeysMutex.Unlock()
+	if key, ok := parsedKeys[data]; ok {
+		return key, nil
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("Invalid signing key: " + this.Id)
+	}
+	var key interface{}
+	var err error
+	if private {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	parsedKeys[data] = key
+	return key, nil
+}
+
+// expired tells if a retired key no longer verifies tokens.
+func (this *SigningKey) expired(now time.Time) bool {
+	return !this.Retired.IsZero() && this.Retired.Add(jwtAccessTtl).Before(now)
+}
+
+// signingKey returns the key tokens are signed with, nil if there is none.
+func signingKey() *SigningKey {
+	var ret *SigningKey
+	for _, key := range Websql.masterData.SigningKeys {
+		if key.Retired.IsZero() && (ret == nil || key.Created.After(ret.Created)) {
+			ret = key
+		}
+	}
+	return ret
+}
+
+func createJwtToken(payload string) (string, error) {
+	key := signingKey()
+	if key == nil {
+		if Websql.service.JwtLegacyHs256 {
+			return jose.Sign(payload, jose.HS256, []byte(Websql.service.Secret))
+		}
+		return "", errors.New("No user token signing key yet.")
+	}
+	privateKey, err := key.parseKey(true)
+	if err != nil {
+		return "", err
+	}
+	return jose.Sign(payload, key.Alg, privateKey, jose.Header("kid", key.Id))
+}
+
+// verificationKey returns the key to verify a token with, by its kid and
+// alg headers.
+func verificationKey(headers map[string]interface{}) interface{} {
+	alg, _ := headers["alg"].(string)
+	kid, _ := headers["kid"].(string)
+	keys := Websql.masterData.SigningKeys
+	if len(keys) == 0 && kid == "" && alg == jose.HS256 && Websql.service.JwtLegacyHs256 {
+		return []byte(Websql.service.Secret)
+	}
+	for _, key := range keys {
+		if key.Id == kid && key.Alg == alg && !key.expired(time.Now()) {
+			publicKey, err := key.parseKey(false)
+			if err != nil {
+				return err
+			}
+			return publicKey
+		}
+	}
+	return errors.New("Unknown signing key: " + kid)
+}
+
+func decodeJwtToken(token string) (string, error) {
+	payload, _, err := jose.Decode(token, func(headers map[string]interface{}, payload string) interface{} {
+		return verificationKey(headers)
+	})
+	return payload, err
+}
+
+// RotateSigningKey adds a key for alg to sign tokens with from now on,
+// retires the current one, and drops those retired for longer than tokens
+// live.
+func (this *MasterData) RotateSigningKey(alg string) error {
+	key, err := newSigningKey(alg)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	keys := []*SigningKey{}
+	for _, k := range this.SigningKeys {
+		if k.expired(now) {
+			continue
+		}
+		if k.Retired.IsZero() {
+			k.Retired = now
+		}
+		keys = append(keys, k)
+	}
+	this.SigningKeys = append(keys, key)
+	this.Version++
+	return Websql.masterData.Propagate()
+}
+
+// RemoveSigningKey drops a key at once, e.g. when it leaked. The tokens it
+// signed are no longer valid.
+func (this *MasterData) RemoveSigningKey(id string) error {
+	for iKey, vKey := range this.SigningKeys {
+		if vKey.Id == id {
+			copy(this.SigningKeys[iKey:], this.SigningKeys[iKey+1:])
+			this.SigningKeys[len(this.SigningKeys)-1] = nil
+			this.SigningKeys = this.SigningKeys[:len(this.SigningKeys)-1]
+			this.Version++
+			return Websql.masterData.Propagate()
+		}
+	}
+	return errors.New("Signing key not found: " + id)
+}
+
+// startKeyRotationJob makes the first signing key on the master if there is
+// none yet, unless the legacy HS256 tokens are asked for, and rotates it on
+// the schedule of the jwt_key_rotation flag.
+func (this *WebSQL) startKeyRotationJob() error {
+	rotate := func() {
+		err := this.masterData.RotateSigningKey(this.service.JwtKeyAlg)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if signingKey() == nil && !this.service.JwtLegacyHs256 {
+		rotate()
+	}
+	if this.service.JwtKeyRotation == "" {
+		return nil
+	}
+	_, err := this.Sched.AddFunc(this.service.JwtKeyRotation, rotate)
+	return err
+}
+
+// jwk returns the public JSON web key of a signing key.
+func (this *SigningKey) jwk() (map[string]interface{}, error) {
+	publicKey, err := this.parseKey(false)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"kid": this.Id,
+		"alg": this.Alg,
+		"use": "sig",
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		ret["kty"] = "RSA"
+		ret["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		ret["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		ret["kty"] = "EC"
+		ret["crv"] = key.Curve.Params().Name
+		ret["x"] = base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size))
+		ret["y"] = base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size))
+	default:
+		return nil, errors.New("Unsupported signing key: " + this.Id)
+	}
+	return ret, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// JwksFunc serves the public keys that verify user tokens, for other services.
+func JwksFunc(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]interface{}{}
+	now := time.Now()
+	for _, key := range Websql.masterData.SigningKeys {
+		if key.expired(now) {
+			continue
+		}
+		jwk, err := key.jwk()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(jsonData)
+}
@@ -0,0 +1,166 @@
+// jwt_keys
+package websql
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dvsekhvalnov/jose2go"
+)
+
+// newTestKeys gives the master data a key of its own to rotate, and a data
+// file to propagate to.
+func newTestKeys(t *testing.T, alg string) *SigningKey {
+	t.Helper()
+	newTestApp(t, &App{Id: "app"})
+	key, err := newSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Websql.masterData.SigningKeys = []*SigningKey{key}
+	dataFile := Websql.service.DataFile
+	Websql.service.DataFile = filepath.Join(t.TempDir(), "data.json")
+	t.Cleanup(func() {
+		Websql.service.DataFile = dataFile
+	})
+	return key
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	key := newTestKeys(t, jose.ES256)
+	masterData := Websql.masterData
+
+	token, err := createJwtToken(`{"sub":"a"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := masterData.RotateSigningKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+	if signingKey() == key || signingKey().Alg != jose.RS256 || key.Retired.IsZero() {
+		t.Fatal("Expected the new key to sign and the old one retired.")
+	}
+	// A token of the retired key still verifies until it would expire.
+	if payload, err := decodeJwtToken(token); err != nil || payload != `{"sub":"a"}` {
+		t.Fatal("Expected the token of the retired key verified:", payload, err)
+	}
+	newToken, err := createJwtToken(`{"sub":"b"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(newToken); err != nil {
+		t.Fatal(err)
+	}
+
+	key.Retired = time.Now().Add(-jwtAccessTtl - time.Minute)
+	if _, err := decodeJwtToken(token); err == nil {
+		t.Fatal("Expected the token of an expired key rejected.")
+	}
+	if err := masterData.RotateSigningKey(jose.ES256); err != nil {
+		t.Fatal(err)
+	}
+	if len(masterData.SigningKeys) != 2 {
+		t.Fatal("Expected the expired key dropped:", len(masterData.SigningKeys))
+	}
+
+	// A removed key verifies nothing at once.
+	if err := masterData.RemoveSigningKey(masterData.SigningKeys[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(newToken); err == nil {
+		t.Fatal("Expected the token of a removed key rejected.")
+	}
+	if _, err := newSigningKey(jose.HS256); err == nil {
+		t.Fatal("Expected a symmetric signing key refused.")
+	}
+}
+
+// A token must be signed with the alg of the key it names, the public key of
+// an RSA key does not verify an HS256 token.
+func TestVerificationKeyAlg(t *testing.T) {
+	key := newTestKeys(t, jose.RS256)
+	token, err := jose.Sign(`{"sub":"a"}`, jose.HS256, []byte(key.PublicKey), jose.Header("kid", key.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(token); err == nil {
+		t.Fatal("Expected a token with another alg than its key rejected.")
+	}
+}
+
+func TestLegacyHs256(t *testing.T) {
+	newTestKeys(t, jose.ES256)
+	Websql.masterData.SigningKeys = nil
+	service := *Websql.service
+	Websql.service.Secret = "secret"
+	t.Cleanup(func() {
+		*Websql.service = service
+	})
+
+	Websql.service.JwtLegacyHs256 = false
+	if _, err := createJwtToken(`{"sub":"a"}`); err == nil {
+		t.Fatal("Expected no token signed without a key.")
+	}
+	hs256, err := jose.Sign(`{"sub":"a"}`, jose.HS256, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(hs256); err == nil {
+		t.Fatal("Expected an HS256 token rejected without the legacy flag.")
+	}
+
+	Websql.service.JwtLegacyHs256 = true
+	token, err := createJwtToken(`{"sub":"a"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(token); err != nil {
+		t.Fatal("Expected the legacy token verified:", err)
+	}
+	if err := Websql.masterData.RotateSigningKey(jose.ES256); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeJwtToken(token); err == nil {
+		t.Fatal("Expected the legacy token rejected once there is a key.")
+	}
+}
+
+func TestJwks(t *testing.T) {
+	ecKey := newTestKeys(t, jose.ES256)
+	rsaKey, err := newSigningKey(jose.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := newSigningKey(jose.ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.Retired = time.Now().Add(-jwtAccessTtl - time.Minute)
+	Websql.masterData.SigningKeys = append(Websql.masterData.SigningKeys, rsaKey, expired)
+
+	w := httptest.NewRecorder()
+	JwksFunc(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var body struct {
+		Keys []map[string]string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Keys) != 2 {
+		t.Fatal("Expected the keys that verify tokens:", body.Keys)
+	}
+	if k := body.Keys[0]; k["kid"] != ecKey.Id || k["kty"] != "EC" || k["crv"] != "P-256" || len(k["x"]) != 43 || len(k["y"]) != 43 {
+		t.Fatal("Expected the EC key:", k)
+	}
+	if k := body.Keys[1]; k["kid"] != rsaKey.Id || k["kty"] != "RSA" || k["alg"] != jose.RS256 || k["e"] != "AQAB" || k["n"] == "" {
+		t.Fatal("Expected the RSA key:", k)
+	}
+	for _, k := range body.Keys {
+		if _, ok := k["d"]; ok {
+			t.Fatal("Expected no private key published.")
+		}
+	}
+}
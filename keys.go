@@ -0,0 +1,327 @@
+// keys
+package websql
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// tableKey holds the columns that identify a row of a table.
+type tableKey struct {
+	Columns       []string
+	AutoIncrement bool
+	// Nullable holds the columns of the table that may be NULL.
+	Nullable map[string]bool
+	// Unique holds the columns of each unique index, the primary key included.
+	Unique  [][]string
+	expires time.Time
+}
+
+// Table keys are cached per data operator for tableKeyTtl, so schema changes
+// are picked up without a restart.
+var tableKeyTtl = time.Minute
+
+// RegisterTableKey sets the key columns of a table, for tables and views
+// without a primary key, or whose primary key is not what clients address
+// rows by. tableId is either the table name or db.table.
+func (this *WebSQL) RegisterTableKey(tableId string, columns ...string) {
+	this.TableKeys[strings.ToUpper(unquoteIdentifier(tableId))] = columns
+}
+
+func registeredTableKey(tableId string) []string {
+	id := strings.ToUpper(unquoteIdentifier(tableId))
+	if columns, ok := Websql.TableKeys[id]; ok {
+		return columns
+	}
+	_, table := splitTableId(id)
+	return Websql.TableKeys[table]
+}
+
+// tableKey finds the key of a table: the registered key if there is one, else
+// the primary key, else the ID column.
+func (this *MySqlDataOperator) tableKey(q sqlQuerier, tableId string) (*tableKey, error) {
+	this.keysMutex.Lock()
+	if key, ok := this.keys[tableId]; ok && time.Now().Before(key.expires) {
+		this.keysMutex.Unlock()
+		return key, nil
+	}
+	this.keysMutex.Unlock()
+
+	d := this.GetDialect()
+	query, args := d.ColumnsMetaQuery(tableId)
+	_, columns, err := queryToTypedArray(q, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, &RequestError{
+			Status:  http.StatusNotFound,
+			Code:    "not_found",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Table not found: " + unquoteIdentifier(tableId),
+		}
+	}
+	names := map[string]string{}
+	autoIncrement := map[string]bool{}
+	nullable := map[string]bool{}
+	for _, row := range columns {
+		name := metaString(row[0])
+		names[strings.ToUpper(name)] = name
+		autoIncrement[name] = metaBool(row[5])
+		nullable[name] = metaBool(row[3])
+	}
+
+	key := &tableKey{
+		Columns:  []string{},
+		Nullable: nullable,
+		expires:  time.Now().Add(tableKeyTtl),
+	}
+	query, args = d.IndexesQuery(tableId)
+	_, indexes, err := queryToTypedArray(q, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	primary := []string{}
+	unique := map[string]int{}
+	for _, row := range indexes {
+		if metaBool(row[3]) {
+			primary = append(primary, metaString(row[1]))
+		}
+		if metaBool(row[2]) {
+			index := metaString(row[0])
+			if _, ok := unique[index]; !ok {
+				unique[index] = len(key.Unique)
+				key.Unique = append(key.Unique, []string{})
+			}
+			key.Unique[unique[index]] = append(key.Unique[unique[index]], metaString(row[1]))
+		}
+	}
+	if registered := registeredTableKey(tableId); len(registered) > 0 {
+		for _, c := range registered {
+			name, ok := names[strings.ToUpper(c)]
+			if !ok {
+				return nil, errors.New("Key column not found: " + c)
+			}
+			key.Columns = append(key.Columns, name)
+		}
+	} else {
+		key.Columns = primary
+		if id, ok := names["ID"]; ok && len(key.Columns) == 0 {
+			key.Columns = append(key.Columns, id)
+		}
+	}
+	key.AutoIncrement = len(key.Columns) == 1 && autoIncrement[key.Columns[0]]
+
+	this.keysMutex.Lock()
+	if this.keys == nil {
+		this.keys = map[string]*tableKey{}
+	}
+	this.keys[tableId] = key
+	this.keysMutex.Unlock()
+	return key, nil
+}
+
+// KeyColumns returns the key columns of a table.
+func (this *MySqlDataOperator) KeyColumns(tableId string) ([]string, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	key, err := this.tableKey(db, tableId)
+	if err != nil {
+		return nil, err
+	}
+	return key.Columns, nil
+}
+
+// rowNotFound reports a row missing by its key.
+func rowNotFound(id string) error {
+	return &RequestError{
+		Status:  http.StatusNotFound,
+		Code:    "not_found",
+		Value:   id,
+		Message: id + " not found.",
+	}
+}
+
+// splitKey splits an id into n key values. A single column key is the value
+// itself. A composite key is its URL escaped values joined by commas.
+func splitKey(id string, n int) ([]interface{}, error) {
+	if n == 1 {
+		return []interface{}{id}, nil
+	}
+	parts := strings.Split(id, ",")
+	if len(parts) != n {
+		return nil, newParamError("id", id, fmt.Sprint("Key needs ", n, " values: ", id))
+	}
+	ret := make([]interface{}, n)
+	for i, part := range parts {
+		v, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, newParamError("id", id, "Invalid key: "+id)
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+// joinKey is the reverse of splitKey.
+func joinKey(values []interface{}) string {
+	if len(values) == 1 {
+		return keyValueString(values[0])
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = url.PathEscape(keyValueString(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func keyValueString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case []byte:
+		return string(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatKeyValue turns a key given in a JSON body into an id. It accepts the
+// id string, a number, an array of the key values in key column order, or an
+// object of the key columns.
+func formatKeyValue(v interface{}, keyColumns []string) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return keyValueString(x), true
+	case []interface{}:
+		if len(x) != len(keyColumns) {
+			return "", false
+		}
+		return joinKey(x), true
+	case map[string]interface{}:
+		values := []interface{}{}
+		for _, c := range keyColumns {
+			found := false
+			for k, v := range x {
+				if strings.EqualFold(k, c) {
+					values = append(values, v)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return "", false
+			}
+		}
+		return joinKey(values), true
+	}
+	return "", false
+}
+
+func (this *tableKey) check(tableId string) error {
+	if len(this.Columns) == 0 {
+		return &RequestError{
+			Status:  http.StatusBadRequest,
+			Code:    "no_key",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Table has no key: " + unquoteIdentifier(tableId),
+		}
+	}
+	return nil
+}
+
+// where returns the predicate selecting a row by its key, with a placeholder
+// per key column.
+func (this *tableKey) where(d Dialect) string {
+	ret := []string{}
+	for _, c := range this.Columns {
+		ret = append(ret, d.QuoteIdentifier(c)+"=?")
+	}
+	return strings.Join(ret, " AND ")
+}
+
+// values returns the key values of a row whose keys are upper case.
+func (this *tableKey) values(data map[string]interface{}) ([]interface{}, error) {
+	ret := []interface{}{}
+	for _, c := range this.Columns {
+		v, ok := data[strings.ToUpper(c)]
+		if !ok || v == nil {
+			return nil, newParamError(c, "", "Key column is not found: "+c)
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+// id returns the key of a created row as the client addresses it, nil if the
+// table has no key or the row has no key values.
+func (this *tableKey) id(data map[string]interface{}) interface{} {
+	values, err := this.values(data)
+	if err != nil || len(this.Columns) == 0 {
+		return nil
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return joinKey(values)
+}
+
+// duplicateKey gives newData, a copy of a row, a new key. A string ID gets a
+// new UUID, an auto increment key is dropped for the database to fill in. It
+// returns the new id, or the column the database generates.
+func (this *tableKey) duplicateKey(tableId string, newData map[string]interface{}) (string, string, error) {
+	if this.AutoIncrement {
+		delete(newData, strings.ToUpper(this.Columns[0]))
+		return "", this.Columns[0], nil
+	}
+	if len(this.Columns) == 1 && strings.EqualFold(this.Columns[0], "ID") {
+		newId := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+		newData["ID"] = newId
+		return newId, "", nil
+	}
+	return "", "", &RequestError{
+		Status:  http.StatusBadRequest,
+		Code:    "no_key",
+		Value:   unquoteIdentifier(tableId),
+		Message: "Cannot generate a new key for: " + unquoteIdentifier(tableId),
+	}
+}
+
+// execInsert runs an insert and returns the value the database generated for
+// column, or nil if column is empty.
+func (this *MySqlDataOperator) execInsert(q sqlQuerier, e sqlExecer, query string, values []interface{}, column string) (interface{}, error) {
+	d := this.GetDialect()
+	if column == "" {
+		_, err := e.Exec(d.Rebind(query), values...)
+		return nil, err
+	}
+	if returning := d.ReturningClause(column); returning != "" {
+		_, rows, err := queryToTypedArray(q, "", d.Rebind(query+returning), values...)
+		if err != nil || len(rows) == 0 {
+			return nil, err
+		}
+		return rows[0][0], nil
+	}
+	result, err := e.Exec(d.Rebind(query), values...)
+	if err != nil {
+		return nil, err
+	}
+	return result.LastInsertId()
+}
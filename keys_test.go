@@ -0,0 +1,140 @@
+// keys
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	values := []interface{}{"a,b", "100%", "c"}
+	id := joinKey(values)
+	if id != "a%2Cb,100%25,c" {
+		t.Fatal("Expected the key values escaped, got", id)
+	}
+	split, err := splitKey(id, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(split, values) {
+		t.Fatal("Expected", values, "got", split)
+	}
+	// A single column key is not escaped.
+	if split, err := splitKey("a,b", 1); err != nil || split[0] != "a,b" {
+		t.Fatal("Expected the id itself, got", split, err)
+	}
+	_, err = splitKey("a,b", 3)
+	requireStatus(t, err, 400)
+}
+
+func TestFormatKeyValue(t *testing.T) {
+	columns := []string{"ORDER_ID", "LINE"}
+	cases := []struct {
+		v        interface{}
+		expected string
+		ok       bool
+	}{
+		{"1,2", "1,2", true},
+		{float64(12), "12", true},
+		{[]interface{}{"a,b", float64(2)}, "a%2Cb,2", true},
+		{map[string]interface{}{"line": float64(2), "order_id": "a"}, "a,2", true},
+		{[]interface{}{"a"}, "", false},
+		{map[string]interface{}{"order_id": "a"}, "", false},
+		{true, "", false},
+	}
+	for _, c := range cases {
+		id, ok := formatKeyValue(c.v, columns)
+		if id != c.expected || ok != c.ok {
+			t.Error("Expected", c.expected, c.ok, "for", c.v, "got", id, ok)
+		}
+	}
+}
+
+func TestSqliteTableKey(t *testing.T) {
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL UNIQUE, NOTE TEXT)",
+		"CREATE TABLE ORDER_LINE (ORDER_ID TEXT NOT NULL, LINE INTEGER NOT NULL, QTY INTEGER, PRIMARY KEY (ORDER_ID, LINE))",
+		"CREATE TABLE TAG (ID TEXT, NAME TEXT)",
+		"CREATE VIEW ITEM_VIEW AS SELECT NAME, NOTE FROM ITEM")
+	db, _ := dbo.GetConn()
+
+	key, err := dbo.tableKey(db, `"ITEM"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Columns, []string{"ID"}) || !key.AutoIncrement {
+		t.Fatal("Expected the rowid alias as an auto increment key:", key.Columns, key.AutoIncrement)
+	}
+	if !key.Nullable["NOTE"] || key.Nullable["NAME"] || key.Nullable["ID"] {
+		t.Fatal("Expected only NOTE nullable:", key.Nullable)
+	}
+	if len(key.Unique) != 2 {
+		t.Fatal("Expected the primary key and the NAME index unique:", key.Unique)
+	}
+
+	key, err = dbo.tableKey(db, `"ORDER_LINE"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Columns, []string{"ORDER_ID", "LINE"}) || key.AutoIncrement {
+		t.Fatal("Expected the composite primary key:", key.Columns, key.AutoIncrement)
+	}
+	if where := key.where(dbo.GetDialect()); where != `"ORDER_ID"=? AND "LINE"=?` {
+		t.Fatal("Unexpected key predicate:", where)
+	}
+
+	// Without a primary key the ID column is the key.
+	key, err = dbo.tableKey(db, `"TAG"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Columns, []string{"ID"}) || key.AutoIncrement {
+		t.Fatal("Expected ID as the key:", key.Columns, key.AutoIncrement)
+	}
+
+	Websql.RegisterTableKey("item_view", "name")
+	t.Cleanup(func() {
+		delete(Websql.TableKeys, "ITEM_VIEW")
+	})
+	key, err = dbo.tableKey(db, `"ITEM_VIEW"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Columns, []string{"NAME"}) {
+		t.Fatal("Expected the registered key:", key.Columns)
+	}
+
+	_, err = dbo.tableKey(db, `"MISSING"`)
+	requireStatus(t, err, 404)
+}
+
+func TestSqliteCompositeKey(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ORDER_LINE (ORDER_ID TEXT NOT NULL, LINE INTEGER NOT NULL, QTY INTEGER, "+
+		testAuditColumns+", PRIMARY KEY (ORDER_ID, LINE))")
+
+	ids, err := dbo.Create("ORDER_LINE", []map[string]interface{}{{"ORDER_ID": "a,b", "LINE": 1, "QTY": 5}}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "a%2Cb,1" {
+		t.Fatal("Expected the joined key of the created row:", ids)
+	}
+	row, err := dbo.LoadTyped("ORDER_LINE", "a%2Cb,1", "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["QTY"] != int64(5) {
+		t.Fatal("Expected the row by its composite key:", row)
+	}
+	_, err = dbo.LoadTyped("ORDER_LINE", "a%2Cb", "*", testContext(t, app, nil))
+	requireStatus(t, err, 400)
+
+	deleted, err := dbo.Delete("ORDER_LINE", []string{"a%2Cb,1"}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatal("Expected the row deleted:", deleted)
+	}
+}
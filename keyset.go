@@ -0,0 +1,193 @@
+// keyset
+package websql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elgs/gosqljson"
+)
+
+// keysetPredicate selects the rows after values in keyset order:
+// (A > ?) OR (A = ? AND B > ?) OR ...
+func keysetPredicate(keyset []sortColumn, values []interface{}) (string, []interface{}) {
+	ors := []string{}
+	args := []interface{}{}
+	for i, col := range keyset {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, keyset[j].Expr+"=?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if col.Desc {
+			op = "<"
+		}
+		ands = append(ands, col.Expr+op+"?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func encodeCursor(values []interface{}) (string, error) {
+	for i, v := range values {
+		if t, ok := v.(time.Time); ok {
+			values[i] = t.Format("2006-01-02 15:04:05.999999999")
+		}
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(cursor string, n int) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, newParamError("cursor", cursor, "Invalid cursor.")
+	}
+	values := []interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&values)
+	if err != nil || len(values) != n {
+		return nil, newParamError("cursor", cursor, "Invalid cursor.")
+	}
+	for i, v := range values {
+		if number, ok := v.(json.Number); ok {
+			values[i] = number.String()
+		}
+	}
+	return values, nil
+}
+
+func sameColumn(key string, column string) bool {
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		column = column[i+1:]
+	}
+	return strings.EqualFold(strings.Replace(key, "_", "", -1), strings.Replace(column, "_", "", -1))
+}
+
+func mapRowValue(row map[string]interface{}, column string) interface{} {
+	for k, v := range row {
+		if sameColumn(k, column) {
+			return v
+		}
+	}
+	return nil
+}
+
+func stringMapRowValue(row map[string]string, column string) interface{} {
+	for k, v := range row {
+		if sameColumn(k, column) {
+			return v
+		}
+	}
+	return nil
+}
+
+func arrayRowValue(headers []string, row []interface{}, column string) interface{} {
+	for i, k := range headers {
+		if sameColumn(k, column) && i < len(row) {
+			return row[i]
+		}
+	}
+	return nil
+}
+
+func stringArrayRowValue(headers []string, row []string, column string) interface{} {
+	for i, k := range headers {
+		if sameColumn(k, column) && i < len(row) {
+			return row[i]
+		}
+	}
+	return nil
+}
+
+func countMode(context map[string]interface{}) string {
+	if mode, ok := context["count"].(string); ok && mode != "" {
+		return mode
+	}
+	return "exact"
+}
+
+func (this *MySqlDataOperator) listQuery(tableId string, fields string, where string, group string, sort string,
+	start int64, limit int64, keyset []sortColumn, context map[string]interface{}) (string, []interface{}, error) {
+	d := this.GetDialect()
+	args := append([]interface{}{}, whereArgs(context)...)
+	if keyset != nil {
+		start = 0
+		if cursor, _ := context["cursor"].(string); cursor != "" {
+			values, err := decodeCursor(cursor, len(keyset))
+			if err != nil {
+				return "", nil, err
+			}
+			predicate, predicateArgs := keysetPredicate(keyset, values)
+			where = fmt.Sprint(where, " AND ", predicate, " ")
+			args = append(args, predicateArgs...)
+		}
+	}
+	foundRows := keyset == nil && countMode(context) == "exact"
+	args = append(args, d.PagingArgs(start, limit)...)
+	return d.Rebind(d.ListQuery(fields, tableId, where, parseGroup(group), sort, foundRows)), args, nil
+}
+
+// countRows returns the total for a list request, -1 if the client asked for
+// no count. Estimates come from table statistics and ignore the filters.
+func (this *MySqlDataOperator) countRows(tx *sql.Tx, tableId string, fields string, where string, group string,
+	keyset []sortColumn, context map[string]interface{}) (int64, error) {
+	d := this.GetDialect()
+	var query string
+	args := []interface{}{}
+	switch countMode(context) {
+	case "none":
+		return -1, nil
+	case "estimate":
+		query, args = d.EstimateQuery(tableId)
+	default:
+		embedsWhere := true
+		if keyset != nil {
+			query = fmt.Sprint("SELECT COUNT(*) FROM (SELECT ", fields, " FROM ", tableId, where, parseGroup(group), ") AS T")
+		} else {
+			query, embedsWhere = d.CountQuery(fields, tableId, where, parseGroup(group))
+		}
+		if embedsWhere {
+			args = whereArgs(context)
+		}
+	}
+	_, cntData, err := gosqljson.QueryTxToArray(tx, "", d.Rebind(query), args...)
+	if err != nil {
+		return -1, err
+	}
+	if len(cntData) == 0 || len(cntData[0]) == 0 || cntData[0][0] == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(cntData[0][0], 10, 64)
+}
+
+// setNextCursor stores the cursor of the page after the current one in
+// context["next_cursor"], or an empty string on the last page.
+func setNextCursor(context map[string]interface{}, keyset []sortColumn, rows int, limit int64, lastRowValue func(column string) interface{}) error {
+	if rows == 0 || int64(rows) < limit {
+		context["next_cursor"] = ""
+		return nil
+	}
+	values := make([]interface{}, len(keyset))
+	for i, col := range keyset {
+		values[i] = lastRowValue(col.Name)
+	}
+	cursor, err := encodeCursor(values)
+	if err != nil {
+		return err
+	}
+	context["next_cursor"] = cursor
+	return nil
+}
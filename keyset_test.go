@@ -0,0 +1,111 @@
+// keyset
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	cursor, err := encodeCursor([]interface{}{int64(3), "a,b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := decodeCursor(cursor, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{"3", "a,b"}) {
+		t.Fatal("Expected the values of the cursor, got", values)
+	}
+	_, err = decodeCursor(cursor, 1)
+	requireStatus(t, err, 400)
+	_, err = decodeCursor("not a cursor", 2)
+	requireStatus(t, err, 400)
+
+	predicate, args := keysetPredicate([]sortColumn{{Expr: "A", Desc: true}, {Expr: "B"}}, []interface{}{1, 2})
+	if predicate != "((A<?) OR (A=? AND B>?))" || !reflect.DeepEqual(args, []interface{}{1, 1, 2}) {
+		t.Fatal("Unexpected keyset predicate:", predicate, args)
+	}
+}
+
+func TestSqliteKeysetPaging(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, SCORE INTEGER NOT NULL, NOTE TEXT)",
+		"INSERT INTO ITEM VALUES (1, 5, NULL), (2, 3, NULL), (3, 5, NULL), (4, 1, NULL), (5, 3, NULL)")
+	db, _ := dbo.GetConn()
+
+	// Rows with the same score are ordered by the key.
+	expected := []interface{}{int64(1), int64(3), int64(2), int64(5), int64(4)}
+	ids := []interface{}{}
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		context := testContext(t, app, nil)
+		context["cursor"] = cursor
+		rows, _, err := dbo.ListMapTyped("ITEM", "*", nil, "SCORE:desc", "", 0, 2, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			ids = append(ids, row["ID"])
+		}
+		cursor = context["next_cursor"].(string)
+		if cursor == "" {
+			break
+		}
+		if page == 0 {
+			// A row inserted before the cursor does not shift the next page.
+			if _, err := db.Exec("INSERT INTO ITEM VALUES (6, 9, NULL)"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if cursor != "" {
+		t.Fatal("Expected the last page to have no next cursor.")
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatal("Expected", expected, "got", ids)
+	}
+
+	context := testContext(t, app, nil)
+	context["cursor"] = ""
+	_, _, err := dbo.ListMapTyped("ITEM", "*", nil, "NOTE", "", 0, 2, context)
+	if requestError := requireStatus(t, err, 400); requestError.Param != "sort" {
+		t.Fatal("Expected the nullable sort column rejected, got", requestError.Param)
+	}
+
+	context = testContext(t, app, nil)
+	context["cursor"] = ""
+	_, _, err = dbo.ListMapTyped("ITEM", "SCORE", nil, "SCORE", "", 0, 2, context)
+	if requestError := requireStatus(t, err, 400); requestError.Param != "cursor" {
+		t.Fatal("Expected the fields without the key rejected, got", requestError.Param)
+	}
+
+	context = testContext(t, app, nil)
+	context["cursor"] = "bm90IGEgY3Vyc29y"
+	_, _, err = dbo.ListMapTyped("ITEM", "*", nil, "SCORE", "", 0, 2, context)
+	requireStatus(t, err, 400)
+}
+
+func TestSqliteCountModes(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, SCORE INTEGER NOT NULL)",
+		"INSERT INTO ITEM VALUES (1, 5), (2, 3), (3, 5)",
+		"DELETE FROM ITEM WHERE ID=2")
+
+	cases := map[string]int64{"exact": 1, "none": -1, "estimate": 3}
+	for mode, expected := range cases {
+		context := testContext(t, app, nil)
+		context["count"] = mode
+		context["cursor"] = ""
+		rows, total, err := dbo.ListMapTyped("ITEM", "*", []string{"SCORE==5", "ID=gt=1"}, "", "", 0, 10, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || total != expected {
+			t.Error("Expected", expected, "as the", mode, "count, got", total, rows)
+		}
+	}
+}
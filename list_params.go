@@ -0,0 +1,326 @@
+// list_params
+package websql
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RequestError is an error caused by the client's request. RestFunc reports it
+// with its status and a JSON body instead of a plain 500.
+type RequestError struct {
+	Status  int
+	Code    string
+	Param   string
+	Value   string
+	Message string
+	// Data is returned to the client along with the error, if not nil.
+	Data interface{}
+}
+
+func (this *RequestError) Error() string {
+	return this.Message
+}
+
+func newParamError(param string, value string, message string) *RequestError {
+	return &RequestError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_param",
+		Param:   param,
+		Value:   value,
+		Message: message,
+	}
+}
+
+type sortColumn struct {
+	// Name is the column name as it comes back in result rows.
+	Name string
+	// Expr is the quoted SQL expression to sort by.
+	Expr string
+	Desc bool
+}
+
+var identifierPattern = `[A-Za-z_][A-Za-z0-9_]*`
+var columnRegexp = regexp.MustCompile(`^(` + identifierPattern + `)(?:\.(` + identifierPattern + `))?$`)
+var aggregateRegexp = regexp.MustCompile(`(?i)^(COUNT|SUM|AVG|MIN|MAX)\s*\(\s*(DISTINCT\s+)?(\*|` + identifierPattern + `(?:\.` + identifierPattern + `)?)\s*\)$`)
+var aliasRegexp = regexp.MustCompile(`(?i)^(.+?)\s+(?:AS\s+)?(` + identifierPattern + `)$`)
+
+// tableColumnSet holds the columns of a table, keyed by their upper case name.
+type tableColumnSet struct {
+	names   map[string]string
+	expires time.Time
+}
+
+// tableColumns returns the columns of a table, keyed by their upper case name.
+// They are cached for tableKeyTtl, like the table key. The returned map must
+// not be changed.
+func (this *MySqlDataOperator) tableColumns(q sqlQuerier, tableId string) (map[string]string, error) {
+	this.columnsMutex.Lock()
+	if set, ok := this.columns[tableId]; ok && time.Now().Before(set.expires) {
+		this.columnsMutex.Unlock()
+		return set.names, nil
+	}
+	this.columnsMutex.Unlock()
+
+	d := this.GetDialect()
+	query, args := d.ColumnsQuery(tableId)
+	_, data, err := queryToTypedArray(q, "", d.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]string{}
+	for _, row := range data {
+		if name, ok := row[0].(string); ok {
+			columns[strings.ToUpper(name)] = name
+		}
+	}
+	if len(columns) == 0 {
+		return nil, &RequestError{
+			Status:  http.StatusNotFound,
+			Code:    "not_found",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Table not found: " + unquoteIdentifier(tableId),
+		}
+	}
+
+	this.columnsMutex.Lock()
+	if this.columns == nil {
+		this.columns = map[string]*tableColumnSet{}
+	}
+	this.columns[tableId] = &tableColumnSet{
+		names:   columns,
+		expires: time.Now().Add(tableKeyTtl),
+	}
+	this.columnsMutex.Unlock()
+	return columns, nil
+}
+
+// resolveColumn turns a client column reference, optionally qualified by the
+// table name, into a quoted column of the table.
+func resolveColumn(param string, ref string, tableId string, columns map[string]string, d Dialect) (string, string, error) {
+	m := columnRegexp.FindStringSubmatch(strings.TrimSpace(ref))
+	if m == nil {
+		return "", "", newParamError(param, ref, "Invalid column: "+ref)
+	}
+	name := m[1]
+	if m[2] != "" {
+		ts := strings.Split(unquoteIdentifier(tableId), ".")
+		if !strings.EqualFold(m[1], ts[len(ts)-1]) {
+			return "", "", newParamError(param, ref, "Unknown table: "+m[1])
+		}
+		name = m[2]
+	}
+	column, ok := columns[strings.ToUpper(name)]
+	if !ok {
+		return "", "", newParamError(param, ref, "Unknown column: "+name)
+	}
+	return column, d.QuoteIdentifier(column), nil
+}
+
+// resolveExpr accepts a column or one of the whitelisted aggregate functions
+// over a column.
+func resolveExpr(param string, expr string, tableId string, columns map[string]string, d Dialect) (string, string, error) {
+	expr = strings.TrimSpace(expr)
+	m := aggregateRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return resolveColumn(param, expr, tableId, columns, d)
+	}
+	fn := strings.ToUpper(m[1])
+	distinct := ""
+	if m[2] != "" {
+		distinct = "DISTINCT "
+	}
+	if m[3] == "*" {
+		if fn != "COUNT" || distinct != "" {
+			return "", "", newParamError(param, expr, "Invalid aggregate: "+expr)
+		}
+		return fn + "(*)", fn + "(*)", nil
+	}
+	column, quoted, err := resolveColumn(param, m[3], tableId, columns, d)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprint(fn, "(", distinct, column, ")"), fmt.Sprint(fn, "(", distinct, quoted, ")"), nil
+}
+
+// parseFieldList validates the fields parameter and rebuilds it from quoted
+// identifiers. Aggregates only take the readable columns, their values are
+// not masked.
+func parseFieldList(fields string, tableId string, columns map[string]string, readable map[string]string, d Dialect) (string, error) {
+	if strings.TrimSpace(fields) == "" || strings.TrimSpace(fields) == "*" {
+		return "*", nil
+	}
+	fieldColumns := func(expr string) map[string]string {
+		if aggregateRegexp.MatchString(strings.TrimSpace(expr)) {
+			return readable
+		}
+		return columns
+	}
+	ret := []string{}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		_, expr, err := resolveExpr("fields", field, tableId, fieldColumns(field), d)
+		if err != nil {
+			m := aliasRegexp.FindStringSubmatch(field)
+			if m == nil {
+				return "", err
+			}
+			_, expr, err = resolveExpr("fields", m[1], tableId, fieldColumns(m[1]), d)
+			if err != nil {
+				return "", err
+			}
+			expr = fmt.Sprint(expr, " AS ", d.QuoteIdentifier(m[2]))
+		}
+		ret = append(ret, expr)
+	}
+	return strings.Join(ret, ","), nil
+}
+
+// parseSortList parses a sort parameter such as "name:desc,created_at".
+func parseSortList(sort string, tableId string, columns map[string]string, d Dialect) ([]sortColumn, error) {
+	ret := []sortColumn{}
+	for _, s := range strings.Split(sort, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		if len(parts) > 2 {
+			return nil, newParamError("sort", s, "Invalid sort: "+s)
+		}
+		name, expr, err := resolveExpr("sort", parts[0], tableId, columns, d)
+		if err != nil {
+			return nil, err
+		}
+		desc := false
+		if len(parts) > 1 {
+			switch strings.ToUpper(strings.TrimSpace(parts[1])) {
+			case "DESC":
+				desc = true
+			case "ASC", "":
+			default:
+				return nil, newParamError("sort", s, "Invalid sort direction: "+parts[1])
+			}
+		}
+		ret = append(ret, sortColumn{Name: name, Expr: expr, Desc: desc})
+	}
+	return ret, nil
+}
+
+// parseGroupList validates the group parameter. Only plain columns are allowed.
+func parseGroupList(group string, tableId string, columns map[string]string, d Dialect) (string, error) {
+	ret := []string{}
+	for _, g := range strings.Split(group, ",") {
+		if strings.TrimSpace(g) == "" {
+			continue
+		}
+		_, expr, err := resolveColumn("group", g, tableId, columns, d)
+		if err != nil {
+			return "", err
+		}
+		ret = append(ret, expr)
+	}
+	return strings.Join(ret, ","), nil
+}
+
+func orderByClause(sortColumns []sortColumn) string {
+	if len(sortColumns) == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	for i, col := range sortColumns {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(col.Expr)
+		if col.Desc {
+			buffer.WriteString(" DESC")
+		} else {
+			buffer.WriteString(" ASC")
+		}
+	}
+	return fmt.Sprint(" ORDER BY ", buffer.String(), " ")
+}
+
+// parseLoadFields validates the fields of a single record load.
+func (this *MySqlDataOperator) parseLoadFields(q sqlQuerier, tableId string, fields string, context map[string]interface{}) (string, error) {
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return "", err
+	}
+	loadUserClaims(context)
+	return parseFieldList(fields, tableId, columns, readableColumns(tableId, columns, context), this.GetDialect())
+}
+
+// parseListParams validates fields, sort and group of a list request against
+// the table's columns. In cursor mode it also returns the keyset columns the
+// cursor is built from, with the table key appended as the tie breaker.
+func (this *MySqlDataOperator) parseListParams(q sqlQuerier, tableId string, fields string, sort string, group string,
+	context map[string]interface{}) (string, string, string, []sortColumn, error) {
+	d := this.GetDialect()
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	loadUserClaims(context)
+	readable := readableColumns(tableId, columns, context)
+	fields, err = parseFieldList(fields, tableId, columns, readable, d)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	group, err = parseGroupList(group, tableId, readable, d)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	sortColumns, err := parseSortList(sort, tableId, readable, d)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	if _, ok := context["cursor"]; !ok {
+		return fields, orderByClause(sortColumns), group, nil, nil
+	}
+	cursor, _ := context["cursor"].(string)
+	if group != "" {
+		return "", "", "", nil, newParamError("group", group, "Cursor paging cannot be used with group.")
+	}
+	key, err := this.tableKey(q, tableId)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	// The cursor is read from the last row, and NULLs would fall out of the
+	// keyset comparison, so the sort columns must be selected and not null.
+	for _, col := range sortColumns {
+		if strings.Contains(col.Name, "(") {
+			return "", "", "", nil, newParamError("sort", col.Name, "Cursor paging cannot sort by an aggregate: "+col.Name)
+		}
+		if key.Nullable[col.Name] {
+			return "", "", "", nil, newParamError("sort", col.Name, "Cursor paging cannot sort by a nullable column: "+col.Name)
+		}
+	}
+	for _, c := range key.Columns {
+		found := false
+		for _, col := range sortColumns {
+			if strings.EqualFold(col.Name, c) {
+				found = true
+			}
+		}
+		if !found {
+			sortColumns = append(sortColumns, sortColumn{Name: c, Expr: d.QuoteIdentifier(c)})
+		}
+	}
+	if fields != "*" {
+		selected := map[string]bool{}
+		for _, field := range strings.Split(fields, ",") {
+			selected[field] = true
+		}
+		for _, col := range sortColumns {
+			if !selected[col.Expr] {
+				return "", "", "", nil, newParamError("cursor", cursor, "Cursor paging needs the sort and key columns in fields: "+col.Name)
+			}
+		}
+	}
+	return fields, orderByClause(sortColumns), group, sortColumns, nil
+}
@@ -0,0 +1,87 @@
+// list_params
+package websql
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestSqliteListParams(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, SCORE INTEGER NOT NULL)",
+		"INSERT INTO ITEM VALUES (1, 5), (2, 3), (3, 5)")
+
+	_, _, err := dbo.ListMap("ITEM", "ID,NOPE", nil, "", "", 0, 10, testContext(t, app, nil))
+	requireStatus(t, err, 400)
+	_, _, err = dbo.ListMap("ITEM", "*", nil, "NOPE", "", 0, 10, testContext(t, app, nil))
+	requireStatus(t, err, 400)
+	_, _, _, err = dbo.ListArray("ITEM", "*", nil, "", "NOPE", 0, 10, testContext(t, app, nil))
+	requireStatus(t, err, 400)
+
+	_, rows, _, err := dbo.ListArrayTyped("ITEM", "SCORE,COUNT(*)", nil, "SCORE", "SCORE", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]interface{}{{int64(3), int64(1)}, {int64(5), int64(2)}}) {
+		t.Fatal("Expected the rows grouped by score:", rows)
+	}
+
+	context := testContext(t, app, nil)
+	context["cursor"] = ""
+	_, _, err = dbo.ListMap("ITEM", "SCORE,COUNT(*)", nil, "SCORE", "SCORE", 0, 10, context)
+	if requireStatus(t, err, 400).Param != "group" {
+		t.Fatal("Expected cursor paging with group rejected:", err)
+	}
+}
+
+// Without a user token a list fails the same way whether the table and
+// columns exist or not.
+func TestSqliteListParamsAfterAuth(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY)")
+
+	anonymous := func() map[string]interface{} {
+		context := testContext(t, app, nil)
+		delete(context, "user_token")
+		return context
+	}
+	for _, tableId := range []string{"ITEM", "MISSING"} {
+		_, _, err := dbo.ListMap(tableId, "NOPE", []string{"NOPE==1"}, "NOPE", "", 0, 10, anonymous())
+		if err == nil || err.Error() != "No user token." {
+			t.Fatal("Expected the missing user token reported for", tableId, "got", err)
+		}
+		_, _, _, err = dbo.ListArrayTyped(tableId, "*", nil, "", "NOPE", 0, 10, anonymous())
+		if err == nil || err.Error() != "No user token." {
+			t.Fatal("Expected the missing user token reported for", tableId, "got", err)
+		}
+	}
+}
+
+type scoreInterceptor struct {
+	DefaultDataInterceptor
+}
+
+func (this *scoreInterceptor) BeforeListMap(resourceId string, db *sql.DB, fields string, context map[string]interface{}, filter *string, sort *string, group *string, start int64, limit int64) error {
+	*filter += "AND SCORE>? "
+	context["where_args"] = append(whereArgs(context), 1)
+	return nil
+}
+
+// The args of the filters follow the args an interceptor adds.
+func TestSqliteListInterceptorArgs(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, SCORE INTEGER NOT NULL)",
+		"INSERT INTO ITEM VALUES (1, 1), (2, 3), (3, 5)")
+	Websql.Interceptors.RegisterDataInterceptor("ITEM", 100, &scoreInterceptor{})
+	t.Cleanup(func() {
+		delete(Websql.Interceptors.DataInterceptorRegistry, "ITEM")
+	})
+
+	ids := listIds(t, dbo, "ITEM", []string{"ID!=3"}, "ID", testContext(t, app, nil))
+	if !reflect.DeepEqual(ids, []interface{}{int64(2)}) {
+		t.Fatal("Expected the rows of both filters:", ids)
+	}
+}
@@ -3,7 +3,6 @@ package websql
 
 import (
 	"database/sql"
-	"encoding/json"
 	"time"
 )
 
@@ -18,23 +17,24 @@ type LoginInterceptor struct {
 }
 
 func (this *LoginInterceptor) AfterExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}, data *[][]interface{}) error {
-	// if the query name is login, encrypt the query result into a jwt token.
+	// if the query name is login, encrypt the query result into a jwt token,
+	// and open a session to refresh it.
 	tokenData := (*data)[0][0]
 	if v, ok := tokenData.([]map[string]string); ok && len(v) > 0 {
 		t, err := convertMapOfStringsToMapOfInterfaces(v[0])
 		if err != nil {
 			return err
 		}
-		t["exp"] = time.Now().Add(time.Hour * 72).Unix()
-		tokenPayload, err := json.Marshal(t)
+		appId, _ := context["app_id"].(string)
+		accessToken, refreshToken, err := openSession(appId, t)
 		if err != nil {
 			return err
 		}
-		s, err := createJwtToken(string(tokenPayload))
-		if err != nil {
-			return err
+		(*data)[0][0] = accessToken
+		if refreshToken != "" {
+			context["refresh_token"] = refreshToken
 		}
-		(*data)[0][0] = s
+		context["expires_in"] = int64(jwtAccessTtl / time.Second)
 	} else {
 		(*data)[0][0] = ""
 	}
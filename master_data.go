@@ -18,21 +18,26 @@ type Command struct {
 }
 
 type MasterData struct {
-	Version   int64
-	DataNodes []*DataNode
-	Apps      []*App
+	Version       int64
+	DataNodes     []*DataNode
+	Apps          []*App
+	SigningKeys   []*SigningKey
+	RevokedTokens []*RevokedToken
 }
 
 type DataNode struct {
-	Id       string
-	Name     string
-	Username string
-	Password string
-	Host     string
-	Port     int
-	Type     string
-	Note     string
-	Status   string
+	Id              string
+	Name            string
+	Username        string
+	Password        string
+	Host            string
+	Port            int
+	Type            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime int
+	Note            string
+	Status          string
 }
 type App struct {
 	Id                 string
@@ -44,6 +49,9 @@ type App struct {
 	Queries            []*Query
 	Jobs               []*Job
 	Tokens             []*Token
+	Roles              []*Role
+	Policies           []*Policy
+	SoftDeletes        []*SoftDelete
 	LocalInterceptors  []*LocalInterceptor
 	RemoteInterceptors []*RemoteInterceptor
 }
@@ -79,6 +87,54 @@ type Token struct {
 	Note   string
 	Status string
 }
+type Role struct {
+	Id          string
+	Name        string
+	AppId       string
+	Permissions []*Permission
+	Columns     []*ColumnRule
+	Note        string
+	Status      string
+}
+
+// A Permission grants the operations in Mode, e.g. "list,load" or "*", on the
+// tables and queries in Target, e.g. "orders,order_report" or "*".
+type Permission struct {
+	Mode   string
+	Target string
+}
+
+// A ColumnRule limits the columns of the tables and queries in Target a role
+// can read and write, see column_permissions.go. Allow and Deny are comma
+// separated columns, Mask maps columns to hide, null, partial or hash.
+type ColumnRule struct {
+	Target string
+	Allow  string
+	Deny   string
+	Mask   map[string]string
+}
+
+// A Policy restricts the rows of the tables in Target a user can read and
+// write to those matching the Read and Write predicates, see policy.go.
+type Policy struct {
+	Id     string
+	Name   string
+	AppId  string
+	Target string
+	Read   string
+	Write  string
+	Exempt string
+	Note   string
+	Status string
+}
+
+// A SoftDelete puts the table TableId of an app in soft delete mode, see
+// soft_delete.go. Deleted rows are purged after PurgeDays, never if 0.
+type SoftDelete struct {
+	TableId   string
+	AppId     string
+	PurgeDays int
+}
 type LocalInterceptor struct {
 	Id       string
 	Name     string
@@ -118,6 +174,9 @@ type ApiNode struct {
 }
 
 func (this *MasterData) AddDataNode(dataNode *DataNode) error {
+	if _, err := getDialect(dataNode.DriverName()); err != nil {
+		return err
+	}
 	for _, v := range this.DataNodes {
 		if v.Name == dataNode.Name {
 			return errors.New("Data node existed: " + dataNode.Name)
@@ -146,6 +205,11 @@ func (this *MasterData) RemoveDataNode(id string) error {
 	return Websql.masterData.Propagate()
 }
 func (this *MasterData) UpdateDataNode(dataNode *DataNode) error {
+	if dataNode.Type != "__not_set__" {
+		if _, err := getDialect(dataNode.DriverName()); err != nil {
+			return err
+		}
+	}
 	for i, v := range this.DataNodes {
 		if v.Id == dataNode.Id {
 			if dataNode.Name != "__not_set__" {
@@ -157,12 +221,24 @@ func (this *MasterData) UpdateDataNode(dataNode *DataNode) error {
 			if dataNode.Port != -1 {
 				v.Port = dataNode.Port
 			}
+			if dataNode.Type != "__not_set__" {
+				v.Type = dataNode.Type
+			}
 			if dataNode.Username != "__not_set__" {
 				v.Username = dataNode.Username
 			}
 			if dataNode.Password != "__not_set__" {
 				v.Password = dataNode.Password
 			}
+			if dataNode.MaxOpenConns != -1 {
+				v.MaxOpenConns = dataNode.MaxOpenConns
+			}
+			if dataNode.MaxIdleConns != -1 {
+				v.MaxIdleConns = dataNode.MaxIdleConns
+			}
+			if dataNode.ConnMaxLifetime != -1 {
+				v.ConnMaxLifetime = dataNode.ConnMaxLifetime
+			}
 			if dataNode.Note != "__not_set__" {
 				v.Note = dataNode.Note
 			}
@@ -179,7 +255,7 @@ func (this *MasterData) ListDataNodes(mode string) string {
 		if mode == "compact" {
 			buffer.WriteString(dataNode.Name + " ")
 		} else if mode == "full" {
-			buffer.WriteString(fmt.Sprintln(dataNode.Name, dataNode.Host))
+			buffer.WriteString(fmt.Sprintln(dataNode.Name, dataNode.Host, dataNode.DriverName()))
 		} else {
 			buffer.WriteString(dataNode.Name + "\n")
 		}
@@ -548,6 +624,243 @@ func (this *MasterData) UpdateToken(token *Token) error {
 	return errors.New("Token not found: " + token.Name)
 }
 
+func (this *MasterData) AddRole(role *Role) error {
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == role.AppId {
+			for _, vRole := range vApp.Roles {
+				if vRole.Name == role.Name && vRole.AppId == role.AppId {
+					return errors.New("Role existed: " + role.Name)
+				}
+			}
+			this.Apps[iApp].Roles = append(this.Apps[iApp].Roles, role)
+			this.Version++
+			return Websql.masterData.Propagate()
+		}
+	}
+	return errors.New("App does not exist: " + role.AppId)
+}
+func (this *MasterData) RemoveRole(id string, appId string) error {
+	for iApp, _ := range this.Apps {
+		if this.Apps[iApp].Id == appId {
+			for iRole, vRole := range this.Apps[iApp].Roles {
+				if vRole.Id == id && vRole.AppId == appId {
+					copy(this.Apps[iApp].Roles[iRole:], this.Apps[iApp].Roles[iRole+1:])
+					this.Apps[iApp].Roles[len(this.Apps[iApp].Roles)-1] = nil
+					this.Apps[iApp].Roles = this.Apps[iApp].Roles[:len(this.Apps[iApp].Roles)-1]
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Role not found: " + id)
+}
+func (this *MasterData) UpdateRole(role *Role) error {
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == role.AppId {
+			for iRole, vRole := range this.Apps[iApp].Roles {
+				if vRole.Id == role.Id && vRole.AppId == role.AppId {
+					if role.Name != "__not_set__" {
+						vRole.Name = role.Name
+					}
+					if role.Note != "__not_set__" {
+						vRole.Note = role.Note
+					}
+					if role.Status != "__not_set__" {
+						vRole.Status = role.Status
+					}
+
+					this.Apps[iApp].Roles[iRole] = vRole
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Role not found: " + role.Name)
+}
+
+// GrantRole sets the operations a role may do on a target, replacing what it
+// was granted on the same target before. An empty mode revokes the target.
+func (this *MasterData) GrantRole(role *Role) error {
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == role.AppId {
+			for _, vRole := range this.Apps[iApp].Roles {
+				if vRole.Id == role.Id && vRole.AppId == role.AppId {
+					for _, grant := range role.Permissions {
+						permissions := []*Permission{}
+						for _, p := range vRole.Permissions {
+							if p.Target != grant.Target {
+								permissions = append(permissions, p)
+							}
+						}
+						if grant.Mode != "" {
+							permissions = append(permissions, grant)
+						}
+						vRole.Permissions = permissions
+					}
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Role not found: " + role.Id)
+}
+
+// SetRoleColumns sets the column rule of a role for a target, replacing the
+// rule it had for the same target. A rule without columns removes it.
+func (this *MasterData) SetRoleColumns(role *Role) error {
+	for _, rule := range role.Columns {
+		err := checkColumnRule(rule)
+		if err != nil {
+			return err
+		}
+	}
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == role.AppId {
+			for _, vRole := range this.Apps[iApp].Roles {
+				if vRole.Id == role.Id && vRole.AppId == role.AppId {
+					for _, rule := range role.Columns {
+						columns := []*ColumnRule{}
+						for _, c := range vRole.Columns {
+							if c.Target != rule.Target {
+								columns = append(columns, c)
+							}
+						}
+						if rule.Allow != "" || rule.Deny != "" || len(rule.Mask) > 0 {
+							columns = append(columns, rule)
+						}
+						vRole.Columns = columns
+					}
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Role not found: " + role.Id)
+}
+
+func (this *MasterData) AddPolicy(policy *Policy) error {
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == policy.AppId {
+			for _, vPolicy := range vApp.Policies {
+				if vPolicy.Name == policy.Name && vPolicy.AppId == policy.AppId {
+					return errors.New("Policy existed: " + policy.Name)
+				}
+			}
+			this.Apps[iApp].Policies = append(this.Apps[iApp].Policies, policy)
+			this.Version++
+			return Websql.masterData.Propagate()
+		}
+	}
+	return errors.New("App does not exist: " + policy.AppId)
+}
+func (this *MasterData) RemovePolicy(id string, appId string) error {
+	for iApp, _ := range this.Apps {
+		if this.Apps[iApp].Id == appId {
+			for iPolicy, vPolicy := range this.Apps[iApp].Policies {
+				if vPolicy.Id == id && vPolicy.AppId == appId {
+					copy(this.Apps[iApp].Policies[iPolicy:], this.Apps[iApp].Policies[iPolicy+1:])
+					this.Apps[iApp].Policies[len(this.Apps[iApp].Policies)-1] = nil
+					this.Apps[iApp].Policies = this.Apps[iApp].Policies[:len(this.Apps[iApp].Policies)-1]
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Policy not found: " + id)
+}
+func (this *MasterData) UpdatePolicy(policy *Policy) error {
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == policy.AppId {
+			for iPolicy, vPolicy := range this.Apps[iApp].Policies {
+				if vPolicy.Id == policy.Id && vPolicy.AppId == policy.AppId {
+					if policy.Name != "__not_set__" {
+						vPolicy.Name = policy.Name
+					}
+					if policy.Target != "__not_set__" {
+						vPolicy.Target = policy.Target
+					}
+					if policy.Read != "__not_set__" {
+						vPolicy.Read = policy.Read
+					}
+					if policy.Write != "__not_set__" {
+						vPolicy.Write = policy.Write
+					}
+					if policy.Exempt != "__not_set__" {
+						vPolicy.Exempt = policy.Exempt
+					}
+					if policy.Note != "__not_set__" {
+						vPolicy.Note = policy.Note
+					}
+					if policy.Status != "__not_set__" {
+						vPolicy.Status = policy.Status
+					}
+
+					this.Apps[iApp].Policies[iPolicy] = vPolicy
+					this.Version++
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	return errors.New("Policy not found: " + policy.Name)
+}
+
+// SetSoftDelete puts a table of an app in soft delete mode, or changes the
+// purge days of a table already in it.
+func (this *MasterData) SetSoftDelete(softDelete *SoftDelete) error {
+	if !columnRegexp.MatchString(softDelete.TableId) || strings.Contains(softDelete.TableId, ".") {
+		return errors.New("Invalid table: " + softDelete.TableId)
+	}
+	if softDelete.PurgeDays < 0 {
+		return errors.New("Purge days cannot be negative.")
+	}
+	// The purge job reads the soft delete tables under the lock.
+	masterDataMutex.Lock()
+	for iApp, vApp := range this.Apps {
+		if vApp.Id == softDelete.AppId {
+			found := false
+			for _, vSoftDelete := range vApp.SoftDeletes {
+				if strings.EqualFold(vSoftDelete.TableId, softDelete.TableId) && vSoftDelete.AppId == softDelete.AppId {
+					vSoftDelete.PurgeDays = softDelete.PurgeDays
+					found = true
+				}
+			}
+			if !found {
+				this.Apps[iApp].SoftDeletes = append(this.Apps[iApp].SoftDeletes, softDelete)
+			}
+			this.Version++
+			masterDataMutex.Unlock()
+			return Websql.masterData.Propagate()
+		}
+	}
+	masterDataMutex.Unlock()
+	return errors.New("App does not exist: " + softDelete.AppId)
+}
+func (this *MasterData) RemoveSoftDelete(tableId string, appId string) error {
+	masterDataMutex.Lock()
+	for iApp, _ := range this.Apps {
+		if this.Apps[iApp].Id == appId {
+			for iSoftDelete, vSoftDelete := range this.Apps[iApp].SoftDeletes {
+				if strings.EqualFold(vSoftDelete.TableId, tableId) && vSoftDelete.AppId == appId {
+					copy(this.Apps[iApp].SoftDeletes[iSoftDelete:], this.Apps[iApp].SoftDeletes[iSoftDelete+1:])
+					this.Apps[iApp].SoftDeletes[len(this.Apps[iApp].SoftDeletes)-1] = nil
+					this.Apps[iApp].SoftDeletes = this.Apps[iApp].SoftDeletes[:len(this.Apps[iApp].SoftDeletes)-1]
+					this.Version++
+					masterDataMutex.Unlock()
+					return Websql.masterData.Propagate()
+				}
+			}
+		}
+	}
+	masterDataMutex.Unlock()
+	return errors.New("Soft delete table not found: " + tableId)
+}
+
 func (this *MasterData) AddLI(li *LocalInterceptor) error {
 	for iApp, vApp := range this.Apps {
 		if vApp.Id == li.AppId {
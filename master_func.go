@@ -20,7 +20,9 @@ func (this *WebSQL) processWsCommandMaster(conn *websocket.Conn, message []byte)
 			Type: "WS_REGISTER",
 			Data: "Failed to valid client secret.",
 		}
+		wsConnsMutex.Lock()
 		conn.WriteJSON(regCommand)
+		wsConnsMutex.Unlock()
 		conn.Close()
 		return errors.New(regCommand.Data)
 	}
@@ -39,28 +41,32 @@ func (this *WebSQL) processWsCommandMaster(conn *websocket.Conn, message []byte)
 			return err
 		}
 
-		Websql.wsConns[apiNode.Id] = conn
-		regCommand := &Command{
-			Type: "WS_REGISTER",
-			Data: "OK",
-		}
-		conn.WriteJSON(regCommand)
-		log.Println(conn.RemoteAddr(), "connected.")
-
+		masterDataMutex.Lock()
 		masterDataBytes, err := json.Marshal(this.masterData)
+		masterDataMutex.Unlock()
 		if err != nil {
 			conn.Close()
 			return err
 		}
+		regCommand := &Command{
+			Type: "WS_REGISTER",
+			Data: "OK",
+		}
 		masterDataCommand := &Command{
 			Type: "WS_MASTER_DATA",
 			Data: string(masterDataBytes),
 		}
+		// The node is only sent commands once it has the master data.
+		wsConnsMutex.Lock()
+		defer wsConnsMutex.Unlock()
+		conn.WriteJSON(regCommand)
+		log.Println(conn.RemoteAddr(), "connected.")
 		err = conn.WriteJSON(masterDataCommand)
 		if err != nil {
 			conn.Close()
 			return err
 		}
+		Websql.wsConns[apiNode.Id] = conn
 		log.Println(conn.RemoteAddr(), "master data sent.")
 	}
 	return nil
@@ -68,27 +74,52 @@ func (this *WebSQL) processWsCommandMaster(conn *websocket.Conn, message []byte)
 
 var masterDataMutex = &sync.Mutex{}
 
+// A websocket connection takes one writer at a time, so the master sends
+// everything to the api nodes under wsConnsMutex, which also guards
+// Websql.wsConns.
+var wsConnsMutex = &sync.Mutex{}
+
+// broadcastWsCommand sends a command to every api node, and returns the last
+// error.
+func broadcastWsCommand(command *Command) error {
+	wsConnsMutex.Lock()
+	defer wsConnsMutex.Unlock()
+	var err error
+	for _, conn := range Websql.wsConns {
+		e := conn.WriteJSON(command)
+		if e != nil {
+			log.Println(e)
+			err = e
+		}
+	}
+	return err
+}
+
+// removeWsConn forgets the connection of an api node that dropped.
+func removeWsConn(conn *websocket.Conn) {
+	wsConnsMutex.Lock()
+	defer wsConnsMutex.Unlock()
+	for k, v := range Websql.wsConns {
+		if v == conn {
+			delete(Websql.wsConns, k)
+			break
+		}
+	}
+}
+
 func (this *MasterData) Propagate() error {
 	masterDataMutex.Lock()
-	var err error
 	masterDataBytes, err := json.Marshal(this)
-	if err != nil {
-		return err
+	if err == nil {
+		err = ioutil.WriteFile(Websql.service.DataFile, masterDataBytes, 0600)
 	}
-	err = ioutil.WriteFile(Websql.service.DataFile, masterDataBytes, 0644)
+	masterDataMutex.Unlock()
 	if err != nil {
 		return err
 	}
-	masterDataMutex.Unlock()
-	masterDataCommand := &Command{
+	refreshDbos(this)
+	return broadcastWsCommand(&Command{
 		Type: "WS_MASTER_DATA",
 		Data: string(masterDataBytes),
-	}
-	for _, conn := range Websql.wsConns {
-		err = conn.WriteJSON(masterDataCommand)
-		if err != nil {
-			log.Println(err)
-		}
-	}
-	return err
+	})
 }
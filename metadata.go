@@ -0,0 +1,241 @@
+// metadata
+package websql
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type TableMeta struct {
+	Name        string
+	Type        string
+	Columns     []*ColumnMeta
+	PrimaryKey  []string
+	UniqueKeys  []*IndexMeta
+	ForeignKeys []*ForeignKeyMeta
+	Indexes     []*IndexMeta
+}
+type ColumnMeta struct {
+	Name          string
+	DataType      string
+	ColumnType    string
+	Nullable      bool
+	Default       interface{}
+	AutoIncrement bool
+}
+type IndexMeta struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Primary bool
+}
+type ForeignKeyMeta struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+}
+
+func metaString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(x)
+	}
+	return fmt.Sprint(v)
+}
+
+func metaBool(v interface{}) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case int64:
+		return x != 0
+	case float64:
+		return x != 0
+	}
+	s := metaString(v)
+	return s == "1" || strings.EqualFold(s, "true") || strings.EqualFold(s, "t")
+}
+
+// ListTables returns the name and type of each table and view in the app
+// database. Tables the caller may not read are left out.
+func (this *MySqlDataOperator) ListTables(context map[string]interface{}) ([]*TableMeta, error) {
+	ret := []*TableMeta{}
+	db, err := this.GetConn()
+	if err != nil {
+		return ret, err
+	}
+	d := this.GetDialect()
+	query, args := d.TablesQuery(extractDbNameFromDs(this.DbType, this.Ds))
+	_, data, err := queryToTypedArray(db, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return ret, err
+	}
+
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, row := range data {
+		name := metaString(row[0])
+		tableId := normalizeTableId(name, this.DbType, this.Ds)
+		allowed := true
+		for _, k := range globalSortedKeys {
+			globalDataInterceptor := globalDataInterceptors[k]
+			if globalDataInterceptor.BeforeMetaData(tableId, db, context) != nil {
+				allowed = false
+				break
+			}
+		}
+		dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+		for _, k := range sortedKeys {
+			dataInterceptor := dataInterceptors[k]
+			if allowed && dataInterceptor != nil && dataInterceptor.BeforeMetaData(tableId, db, context) != nil {
+				allowed = false
+			}
+		}
+		if allowed {
+			ret = append(ret, &TableMeta{Name: name, Type: metaString(row[1])})
+		}
+	}
+	return ret, nil
+}
+
+// MetaData describes a table: its columns, primary and unique keys, foreign
+// keys and indexes.
+func (this *MySqlDataOperator) MetaData(tableId string, context map[string]interface{}) (*TableMeta, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, err
+	}
+
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeMetaData(tableId, db, context)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeMetaData(tableId, db, context)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	d := this.GetDialect()
+	_, tableName := splitTableId(tableId)
+	meta := &TableMeta{
+		Name:        tableName,
+		Columns:     []*ColumnMeta{},
+		PrimaryKey:  []string{},
+		UniqueKeys:  []*IndexMeta{},
+		ForeignKeys: []*ForeignKeyMeta{},
+		Indexes:     []*IndexMeta{},
+	}
+
+	query, args := d.TablesQuery(extractDbNameFromDs(this.DbType, this.Ds))
+	_, tables, err := queryToTypedArray(db, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	for _, row := range tables {
+		if metaString(row[0]) == tableName {
+			meta.Type = metaString(row[1])
+		}
+	}
+	if meta.Type == "" {
+		return nil, &RequestError{
+			Status:  http.StatusNotFound,
+			Code:    "not_found",
+			Value:   tableName,
+			Message: "Table not found: " + tableName,
+		}
+	}
+
+	query, args = d.ColumnsMetaQuery(tableId)
+	_, columns, err := queryToTypedArray(db, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	for _, row := range columns {
+		meta.Columns = append(meta.Columns, &ColumnMeta{
+			Name:          metaString(row[0]),
+			DataType:      strings.ToLower(metaString(row[1])),
+			ColumnType:    strings.ToLower(metaString(row[2])),
+			Nullable:      metaBool(row[3]),
+			Default:       row[4],
+			AutoIncrement: metaBool(row[5]),
+		})
+	}
+
+	query, args = d.IndexesQuery(tableId)
+	_, indexes, err := queryToTypedArray(db, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var index *IndexMeta
+	for _, row := range indexes {
+		name := metaString(row[0])
+		if index == nil || index.Name != name {
+			index = &IndexMeta{
+				Name:    name,
+				Columns: []string{},
+				Unique:  metaBool(row[2]),
+				Primary: metaBool(row[3]),
+			}
+			meta.Indexes = append(meta.Indexes, index)
+			if index.Unique && !index.Primary {
+				meta.UniqueKeys = append(meta.UniqueKeys, index)
+			}
+		}
+		index.Columns = append(index.Columns, metaString(row[1]))
+		if index.Primary {
+			meta.PrimaryKey = append(meta.PrimaryKey, metaString(row[1]))
+		}
+	}
+
+	query, args = d.ForeignKeysQuery(tableId)
+	_, foreignKeys, err := queryToTypedArray(db, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var foreignKey *ForeignKeyMeta
+	for _, row := range foreignKeys {
+		name := metaString(row[0])
+		if foreignKey == nil || foreignKey.Name != name {
+			foreignKey = &ForeignKeyMeta{
+				Name:       name,
+				Columns:    []string{},
+				RefTable:   metaString(row[2]),
+				RefColumns: []string{},
+			}
+			meta.ForeignKeys = append(meta.ForeignKeys, foreignKey)
+		}
+		foreignKey.Columns = append(foreignKey.Columns, metaString(row[1]))
+		foreignKey.RefColumns = append(foreignKey.RefColumns, metaString(row[3]))
+	}
+
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			dataInterceptor.AfterMetaData(tableId, db, context, meta)
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterMetaData(tableId, db, context, meta)
+	}
+	return meta, nil
+}
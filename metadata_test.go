@@ -0,0 +1,79 @@
+// metadata
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSqliteMetaData(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE CUSTOMER (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL)",
+		"CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, CUSTOMER_ID INTEGER NOT NULL REFERENCES CUSTOMER (ID), "+
+			"CODE TEXT NOT NULL UNIQUE, STATUS TEXT DEFAULT 'new')",
+		"CREATE INDEX ORDERS_STATUS ON ORDERS (STATUS)",
+		"CREATE VIEW OPEN_ORDERS AS SELECT * FROM ORDERS WHERE STATUS='new'")
+
+	meta, err := dbo.MetaData("ORDERS", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "ORDERS" || meta.Type != "table" || len(meta.Columns) != 4 {
+		t.Fatal("Expected the table and its columns:", meta.Name, meta.Type, meta.Columns)
+	}
+	id, status := meta.Columns[0], meta.Columns[3]
+	if id.Name != "ID" || id.DataType != "integer" || id.Nullable || !id.AutoIncrement {
+		t.Fatal("Expected an auto increment key column:", id)
+	}
+	if status.Name != "STATUS" || !status.Nullable || status.Default != "'new'" || status.AutoIncrement {
+		t.Fatal("Expected a nullable column with a default:", status)
+	}
+	if !reflect.DeepEqual(meta.PrimaryKey, []string{"ID"}) {
+		t.Fatal("Expected the primary key:", meta.PrimaryKey)
+	}
+	if len(meta.UniqueKeys) != 1 || !reflect.DeepEqual(meta.UniqueKeys[0].Columns, []string{"CODE"}) {
+		t.Fatal("Expected the unique key on CODE:", meta.UniqueKeys)
+	}
+	found := false
+	for _, index := range meta.Indexes {
+		found = found || index.Name == "ORDERS_STATUS" && !index.Unique && reflect.DeepEqual(index.Columns, []string{"STATUS"})
+	}
+	if !found {
+		t.Fatal("Expected the index on STATUS:", meta.Indexes)
+	}
+	if len(meta.ForeignKeys) != 1 || meta.ForeignKeys[0].RefTable != "CUSTOMER" ||
+		!reflect.DeepEqual(meta.ForeignKeys[0].Columns, []string{"CUSTOMER_ID"}) ||
+		!reflect.DeepEqual(meta.ForeignKeys[0].RefColumns, []string{"ID"}) {
+		t.Fatal("Expected the foreign key to CUSTOMER:", meta.ForeignKeys)
+	}
+
+	_, err = dbo.MetaData("MISSING", testContext(t, app, nil))
+	requireStatus(t, err, 404)
+}
+
+// Tables the user's roles do not let them read are left out of the listing.
+func TestSqliteListTables(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", Roles: []*Role{
+		{Name: "clerk", AppId: "app", Permissions: []*Permission{{Target: "CUSTOMER,OPEN_ORDERS", Mode: "load,list"}}},
+	}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE CUSTOMER (ID INTEGER PRIMARY KEY)",
+		"CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, STATUS TEXT)",
+		"CREATE VIEW OPEN_ORDERS AS SELECT * FROM ORDERS WHERE STATUS='new'")
+
+	tables, err := dbo.ListTables(testContext(t, app, nil, "clerk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]string{}
+	for _, table := range tables {
+		listed[table.Name] = table.Type
+	}
+	if !reflect.DeepEqual(listed, map[string]string{"CUSTOMER": "table", "OPEN_ORDERS": "view"}) {
+		t.Fatal("Expected the readable tables and views only:", listed)
+	}
+
+	_, err = dbo.MetaData("ORDERS", testContext(t, app, nil, "clerk"))
+	requireStatus(t, err, 403)
+}
@@ -5,25 +5,52 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/elgs/exparser"
 	"github.com/elgs/gosqljson"
 	"github.com/satori/go.uuid"
 )
 
 type MySqlDataOperator struct {
 	*DefaultDataOperator
-	Ds     string
-	DbType string
-	db     *sql.DB
+	Ds              string
+	DbType          string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	db              *sql.DB
+	dbMutex         sync.Mutex
+	closed          bool
+	keys            map[string]*tableKey
+	keysMutex       sync.Mutex
+	columns         map[string]*tableColumnSet
+	columnsMutex    sync.Mutex
 }
 
 func (this *MySqlDataOperator) Load(tableId string, id string, fields string, context map[string]interface{}) (map[string]string, error) {
 	ret := make(map[string]string, 0)
 	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
 	db, err := this.GetConn()
+	if err != nil {
+		return ret, err
+	}
+	fields, err = this.parseLoadFields(db, tableId, fields, context)
+	if err != nil {
+		return ret, err
+	}
+	key, err := this.tableKey(db, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		return ret, err
+	}
+	keyValues, err := splitKey(id, len(key.Columns))
+	if err != nil {
+		return ret, err
+	}
 
 	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
 	for _, k := range globalSortedKeys {
@@ -49,14 +76,39 @@ func (this *MySqlDataOperator) Load(tableId string, id string, fields string, co
 	if extraFilter == nil {
 		extraFilter = ""
 	}
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		extraFilter = fmt.Sprint(extraFilter, " AND ", deleted)
+	}
+	policy, policyArgs, err := policyPredicate(tableId, false, context)
+	if err != nil {
+		return ret, err
+	}
+	if policy != "" {
+		extraFilter = fmt.Sprint(extraFilter, " AND ", policy)
+	}
 	c := context["case"].(string)
 
-	m, err := gosqljson.QueryDbToMap(db, c,
-		fmt.Sprint("SELECT ", fields, " FROM ", tableId, " WHERE ID=? ", extraFilter), id)
+	query := this.GetDialect().Rebind(fmt.Sprint("SELECT ", fields, " FROM ", tableId, " WHERE ", key.where(this.GetDialect()), " ", extraFilter))
+	var m []map[string]string
+	if tx := pinnedTx(context); tx != nil {
+		m, err = gosqljson.QueryTxToMap(tx, c, query, append(append([]interface{}{}, keyValues...), policyArgs...)...)
+	} else {
+		m, err = gosqljson.QueryDbToMap(db, c, query, append(append([]interface{}{}, keyValues...), policyArgs...)...)
+	}
 	if err != nil {
 		fmt.Println(err)
 		return ret, err
 	}
+	if len(m) > 0 {
+		var q sqlQuerier = db
+		if tx := pinnedTx(context); tx != nil {
+			q = tx
+		}
+		context["etag"], err = this.rowETag(q, tableId, key, keyValues)
+		if err != nil {
+			return ret, err
+		}
+	}
 
 	if len(m) == 0 {
 		m = []map[string]string{
@@ -90,20 +142,19 @@ func (this *MySqlDataOperator) ListMap(tableId string, fields string, filter []s
 	if err != nil {
 		return nil, -1, err
 	}
-	tx, err := db.Begin()
+	tx, err := beginRequestTx(db, context)
 	if err != nil {
-		tx.Rollback()
 		return nil, -1, err
 	}
 
-	sort = parseSort(sort)
-	where := parseFilters(filter)
+	where := this.listWhere(tableId, context)
 	//	fmt.Println(where)
 	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
 	for _, k := range globalSortedKeys {
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeListMap(tableId, db, fields, context, &where, &sort, &group, start, limit)
 		if err != nil {
+			rollbackRequestTx(tx, context)
 			return ret, -1, err
 		}
 	}
@@ -113,31 +164,55 @@ func (this *MySqlDataOperator) ListMap(tableId string, fields string, filter []s
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeListMap(tableId, db, fields, context, &where, &sort, &group, start, limit)
 			if err != nil {
+				rollbackRequestTx(tx, context)
 				return ret, -1, err
 			}
 		}
 	}
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	fields, sort, group, keyset, err := this.parseListParams(tx, tableId, fields, sort, group, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
 	c := context["case"].(string)
-	sqlQuery := fmt.Sprint("SELECT SQL_CALC_FOUND_ROWS ", fields, " FROM ", tableId, where, parseGroup(group), sort, " LIMIT ?,?")
-	cnt := -1
-	m, err := gosqljson.QueryTxToMap(tx, c, sqlQuery, start, limit)
+	sqlQuery, args, err := this.listQuery(tableId, fields, where, group, sort, start, limit, keyset, context)
 	if err != nil {
-		tx.Rollback()
-		fmt.Println(err)
+		rollbackRequestTx(tx, context)
 		return nil, -1, err
 	}
-
-	cntData, err := gosqljson.QueryTxToMap(tx, "upper",
-		fmt.Sprint("SELECT FOUND_ROWS()"))
+	m, err := gosqljson.QueryTxToMap(tx, c, sqlQuery, args...)
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
+		fmt.Println(err)
 		return nil, -1, err
 	}
-	cnt, err = strconv.Atoi(cntData[0]["FOUND_ROWS()"])
+
+	cnt, err := this.countRows(tx, tableId, fields, where, group, keyset, context)
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
 		return nil, -1, err
 	}
+	if keyset != nil {
+		err = setNextCursor(context, keyset, len(m), limit, func(column string) interface{} {
+			return stringMapRowValue(m[len(m)-1], column)
+		})
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return nil, -1, err
+		}
+	}
 
 	for _, k := range sortedKeys {
 		dataInterceptor := dataInterceptors[k]
@@ -149,7 +224,7 @@ func (this *MySqlDataOperator) ListMap(tableId string, fields string, filter []s
 		globalDataInterceptor := globalDataInterceptors[k]
 		globalDataInterceptor.AfterListMap(tableId, db, fields, context, &m, int64(cnt))
 	}
-	tx.Commit()
+	commitRequestTx(tx, context)
 
 	return m, int64(cnt), err
 }
@@ -160,19 +235,18 @@ func (this *MySqlDataOperator) ListArray(tableId string, fields string, filter [
 	if err != nil {
 		return nil, nil, -1, err
 	}
-	tx, err := db.Begin()
+	tx, err := beginRequestTx(db, context)
 	if err != nil {
-		tx.Rollback()
 		return nil, nil, -1, err
 	}
 
-	sort = parseSort(sort)
-	where := parseFilters(filter)
+	where := this.listWhere(tableId, context)
 	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
 	for _, k := range globalSortedKeys {
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeListArray(tableId, db, fields, context, &where, &sort, &group, start, limit)
 		if err != nil {
+			rollbackRequestTx(tx, context)
 			return nil, nil, -1, err
 		}
 	}
@@ -182,30 +256,53 @@ func (this *MySqlDataOperator) ListArray(tableId string, fields string, filter [
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeListArray(tableId, db, fields, context, &where, &sort, &group, start, limit)
 			if err != nil {
+				rollbackRequestTx(tx, context)
 				return nil, nil, -1, err
 			}
 		}
 	}
-
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	fields, sort, group, keyset, err := this.parseListParams(tx, tableId, fields, sort, group, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
 	c := context["case"].(string)
-	h, a, err := gosqljson.QueryTxToArray(tx, c,
-		fmt.Sprint("SELECT SQL_CALC_FOUND_ROWS ", fields, " FROM ", tableId, where, parseGroup(group), sort, " LIMIT ?,?"), start, limit)
+	sqlQuery, args, err := this.listQuery(tableId, fields, where, group, sort, start, limit, keyset, context)
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
 		return nil, nil, -1, err
 	}
-	cnt := -1
-	cntData, err := gosqljson.QueryTxToMap(tx, "upper",
-		fmt.Sprint("SELECT FOUND_ROWS()"))
+	h, a, err := gosqljson.QueryTxToArray(tx, c, sqlQuery, args...)
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
 		return nil, nil, -1, err
 	}
-	cnt, err = strconv.Atoi(cntData[0]["FOUND_ROWS()"])
+	cnt, err := this.countRows(tx, tableId, fields, where, group, keyset, context)
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
 		return nil, nil, -1, err
 	}
+	if keyset != nil {
+		err = setNextCursor(context, keyset, len(a), limit, func(column string) interface{} {
+			return stringArrayRowValue(h, a[len(a)-1], column)
+		})
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return nil, nil, -1, err
+		}
+	}
 
 	for _, k := range sortedKeys {
 		dataInterceptor := dataInterceptors[k]
@@ -217,7 +314,305 @@ func (this *MySqlDataOperator) ListArray(tableId string, fields string, filter [
 		globalDataInterceptor := globalDataInterceptors[k]
 		globalDataInterceptor.AfterListArray(tableId, db, fields, context, &h, &a, int64(cnt))
 	}
-	tx.Commit()
+	commitRequestTx(tx, context)
+
+	return h, a, int64(cnt), err
+}
+
+func (this *MySqlDataOperator) LoadTyped(tableId string, id string, fields string, context map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, 0)
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return ret, err
+	}
+	fields, err = this.parseLoadFields(db, tableId, fields, context)
+	if err != nil {
+		return ret, err
+	}
+	key, err := this.tableKey(db, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		return ret, err
+	}
+	keyValues, err := splitKey(id, len(key.Columns))
+	if err != nil {
+		return ret, err
+	}
+
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeLoad(tableId, db, fields, context, id)
+		if err != nil {
+			return ret, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeLoad(tableId, db, fields, context, id)
+			if err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	// Load the record
+	extraFilter := context["extra_filter"]
+	if extraFilter == nil {
+		extraFilter = ""
+	}
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		extraFilter = fmt.Sprint(extraFilter, " AND ", deleted)
+	}
+	policy, policyArgs, err := policyPredicate(tableId, false, context)
+	if err != nil {
+		return ret, err
+	}
+	if policy != "" {
+		extraFilter = fmt.Sprint(extraFilter, " AND ", policy)
+	}
+	c := context["case"].(string)
+
+	var q sqlQuerier = db
+	if tx := pinnedTx(context); tx != nil {
+		q = tx
+	}
+	m, err := queryToTypedMap(q, c,
+		this.GetDialect().Rebind(fmt.Sprint("SELECT ", fields, " FROM ", tableId, " WHERE ", key.where(this.GetDialect()), " ", extraFilter)), append(append([]interface{}{}, keyValues...), policyArgs...)...)
+	if err != nil {
+		fmt.Println(err)
+		return ret, err
+	}
+	if len(m) > 0 {
+		context["etag"], err = this.rowETag(q, tableId, key, keyValues)
+		if err != nil {
+			return ret, err
+		}
+	}
+	err = this.expandRows(q, tableId, m, context)
+	if err != nil {
+		return ret, err
+	}
+
+	if len(m) == 0 {
+		m = []map[string]interface{}{
+			make(map[string]interface{}, 0),
+		}
+	}
+
+	runStringLoadHooks(tableId, db, fields, context, m[0], afterInterceptors(tableId))
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			dataInterceptor.AfterLoadTyped(tableId, db, fields, context, m[0])
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterLoadTyped(tableId, db, fields, context, m[0])
+	}
+
+	if m != nil && len(m) == 1 {
+		return m[0], err
+	} else {
+		return ret, err
+	}
+}
+
+func (this *MySqlDataOperator) ListMapTyped(tableId string, fields string, filter []string, sort string, group string,
+	start int64, limit int64, context map[string]interface{}) ([]map[string]interface{}, int64, error) {
+	ret := make([]map[string]interface{}, 0)
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, -1, err
+	}
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	where := this.listWhere(tableId, context)
+	//	fmt.Println(where)
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeListMap(tableId, db, fields, context, &where, &sort, &group, start, limit)
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return ret, -1, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeListMap(tableId, db, fields, context, &where, &sort, &group, start, limit)
+			if err != nil {
+				rollbackRequestTx(tx, context)
+				return ret, -1, err
+			}
+		}
+	}
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	fields, sort, group, keyset, err := this.parseListParams(tx, tableId, fields, sort, group, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	c := context["case"].(string)
+	sqlQuery, args, err := this.listQuery(tableId, fields, where, group, sort, start, limit, keyset, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	m, err := queryToTypedMap(tx, c, sqlQuery, args...)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		fmt.Println(err)
+		return nil, -1, err
+	}
+
+	cnt, err := this.countRows(tx, tableId, fields, where, group, keyset, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	// Expand after counting, FOUND_ROWS() reports on the last select.
+	err = this.expandRows(tx, tableId, m, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, -1, err
+	}
+	if keyset != nil {
+		err = setNextCursor(context, keyset, len(m), limit, func(column string) interface{} {
+			return mapRowValue(m[len(m)-1], column)
+		})
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return nil, -1, err
+		}
+	}
+
+	runStringListMapHooks(tableId, db, fields, context, &m, int64(cnt), afterInterceptors(tableId))
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			dataInterceptor.AfterListMapTyped(tableId, db, fields, context, &m, int64(cnt))
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterListMapTyped(tableId, db, fields, context, &m, int64(cnt))
+	}
+	commitRequestTx(tx, context)
+
+	return m, int64(cnt), err
+}
+func (this *MySqlDataOperator) ListArrayTyped(tableId string, fields string, filter []string, sort string, group string,
+	start int64, limit int64, context map[string]interface{}) ([]string, [][]interface{}, int64, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	where := this.listWhere(tableId, context)
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeListArray(tableId, db, fields, context, &where, &sort, &group, start, limit)
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return nil, nil, -1, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeListArray(tableId, db, fields, context, &where, &sort, &group, start, limit)
+			if err != nil {
+				rollbackRequestTx(tx, context)
+				return nil, nil, -1, err
+			}
+		}
+	}
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	fields, sort, group, keyset, err := this.parseListParams(tx, tableId, fields, sort, group, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	c := context["case"].(string)
+	sqlQuery, args, err := this.listQuery(tableId, fields, where, group, sort, start, limit, keyset, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	h, a, err := queryToTypedArray(tx, c, sqlQuery, args...)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	cnt, err := this.countRows(tx, tableId, fields, where, group, keyset, context)
+	if err != nil {
+		rollbackRequestTx(tx, context)
+		return nil, nil, -1, err
+	}
+	if keyset != nil {
+		err = setNextCursor(context, keyset, len(a), limit, func(column string) interface{} {
+			return arrayRowValue(h, a[len(a)-1], column)
+		})
+		if err != nil {
+			rollbackRequestTx(tx, context)
+			return nil, nil, -1, err
+		}
+	}
+
+	runStringListArrayHooks(tableId, db, fields, context, &h, &a, int64(cnt), afterInterceptors(tableId))
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			dataInterceptor.AfterListArrayTyped(tableId, db, fields, context, &h, &a, int64(cnt))
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterListArrayTyped(tableId, db, fields, context, &h, &a, int64(cnt))
+	}
+	commitRequestTx(tx, context)
 
 	return h, a, int64(cnt), err
 }
@@ -231,9 +626,7 @@ func (this *MySqlDataOperator) Create(tableId string, data []map[string]interfac
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeCreate(tableId, db, context, data)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
@@ -243,20 +636,52 @@ func (this *MySqlDataOperator) Create(tableId string, data []map[string]interfac
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeCreate(tableId, db, context, data)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
 	}
+	err = policyTx(db, tableId, context)
+	if err != nil {
+		return nil, err
+	}
+	var q sqlQuerier = db
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		q = tx
+	}
+	key, err := this.tableKey(q, tableId)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	var upsert *upsertOptions
+	if mode, _ := context["mode"].(string); mode == "upsert" {
+		upsert, err = this.parseUpsertOptions(q, tableId, key, context)
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+	}
+
 	// Create the record
 	ret := []interface{}{}
+	written := [][]interface{}{}
 	for _, data1 := range data {
-		if data1["ID"] == nil || data1["ID"].(string) == "" {
-			data1["ID"] = strings.Replace(uuid.NewV4().String(), "-", "", -1)
+		// The key column is left to the database if it auto increments,
+		// a string ID is filled with a UUID.
+		generated := ""
+		newKey := false
+		if key.AutoIncrement {
+			if v, ok := data1[strings.ToUpper(key.Columns[0])]; !ok || v == nil {
+				generated = key.Columns[0]
+				newKey = true
+			}
+		} else if len(key.Columns) == 1 && strings.EqualFold(key.Columns[0], "ID") {
+			if id, ok := data1["ID"]; !ok || id == nil || id == "" {
+				data1["ID"] = strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				newKey = true
+			}
 		}
-		ret = append(ret, data1["ID"])
 		dataLen := len(data1)
 		values := make([]interface{}, 0, dataLen)
 		var fieldBuffer bytes.Buffer
@@ -275,20 +700,55 @@ func (this *MySqlDataOperator) Create(tableId string, data []map[string]interfac
 		}
 		fields := fieldBuffer.String()
 		qms := qmBuffer.String()
+		if upsert != nil {
+			var result map[string]interface{}
+			if tx, ok := context["tx"].(*sql.Tx); ok {
+				result, err = this.upsertRow(tx, tx, tableId, fields, qms, values, data1, key, generated, newKey, upsert)
+				if err != nil {
+					rollbackContextTx(context)
+					return nil, err
+				}
+			} else {
+				result, err = this.upsertRow(db, db, tableId, fields, qms, values, data1, key, generated, newKey, upsert)
+				if err != nil {
+					return nil, err
+				}
+			}
+			ret = append(ret, result)
+			if id := result["id"]; id != nil && result["result"] != "unchanged" {
+				if values, err := splitKey(keyValueString(id), len(key.Columns)); err == nil {
+					written = append(written, values)
+				}
+			}
+			continue
+		}
+		var newId interface{}
 		if tx, ok := context["tx"].(*sql.Tx); ok {
-			_, err = gosqljson.ExecTx(tx, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), values...)
+			newId, err = this.execInsert(tx, tx, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), values, generated)
 			if err != nil {
 				fmt.Println(err)
-				tx.Rollback()
+				rollbackContextTx(context)
 				return nil, err
 			}
 		} else {
-			_, err = gosqljson.ExecDb(db, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), values...)
+			newId, err = this.execInsert(db, db, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), values, generated)
 			if err != nil {
 				fmt.Println(err)
 				return nil, err
 			}
 		}
+		if generated != "" {
+			data1[strings.ToUpper(generated)] = newId
+		}
+		ret = append(ret, key.id(data1))
+		if values, err := key.values(data1); err == nil {
+			written = append(written, values)
+		}
+	}
+	err = this.checkPolicyRows(q, tableId, key, written, context)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
 	}
 
 	for _, k := range sortedKeys {
@@ -296,9 +756,7 @@ func (this *MySqlDataOperator) Create(tableId string, data []map[string]interfac
 		if dataInterceptor != nil {
 			err := dataInterceptor.AfterCreate(tableId, db, context, data)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
@@ -307,15 +765,15 @@ func (this *MySqlDataOperator) Create(tableId string, data []map[string]interfac
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.AfterCreate(tableId, db, context, data)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
 
-	if tx, ok := context["tx"].(*sql.Tx); ok {
-		tx.Commit()
+	err = commitContextTx(context)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
 	}
 
 	return ret, err
@@ -329,9 +787,7 @@ func (this *MySqlDataOperator) Update(tableId string, data []map[string]interfac
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeUpdate(tableId, db, context, data)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
@@ -341,25 +797,75 @@ func (this *MySqlDataOperator) Update(tableId string, data []map[string]interfac
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeUpdate(tableId, db, context, data)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
 	}
+	err = policyTx(db, tableId, context)
+	if err != nil {
+		return nil, err
+	}
+	var q sqlQuerier = db
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		q = tx
+	}
+	key, err := this.tableKey(q, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	keyWhere := key.where(this.GetDialect())
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	rowWhere := keyWhere
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", deleted)
+	}
+	if policy != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", policy)
+	}
+	versionColumn, err := this.versionColumn(q, tableId)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+
 	ret := []int64{}
+	written := [][]interface{}{}
 	// Update the record
-	for _, data1 := range data {
-		id := data1["ID"]
-		if id == nil {
-			fmt.Println("ID is not found.")
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+	for i, data1 := range data {
+		id, err := key.values(data1)
+		if err != nil {
+			rollbackContextTx(context)
 			return nil, err
 		}
-		delete(data1, "ID")
+		// The update only applies if the row still has the checked version.
+		current, err := this.checkVersion(q, tableId, key, id, expectedVersion(context, i), context)
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+		updateWhere := rowWhere + this.versionWhere(versionColumn, current)
+		if stamp, ok := data1["UPDATED_AT"]; ok && strings.EqualFold(versionColumn, "UPDATED_AT") {
+			if current == nil {
+				_, current, _, err = this.rowVersion(q, tableId, versionColumn, key, id, ContextTx(context) != nil)
+				if err != nil {
+					rollbackContextTx(context)
+					return nil, err
+				}
+			}
+			data1["UPDATED_AT"] = nextUpdatedAt(stamp, current)
+		}
+		for _, c := range key.Columns {
+			delete(data1, strings.ToUpper(c))
+		}
 		dataLen := len(data1)
 		values := make([]interface{}, 0, dataLen)
 		var buffer bytes.Buffer
@@ -367,66 +873,96 @@ func (this *MySqlDataOperator) Update(tableId string, data []map[string]interfac
 			buffer.WriteString(fmt.Sprint(k, "=?,"))
 			values = append(values, v)
 		}
-		values = append(values, id)
+		if _, ok := data1["VERSION"]; !ok && strings.EqualFold(versionColumn, "VERSION") {
+			version := this.GetDialect().QuoteIdentifier(versionColumn)
+			buffer.WriteString(fmt.Sprint(version, "=", version, "+1,"))
+		}
+		values = append(values, id...)
+		values = append(values, policyArgs...)
+		if updateWhere != rowWhere {
+			values = append(values, current)
+		}
 		sets := buffer.String()
 		sets = sets[0 : len(sets)-1]
 		var rowsAffected int64 = 0
 		if tx, ok := context["tx"].(*sql.Tx); ok {
 			load, _ := context["load"].(bool)
 			if load {
-				data, err := gosqljson.QueryTxToMap(tx, "upper", "SELECT * FROM "+tableId+" WHERE ID=?", id)
+				data, err := gosqljson.QueryTxToMap(tx, "upper", this.GetDialect().Rebind("SELECT * FROM "+tableId+" WHERE "+rowWhere), append(append([]interface{}{}, id...), policyArgs...)...)
 				if err != nil {
 					fmt.Println(err)
-					tx.Rollback()
+					rollbackContextTx(context)
 					return nil, err
 				}
-				if data == nil && len(data) != 1 {
-					tx.Rollback()
-					return nil, errors.New(id.(string) + " not found.")
+				if len(data) != 1 {
+					rollbackContextTx(context)
+					return nil, rowNotFound(joinKey(id))
 				} else {
 					context["old_data"] = data[0]
 				}
 			}
 
-			rowsAffected, err = gosqljson.ExecTx(tx, fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ID=?"), values...)
+			rowsAffected, err = gosqljson.ExecTx(tx, this.GetDialect().Rebind(fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ", updateWhere)), values...)
 			if err != nil {
 				fmt.Println(err)
-				tx.Rollback()
+				rollbackContextTx(context)
 				return nil, err
 			}
 		} else {
 			load, _ := context["load"].(bool)
 			if load {
-				data, err := gosqljson.QueryDbToMap(db, "upper", "SELECT * FROM "+tableId+" WHERE ID=?", id)
+				data, err := gosqljson.QueryDbToMap(db, "upper", this.GetDialect().Rebind("SELECT * FROM "+tableId+" WHERE "+rowWhere), append(append([]interface{}{}, id...), policyArgs...)...)
 				if err != nil {
 					fmt.Println(err)
 					return nil, err
 				}
-				if data == nil && len(data) != 1 {
-					return nil, errors.New(id.(string) + " not found.")
+				if len(data) != 1 {
+					return nil, rowNotFound(joinKey(id))
 				} else {
 					context["old_data"] = data[0]
 				}
 			}
 
-			rowsAffected, err = gosqljson.ExecDb(db, fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ID=?"), values...)
+			rowsAffected, err = gosqljson.ExecDb(db, this.GetDialect().Rebind(fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ", updateWhere)), values...)
 			if err != nil {
 				fmt.Println(err)
 				return nil, err
 			}
 		}
-		data1["ID"] = id
+		if rowsAffected == 0 && updateWhere != rowWhere {
+			// The row was changed or removed since the check.
+			err = this.versionMismatch(q, tableId, key, id, true, context)
+			rollbackContextTx(context)
+			return nil, err
+		}
+		if len(data) == 1 {
+			context["etag"], err = this.rowETag(q, tableId, key, id)
+			if err != nil {
+				rollbackContextTx(context)
+				return nil, err
+			}
+		}
+		for i, c := range key.Columns {
+			data1[strings.ToUpper(c)] = id[i]
+		}
+		if rowsAffected > 0 {
+			written = append(written, id)
+		}
 		ret = append(ret, rowsAffected)
 	}
+	// The new values may have moved a row outside the write policy.
+	err = this.checkPolicyRows(q, tableId, key, written, context)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
 
 	for _, k := range sortedKeys {
 		dataInterceptor := dataInterceptors[k]
 		if dataInterceptor != nil {
 			err := dataInterceptor.AfterUpdate(tableId, db, context, data)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
@@ -435,15 +971,15 @@ func (this *MySqlDataOperator) Update(tableId string, data []map[string]interfac
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.AfterUpdate(tableId, db, context, data)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
 
-	if tx, ok := context["tx"].(*sql.Tx); ok {
-		tx.Commit()
+	err = commitContextTx(context)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
 	}
 
 	return ret, err
@@ -457,9 +993,7 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeDuplicate(tableId, db, context, id)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
@@ -469,30 +1003,67 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeDuplicate(tableId, db, context, id)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
 	}
 
+	var q sqlQuerier = db
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		q = tx
+	}
+	key, err := this.tableKey(q, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	keyWhere := key.where(this.GetDialect())
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	rowWhere := keyWhere
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", deleted)
+	}
+	if policy != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", policy)
+	}
+
 	ret := []string{}
 	for _, id1 := range id {
-		newId := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+		keyValues, err := splitKey(id1, len(key.Columns))
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+		newId := ""
 		// Duplicate the record
 		if tx, ok := context["tx"].(*sql.Tx); ok {
 			data, err := gosqljson.QueryTxToMap(tx, "upper",
-				fmt.Sprint("SELECT * FROM ", tableId, " WHERE ID=?"), id1)
+				this.GetDialect().Rebind(fmt.Sprint("SELECT * FROM ", tableId, " WHERE ", rowWhere)), append(append([]interface{}{}, keyValues...), policyArgs...)...)
 			if data == nil || len(data) != 1 {
-				tx.Rollback()
+				rollbackContextTx(context)
+				if err == nil {
+					err = errors.New(id1 + " not found.")
+				}
 				return nil, err
 			}
 			newData := make(map[string]interface{}, len(data[0]))
 			for k, v := range data[0] {
 				newData[k] = v
 			}
-			newData["ID"] = newId
+			generated := ""
+			newId, generated, err = key.duplicateKey(tableId, newData)
+			if err != nil {
+				rollbackContextTx(context)
+				return nil, err
+			}
 
 			newDataLen := len(newData)
 			newValues := make([]interface{}, 0, newDataLen)
@@ -512,23 +1083,33 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 			}
 			fields := fieldBuffer.String()
 			qms := qmBuffer.String()
-			_, err = gosqljson.ExecTx(tx, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), newValues...)
+			insertedId, err := this.execInsert(tx, tx, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), newValues, generated)
 			if err != nil {
 				fmt.Println(err)
-				tx.Rollback()
+				rollbackContextTx(context)
 				return nil, err
 			}
+			if generated != "" {
+				newId = keyValueString(insertedId)
+			}
 		} else {
 			data, err := gosqljson.QueryDbToMap(db, "upper",
-				fmt.Sprint("SELECT * FROM ", tableId, " WHERE ID=?"), id1)
+				this.GetDialect().Rebind(fmt.Sprint("SELECT * FROM ", tableId, " WHERE ", rowWhere)), append(append([]interface{}{}, keyValues...), policyArgs...)...)
 			if data == nil || len(data) != 1 {
+				if err == nil {
+					err = errors.New(id1 + " not found.")
+				}
 				return nil, err
 			}
 			newData := make(map[string]interface{}, len(data[0]))
 			for k, v := range data[0] {
 				newData[k] = v
 			}
-			newData["ID"] = newId
+			generated := ""
+			newId, generated, err = key.duplicateKey(tableId, newData)
+			if err != nil {
+				return nil, err
+			}
 
 			newDataLen := len(newData)
 			newValues := make([]interface{}, 0, newDataLen)
@@ -548,11 +1129,14 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 			}
 			fields := fieldBuffer.String()
 			qms := qmBuffer.String()
-			_, err = gosqljson.ExecDb(db, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), newValues...)
+			insertedId, err := this.execInsert(db, db, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), newValues, generated)
 			if err != nil {
 				fmt.Println(err)
 				return nil, err
 			}
+			if generated != "" {
+				newId = keyValueString(insertedId)
+			}
 		}
 		ret = append(ret, newId)
 	}
@@ -562,9 +1146,7 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 		if dataInterceptor != nil {
 			err := dataInterceptor.AfterDuplicate(tableId, db, context, id, ret)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
@@ -573,15 +1155,15 @@ func (this *MySqlDataOperator) Duplicate(tableId string, id []string, context ma
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.AfterDuplicate(tableId, db, context, id, ret)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
 
-	if tx, ok := context["tx"].(*sql.Tx); ok {
-		tx.Commit()
+	err = commitContextTx(context)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
 	}
 
 	return ret, err
@@ -596,9 +1178,7 @@ func (this *MySqlDataOperator) Delete(tableId string, id []string, context map[s
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeDelete(tableId, db, context, id)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
@@ -608,63 +1188,124 @@ func (this *MySqlDataOperator) Delete(tableId string, id []string, context map[s
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeDelete(tableId, db, context, id)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
 	}
 
+	var q sqlQuerier = db
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		q = tx
+	}
+	key, err := this.tableKey(q, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	keyWhere := key.where(this.GetDialect())
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		rollbackContextTx(context)
+		return nil, err
+	}
+	rowWhere := keyWhere
+	if policy != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", policy)
+	}
+	deleteQuery := fmt.Sprint("DELETE FROM ", tableId, " WHERE ", rowWhere)
+	deleteArgs := []interface{}{}
+	if _, ok := softDeleteTable(tableId, context); ok {
+		// Soft delete stamps the row instead.
+		sets, args, err := this.softDeleteSets(q, tableId, false, context)
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+		deleteQuery = fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ", rowWhere, " AND DELETED_AT IS NULL")
+		deleteArgs = args
+	}
+
 	ret := []int64{}
-	for _, id1 := range id {
+	for i, id1 := range id {
+		keyValues, err := splitKey(id1, len(key.Columns))
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+		// The delete only applies if the row still has the checked version.
+		current, err := this.checkVersion(q, tableId, key, keyValues, expectedVersion(context, i), context)
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+		rowQuery := deleteQuery
+		rowArgs := append(append(append([]interface{}{}, deleteArgs...), keyValues...), policyArgs...)
+		if current != nil {
+			versionColumn, err := this.versionColumn(q, tableId)
+			if err != nil {
+				rollbackContextTx(context)
+				return nil, err
+			}
+			rowQuery += this.versionWhere(versionColumn, current)
+			rowArgs = append(rowArgs, current)
+		}
 		var rowsAffected int64 = 0
 		if tx, ok := context["tx"].(*sql.Tx); ok {
 			load, _ := context["load"].(bool)
 			if load {
-				data, err := gosqljson.QueryTxToMap(tx, "upper", "SELECT * FROM "+tableId+" WHERE ID=?", id1)
+				data, err := gosqljson.QueryTxToMap(tx, "upper", this.GetDialect().Rebind("SELECT * FROM "+tableId+" WHERE "+rowWhere), append(append([]interface{}{}, keyValues...), policyArgs...)...)
 				if err != nil {
 					fmt.Println(err)
-					tx.Rollback()
+					rollbackContextTx(context)
 					return nil, err
 				}
-				if data == nil && len(data) != 1 {
-					tx.Rollback()
-					return nil, errors.New(id1 + " not found.")
+				if len(data) != 1 {
+					rollbackContextTx(context)
+					return nil, rowNotFound(id1)
 				} else {
 					context["old_data"] = data[0]
 				}
 			}
 
 			// Delete the record
-			rowsAffected, err = gosqljson.ExecTx(tx, fmt.Sprint("DELETE FROM ", tableId, " WHERE ID=?"), id1)
+			rowsAffected, err = gosqljson.ExecTx(tx, this.GetDialect().Rebind(rowQuery), rowArgs...)
 			if err != nil {
 				fmt.Println(err)
-				tx.Rollback()
+				rollbackContextTx(context)
 				return nil, err
 			}
 		} else {
 			load, _ := context["load"].(bool)
 			if load {
-				data, err := gosqljson.QueryDbToMap(db, "upper", "SELECT * FROM "+tableId+" WHERE ID=?", id1)
+				data, err := gosqljson.QueryDbToMap(db, "upper", this.GetDialect().Rebind("SELECT * FROM "+tableId+" WHERE "+rowWhere), append(append([]interface{}{}, keyValues...), policyArgs...)...)
 				if err != nil {
 					fmt.Println(err)
 					return nil, err
 				}
-				if data == nil && len(data) != 1 {
-					return nil, errors.New(id1 + " not found.")
+				if len(data) != 1 {
+					return nil, rowNotFound(id1)
 				} else {
 					context["old_data"] = data[0]
 				}
 			}
 
 			// Delete the record
-			rowsAffected, err = gosqljson.ExecDb(db, fmt.Sprint("DELETE FROM ", tableId, " WHERE ID=?"), id1)
+			rowsAffected, err = gosqljson.ExecDb(db, this.GetDialect().Rebind(rowQuery), rowArgs...)
 			if err != nil {
 				fmt.Println(err)
 				return nil, err
 			}
 		}
+		if rowsAffected == 0 && current != nil {
+			// The row was changed or removed since the check.
+			err = this.versionMismatch(q, tableId, key, keyValues, true, context)
+			rollbackContextTx(context)
+			return nil, err
+		}
 		ret = append(ret, rowsAffected)
 	}
 
@@ -673,9 +1314,7 @@ func (this *MySqlDataOperator) Delete(tableId string, id []string, context map[s
 		if dataInterceptor != nil {
 			err := dataInterceptor.AfterDelete(tableId, db, context, id)
 			if err != nil {
-				if tx, ok := context["tx"].(*sql.Tx); ok {
-					tx.Rollback()
-				}
+				rollbackContextTx(context)
 				return nil, err
 			}
 		}
@@ -684,19 +1323,24 @@ func (this *MySqlDataOperator) Delete(tableId string, id []string, context map[s
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.AfterDelete(tableId, db, context, id)
 		if err != nil {
-			if tx, ok := context["tx"].(*sql.Tx); ok {
-				tx.Rollback()
-			}
+			rollbackContextTx(context)
 			return nil, err
 		}
 	}
-	if tx, ok := context["tx"].(*sql.Tx); ok {
-		tx.Commit()
+	err = commitContextTx(context)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
 	}
 	return ret, err
 }
 
 func (this *MySqlDataOperator) GetConn() (*sql.DB, error) {
+	this.dbMutex.Lock()
+	defer this.dbMutex.Unlock()
+	if this.closed {
+		return nil, errors.New("Data operator is closed.")
+	}
 	if this.db == nil {
 		if len(strings.TrimSpace(this.DbType)) == 0 {
 			this.DbType = "mysql"
@@ -706,11 +1350,40 @@ func (this *MySqlDataOperator) GetConn() (*sql.DB, error) {
 		if err != nil {
 			return nil, err
 		}
+		if this.MaxOpenConns > 0 {
+			db.SetMaxOpenConns(this.MaxOpenConns)
+		}
+		if this.MaxIdleConns > 0 {
+			db.SetMaxIdleConns(this.MaxIdleConns)
+		}
+		if this.ConnMaxLifetime > 0 {
+			db.SetConnMaxLifetime(this.ConnMaxLifetime)
+		}
 		this.db = db
 	}
 	return this.db, nil
 }
 
+// Close closes the connection pool. Queries already running are allowed to
+// finish first, new ones fail, the pool is not opened again.
+func (this *MySqlDataOperator) Close() error {
+	this.dbMutex.Lock()
+	defer this.dbMutex.Unlock()
+	this.closed = true
+	if this.db == nil {
+		return nil
+	}
+	err := this.db.Close()
+	this.db = nil
+	return err
+}
+
+// GetDialect returns the dialect of DbType, which NewDbo has checked.
+func (this *MySqlDataOperator) GetDialect() Dialect {
+	d, _ := getDialect(this.DbType)
+	return d
+}
+
 func extractDbNameFromDs(dbType string, ds string) string {
 	switch dbType {
 	case "sqlite3":
@@ -727,19 +1400,16 @@ func extractDbNameFromDs(dbType string, ds string) string {
 }
 
 func normalizeTableId(tableId string, dbType string, ds string) string {
+	d, _ := getDialect(dbType)
 	if strings.Contains(tableId, ".") {
 		a := strings.Split(tableId, ".")
-		return fmt.Sprint("`"+a[0], "`.`", a[1]+"`")
+		return fmt.Sprint(d.QuoteIdentifier(a[0]), ".", d.QuoteIdentifier(a[1]))
 	}
 	db := extractDbNameFromDs(dbType, ds)
 
 	MysqlSafe(&tableId)
-	if len(strings.TrimSpace(db)) == 0 {
-		return "`" + tableId + "`"
-	} else {
-		MysqlSafe(&db)
-		return fmt.Sprint("`"+db+"`", ".", "`"+tableId+"`")
-	}
+	MysqlSafe(&db)
+	return d.TableId(db, tableId)
 }
 
 func MysqlSafe(s *string) {
@@ -747,39 +1417,10 @@ func MysqlSafe(s *string) {
 	*s = strings.Replace(*s, "--", "", -1)
 }
 
-func parseSort(sort string) string {
-	if len(strings.TrimSpace(sort)) == 0 {
-		return ""
-	}
-	return fmt.Sprint(" ORDER BY ", strings.ToUpper(strings.Replace(sort, ":", " ", -1)), " ")
-}
-
-func parseFilter(filter string) string {
-	r, err := parser.Calculate(filter)
-	if err != nil {
-		fmt.Println(err)
-		return ""
-	}
-	return r
-}
-
-func parseFilters(filters []string) (r string) {
-	for _, v := range filters {
-		r += fmt.Sprint("AND ", parseFilter(v))
-	}
-	r = fmt.Sprint(" WHERE 1=1 ", r, " ")
-	//fmt.Println(r)
-	return
-}
-
-var parser = &exparser.Parser{
-	Operators: exparser.MysqlOperators,
-}
-
 func parseGroup(group string) (r string) {
 	if strings.TrimSpace(group) == "" {
 		return ""
 	}
-	r = fmt.Sprint(" GROUP BY ", strings.ToUpper(group))
+	r = fmt.Sprint(" GROUP BY ", group)
 	return
 }
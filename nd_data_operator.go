@@ -4,19 +4,37 @@ package websql
 import (
 	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
 type NdDataOperator struct {
-	*MySqlDataOperator
+	DataOperator
 }
 
-func NewDbo(ds, dbType string) DataOperator {
-	return &NdDataOperator{
-		MySqlDataOperator: &MySqlDataOperator{
-			Ds:     ds,
-			DbType: dbType,
-		},
+func NewDbo(ds, dbType string, dn *DataNode) (DataOperator, error) {
+	if _, err := getDialect(dbType); err != nil {
+		return nil, err
+	}
+	base := &MySqlDataOperator{
+		Ds:              ds,
+		DbType:          dbType,
+		MaxOpenConns:    dn.MaxOpenConns,
+		MaxIdleConns:    dn.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(dn.ConnMaxLifetime) * time.Second,
 	}
+	var dbo DataOperator = base
+	switch dbType {
+	case "postgres":
+		dbo = &PostgresDataOperator{MySqlDataOperator: base}
+	case "sqlite3":
+		dbo = &SqliteDataOperator{MySqlDataOperator: base}
+	}
+	return &NdDataOperator{
+		DataOperator: dbo,
+	}, nil
 }
 
 func (this *WebSQL) getQueryText(projectId, queryName string) (string, error) {
@@ -53,7 +71,7 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 	if err != nil {
 		return nil, err
 	}
-	tx, err := db.Begin()
+	tx, err := beginRequestTx(db, context)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +81,7 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeExec(tableId, scripts, &params, queryParams, array, db, context)
 		if err != nil {
+			rollbackRequestTx(tx, context)
 			return nil, err
 		}
 	}
@@ -72,16 +91,17 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 		if dataInterceptor != nil {
 			err := dataInterceptor.BeforeExec(tableId, scripts, &params, queryParams, array, db, context)
 			if err != nil {
+				rollbackRequestTx(tx, context)
 				return nil, err
 			}
 		}
 	}
 
 	replaceContext := buildReplaceContext(context)
-	retArray, err := batchExecuteTx(tx, nil, &scripts, queryParams, params, array, theCase, replaceContext)
+	retArray, err := batchExecuteTx(tx, nil, &scripts, queryParams, params, array, theCase, replaceContext, this.GetDialect())
 
 	if err != nil {
-		tx.Rollback()
+		rollbackRequestTx(tx, context)
 		return nil, err
 	}
 
@@ -96,44 +116,103 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 		globalDataInterceptor.AfterExec(tableId, scripts, &params, queryParams, array, db, context, &retArray)
 	}
 
-	tx.Commit()
+	commitRequestTx(tx, context)
 
 	return retArray, err
 }
 
-func MakeGetDbo(dbType string, masterData *MasterData) func(id string) (DataOperator, error) {
-	return func(id string) (DataOperator, error) {
-		ret := Websql.handlers.DboRegistry[id]
-		if ret != nil {
-			return ret, nil
+// Requests still holding a replaced data operator get this long to finish
+// before its connection pool is closed.
+var dboDrainTimeout = time.Second * 30
+
+var dboMutex = &sync.Mutex{}
+
+// dboFingerprint changes whenever a setting the data operator was built from
+// changes, so cached data operators can be rebuilt.
+func dboFingerprint(app *App, dn *DataNode) string {
+	return fmt.Sprint(dn.DriverName(), "|", app.DataSourceName(dn), "|", dn.MaxOpenConns, "|", dn.MaxIdleConns, "|", dn.ConnMaxLifetime)
+}
+
+func drainDbo(dbo DataOperator) {
+	time.AfterFunc(dboDrainTimeout, func() {
+		err := dbo.Close()
+		if err != nil {
+			log.Println(err)
 		}
+	})
+}
 
-		var app *App = nil
-		for _, a := range masterData.Apps {
-			if a.Id == id {
-				app = a
-				break
-			}
+func findAppDataNode(masterData *MasterData, id string) (*App, *DataNode, error) {
+	var app *App = nil
+	for _, a := range masterData.Apps {
+		if a.Id == id {
+			app = a
+			break
+		}
+	}
+	if app == nil {
+		return nil, nil, errors.New("App not found: " + id)
+	}
+
+	var dn *DataNode = nil
+	for _, vDn := range masterData.DataNodes {
+		if app.DataNodeId == vDn.Id {
+			dn = vDn
+			break
 		}
-		if app == nil {
-			return nil, errors.New("App not found: " + id)
+	}
+
+	if dn == nil {
+		return nil, nil, errors.New("Data node not found: " + app.DataNodeId)
+	}
+	return app, dn, nil
+}
+
+// refreshDbos drops the cached data operators of apps that were removed or
+// whose app or data node settings changed, and drains their connection pools.
+func refreshDbos(masterData *MasterData) {
+	dboMutex.Lock()
+	defer dboMutex.Unlock()
+	for id, dbo := range Websql.handlers.DboRegistry {
+		app, dn, err := findAppDataNode(masterData, id)
+		if err == nil && Websql.handlers.dboFingerprints[id] == dboFingerprint(app, dn) {
+			continue
 		}
+		delete(Websql.handlers.DboRegistry, id)
+		delete(Websql.handlers.dboFingerprints, id)
+		drainDbo(dbo)
+	}
+}
 
-		var dn *DataNode = nil
-		for _, vDn := range masterData.DataNodes {
-			if app.DataNodeId == vDn.Id {
-				dn = vDn
-				break
-			}
+func MakeGetDbo(dbType string, masterData *MasterData) func(id string) (DataOperator, error) {
+	return func(id string) (DataOperator, error) {
+		dboMutex.Lock()
+		defer dboMutex.Unlock()
+
+		app, dn, err := findAppDataNode(masterData, id)
+		if err != nil {
+			return nil, err
 		}
 
-		if dn == nil {
-			return nil, errors.New("Data node not found: " + app.DataNodeId)
+		fingerprint := dboFingerprint(app, dn)
+		ret := Websql.handlers.DboRegistry[id]
+		if ret != nil {
+			if Websql.handlers.dboFingerprints[id] == fingerprint {
+				return ret, nil
+			}
+			drainDbo(ret)
 		}
 
-		ds := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", app.DbName, id, dn.Host, dn.Port, "nd_"+app.DbName)
-		ret = NewDbo(ds, dbType)
+		nodeDbType := dbType
+		if len(strings.TrimSpace(dn.Type)) > 0 {
+			nodeDbType = dn.Type
+		}
+		ret, err = NewDbo(app.DataSourceName(dn), nodeDbType, dn)
+		if err != nil {
+			return nil, err
+		}
 		Websql.handlers.DboRegistry[id] = ret
+		Websql.handlers.dboFingerprints[id] = fingerprint
 		return ret, nil
 	}
 }
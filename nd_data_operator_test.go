@@ -0,0 +1,72 @@
+// nd_data_operator
+package websql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSqlitePoolSettings(t *testing.T) {
+	newTestDbo(t)
+	app := newTestApp(t, &App{Id: "app", DbName: "test", DataNodeId: "dn"})
+	dn := &DataNode{Id: "dn", Name: "dn", Type: "sqlite3", Host: t.TempDir(), MaxOpenConns: 3, ConnMaxLifetime: 60}
+	Websql.masterData.DataNodes = []*DataNode{dn}
+	drainTimeout := dboDrainTimeout
+	dboDrainTimeout = 0
+	t.Cleanup(func() {
+		dboDrainTimeout = drainTimeout
+		if dbo, ok := Websql.handlers.DboRegistry[app.Id]; ok {
+			dbo.Close()
+		}
+		delete(Websql.handlers.DboRegistry, app.Id)
+		delete(Websql.handlers.dboFingerprints, app.Id)
+	})
+	getDbo := MakeGetDbo("mysql", Websql.masterData)
+
+	dbo, err := getDbo(app.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbo.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := db.Stats().MaxOpenConnections; max != 3 {
+		t.Fatal("Expected the pool limited to 3 connections, got", max)
+	}
+	if same, _ := getDbo(app.Id); same != dbo {
+		t.Fatal("Expected the data operator cached.")
+	}
+
+	// A changed data node gets a new pool, the old one is drained.
+	dn.MaxOpenConns = 5
+	refreshDbos(Websql.masterData)
+	if _, ok := Websql.handlers.DboRegistry[app.Id]; ok {
+		t.Fatal("Expected the stale data operator dropped.")
+	}
+	newDbo, err := getDbo(app.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newDbo == dbo {
+		t.Fatal("Expected a new data operator.")
+	}
+	db, err = newDbo.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := db.Stats().MaxOpenConnections; max != 5 {
+		t.Fatal("Expected the pool limited to 5 connections, got", max)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = dbo.GetConn()
+		if err != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err == nil {
+		t.Fatal("Expected the drained data operator closed for good.")
+	}
+}
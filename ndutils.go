@@ -11,7 +11,6 @@ import (
 	"strings"
 	//	"time"
 
-	"github.com/dvsekhvalnov/jose2go"
 	"github.com/elgs/gojq"
 	"github.com/elgs/gosplitargs"
 	"github.com/elgs/gosqljson"
@@ -52,7 +51,7 @@ func httpRequest(url string, method string, data string, maxReadLimit int64) ([]
 	return result, res.StatusCode, err
 }
 
-func batchExecuteTx(tx *sql.Tx, db *sql.DB, script *string, scriptParams map[string]string, params [][]interface{}, array bool, theCase string, replaceContext map[string]string) ([][]interface{}, error) {
+func batchExecuteTx(tx *sql.Tx, db *sql.DB, script *string, scriptParams map[string]string, params [][]interface{}, array bool, theCase string, replaceContext map[string]string, dialect Dialect) ([][]interface{}, error) {
 	ret := [][]interface{}{}
 
 	innerTrans := false
@@ -101,6 +100,9 @@ func batchExecuteTx(tx *sql.Tx, db *sql.DB, script *string, scriptParams map[str
 				}
 				return nil, errors.New(fmt.Sprintln("Incorrect param count. Expected: ", totalCount+count, " actual: ", len(params1)))
 			}
+			if dialect != nil {
+				s = dialect.Rebind(s)
+			}
 			isQ := isQuery(s)
 			if isQ {
 				if array {
@@ -244,9 +246,3 @@ func isQuery(sql string) bool {
 	}
 	return false
 }
-
-func createJwtToken(payload string) (string, error) {
-	key := []byte(Websql.service.Secret)
-	token, err := jose.Sign(payload, jose.HS256, key)
-	return token, err
-}
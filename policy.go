@@ -0,0 +1,220 @@
+// policy
+package websql
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A row policy limits the rows of a table a user can see and change. Read is
+// ANDed to lists and loads, Write to updates, deletes, restores and
+// duplicates. Both are SQL predicates over the table's columns, where :name
+// stands for a claim of the user token, e.g.
+//
+//	OWNER = :user_email
+//	TENANT_ID = :tenant_id AND STATUS <> 'archived'
+//	REGION IN (:regions)
+//
+// :user_email and :client_ip are the caller's, other names are looked up in
+// the token's claims regardless of case. The values are bound, an array
+// claim binds one value per element. Users with one of the Exempt roles are
+// not restricted. All policies matching a table apply.
+//
+// Write also applies to the conflicting row of an upsert, and to the rows a
+// create, update or upsert leaves behind, like WITH CHECK, so that a row
+// cannot be written or moved outside the policy. A write to a table with a
+// write policy always runs in a transaction, even with atomic=false.
+
+// policyPredicate returns the read or write predicate of a table for the user
+// of a request with its bound arguments, or "" if no policy applies.
+func policyPredicate(tableId string, write bool, context map[string]interface{}) (string, []interface{}, error) {
+	app := contextApp(context)
+	if app == nil {
+		return "", nil, nil
+	}
+	predicates := []string{}
+	args := []interface{}{}
+	for _, policy := range app.Policies {
+		if policy.AppId != app.Id || policy.Status == "disabled" {
+			continue
+		}
+		if !checkAccessPermission(policy.Target, tableId, "*", "") || policyExempt(policy, context) {
+			continue
+		}
+		template := policy.Read
+		if write {
+			template = policy.Write
+		}
+		if strings.TrimSpace(template) == "" {
+			continue
+		}
+		predicate, policyArgs, err := bindPolicy(template, context)
+		if err != nil {
+			return "", nil, err
+		}
+		predicates = append(predicates, "("+predicate+")")
+		args = append(args, policyArgs...)
+	}
+	return strings.Join(predicates, " AND "), args, nil
+}
+
+func policyExempt(policy *Policy, context map[string]interface{}) bool {
+	userRoles, _ := context["user_roles"].([]string)
+	for _, exempt := range strings.Split(policy.Exempt, ",") {
+		for _, userRole := range userRoles {
+			if strings.TrimSpace(exempt) != "" && strings.TrimSpace(exempt) == userRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bindPolicy replaces the :name parameters of a policy template with
+// placeholders and returns their values. Quoted strings and identifiers and
+// :: casts are left alone.
+func bindPolicy(template string, context map[string]interface{}) (string, []interface{}, error) {
+	var buffer bytes.Buffer
+	args := []interface{}{}
+	var quote byte
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			buffer.WriteByte(c)
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(template) && template[i+1] == ':':
+			buffer.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(template) && isPolicyNameStart(template[i+1]):
+			j := i + 1
+			for j < len(template) && (isPolicyNameStart(template[j]) || template[j] >= '0' && template[j] <= '9') {
+				j++
+			}
+			name := template[i+1 : j]
+			value, ok := policyValue(name, context)
+			if !ok {
+				return "", nil, &RequestError{
+					Status:  http.StatusForbidden,
+					Code:    "forbidden",
+					Param:   name,
+					Message: "Permission denied, the user token has no " + name + ".",
+				}
+			}
+			if values, ok := value.([]interface{}); ok {
+				if len(values) == 0 {
+					buffer.WriteString("NULL")
+				} else {
+					buffer.WriteString(strings.TrimSuffix(strings.Repeat("?,", len(values)), ","))
+					args = append(args, values...)
+				}
+			} else {
+				buffer.WriteString("?")
+				args = append(args, value)
+			}
+			i = j - 1
+			continue
+		}
+		buffer.WriteByte(c)
+	}
+	return buffer.String(), args, nil
+}
+
+func isPolicyNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// policyValue looks up a policy parameter for the user of a request.
+func policyValue(name string, context map[string]interface{}) (interface{}, bool) {
+	switch strings.ToLower(name) {
+	case "user_email", "client_ip":
+		value, ok := context[strings.ToLower(name)]
+		return value, ok && value != nil
+	}
+	claims, _ := context["user_claims"].(map[string]interface{})
+	for k, v := range claims {
+		if strings.EqualFold(k, name) && v != nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// policyWhere ANDs the read or write predicate of a table to a WHERE clause
+// and appends its arguments to args.
+func policyWhere(where string, args []interface{}, tableId string, write bool,
+	context map[string]interface{}) (string, []interface{}, error) {
+	predicate, policyArgs, err := policyPredicate(tableId, write, context)
+	if err != nil || predicate == "" {
+		return where, args, err
+	}
+	return where + " AND " + predicate, append(append([]interface{}{}, args...), policyArgs...), nil
+}
+
+// policyFilter ANDs the read predicate of a table to the WHERE clause of a
+// list and binds its arguments after those in context["where_args"]. It runs
+// after the Before hooks, which read the user token.
+func policyFilter(where string, tableId string, context map[string]interface{}) (string, error) {
+	where, args, err := policyWhere(where, whereArgs(context), tableId, false, context)
+	if err != nil {
+		return "", err
+	}
+	context["where_args"] = args
+	return where, nil
+}
+
+// policyTx begins the transaction of a write to a table with a write policy
+// if the request has none, so that rows failing checkPolicyRows can be rolled
+// back. The data operator commits it with the write.
+func policyTx(db *sql.DB, tableId string, context map[string]interface{}) error {
+	if ContextTx(context) != nil {
+		return nil
+	}
+	policy, _, err := policyPredicate(tableId, true, context)
+	if err != nil || policy == "" {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	context["tx"] = tx
+	return nil
+}
+
+// checkPolicyRows makes sure the rows a write left behind, by their keys,
+// still meet the write policy.
+func (this *MySqlDataOperator) checkPolicyRows(q sqlQuerier, tableId string, key *tableKey, ids [][]interface{},
+	context map[string]interface{}) error {
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil || policy == "" || len(key.Columns) == 0 {
+		return err
+	}
+	d := this.GetDialect()
+	query := d.Rebind(fmt.Sprint("SELECT 1 FROM ", tableId, " WHERE ", key.where(d), " AND ", policy))
+	for _, id := range ids {
+		_, rows, err := queryToTypedArray(q, "", query, append(append([]interface{}{}, id...), policyArgs...)...)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return &RequestError{
+				Status:  http.StatusForbidden,
+				Code:    "policy_violation",
+				Value:   joinKey(id),
+				Message: "Row would be outside the write policy: " + joinKey(id),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// policy
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindPolicy(t *testing.T) {
+	context := map[string]interface{}{
+		"user_email":  "a@example.com",
+		"user_claims": map[string]interface{}{"Tenant_Id": "t1", "regions": []interface{}{"eu", "us"}, "none": []interface{}{}},
+	}
+	cases := []struct {
+		template string
+		expected string
+		args     []interface{}
+	}{
+		{"OWNER = :user_email", "OWNER = ?", []interface{}{"a@example.com"}},
+		{"TENANT_ID = :tenant_id AND NOTE <> ':tenant_id'", "TENANT_ID = ? AND NOTE <> ':tenant_id'", []interface{}{"t1"}},
+		{"REGION IN (:regions) AND CREATED::date > '2024-01-01'", "REGION IN (?,?) AND CREATED::date > '2024-01-01'", []interface{}{"eu", "us"}},
+		{"REGION IN (:none)", "REGION IN (NULL)", []interface{}{}},
+	}
+	for _, c := range cases {
+		predicate, args, err := bindPolicy(c.template, context)
+		if err != nil {
+			t.Error(c.template, err)
+			continue
+		}
+		if predicate != c.expected || !reflect.DeepEqual(args, c.args) {
+			t.Error("Expected", c.expected, c.args, "got", predicate, args)
+		}
+	}
+	_, _, err := bindPolicy("OWNER = :owner", context)
+	requireStatus(t, err, 403)
+}
+
+func TestSqlitePolicies(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", Policies: []*Policy{{
+		Id:     "tenant",
+		AppId:  "app",
+		Target: "DOC",
+		Read:   "TENANT_ID = :tenant_id",
+		Write:  "TENANT_ID = :tenant_id",
+		Exempt: "admin",
+	}}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE DOC (ID TEXT PRIMARY KEY, TENANT_ID TEXT NOT NULL, TITLE TEXT, "+testAuditColumns+")",
+		"CREATE TABLE COMMENT (ID INTEGER PRIMARY KEY, DOC_ID TEXT REFERENCES DOC (ID), BODY TEXT)",
+		"INSERT INTO DOC (ID, TENANT_ID, TITLE) VALUES ('d1', 't1', 'public'), ('d2', 't2', 'secret')",
+		"INSERT INTO COMMENT VALUES (1, 'd1', 'a'), (2, 'd2', 'b')")
+	t1 := map[string]interface{}{"tenant_id": "t1"}
+	all := []interface{}{"d1", "d2"}
+
+	if ids := listIds(t, dbo, "DOC", nil, "ID", testContext(t, app, t1)); !reflect.DeepEqual(ids, []interface{}{"d1"}) {
+		t.Fatal("Expected only the rows of the tenant, got", ids)
+	}
+	if ids := listIds(t, dbo, "DOC", nil, "ID", testContext(t, app, nil, "admin")); !reflect.DeepEqual(ids, all) {
+		t.Fatal("Expected every row for an exempt role, got", ids)
+	}
+	// A filter on a related row sees only the related rows the user may read.
+	if ids := listIds(t, dbo, "COMMENT", []string{"DOC.TITLE==secret"}, "ID", testContext(t, app, t1)); len(ids) != 0 {
+		t.Fatal("Expected no comments on a row of another tenant, got", ids)
+	}
+	row, err := dbo.LoadTyped("DOC", "d2", "*", testContext(t, app, t1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != 0 {
+		t.Fatal("Expected the row of another tenant not found:", row)
+	}
+	_, _, err = dbo.ListMapTyped("DOC", "*", nil, "", "", 0, 10, testContext(t, app, nil))
+	requireStatus(t, err, 403)
+
+	updated, err := dbo.Update("DOC", []map[string]interface{}{{"ID": "d2", "TITLE": "x"}}, testContext(t, app, t1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated[0] != 0 {
+		t.Fatal("Expected the row of another tenant not updated:", updated)
+	}
+	deleted, err := dbo.Delete("DOC", []string{"d2"}, testContext(t, app, t1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted[0] != 0 {
+		t.Fatal("Expected the row of another tenant not deleted:", deleted)
+	}
+
+	// A row cannot be written to or moved into another tenant.
+	_, err = dbo.Update("DOC", []map[string]interface{}{{"ID": "d1", "TENANT_ID": "t2"}}, testContext(t, app, t1))
+	if requestError := requireStatus(t, err, 403); requestError.Code != "policy_violation" {
+		t.Fatal("Expected a policy violation, got", requestError.Code)
+	}
+	_, err = dbo.Create("DOC", []map[string]interface{}{{"ID": "d3", "TENANT_ID": "t2"}}, testContext(t, app, t1))
+	requireStatus(t, err, 403)
+	context := testContext(t, app, t1)
+	context["mode"] = "upsert"
+	_, err = dbo.Create("DOC", []map[string]interface{}{{"ID": "d2", "TENANT_ID": "t1", "TITLE": "x"}}, context)
+	if requestError := requireStatus(t, err, 409); requestError.Code != "conflict_not_writable" {
+		t.Fatal("Expected the conflicting row not writable, got", requestError.Code)
+	}
+
+	rows, _, err := dbo.ListMapTyped("DOC", "*", nil, "ID", "", 0, 10, testContext(t, app, nil, "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["TENANT_ID"] != "t1" || rows[1]["TITLE"] != "secret" {
+		t.Fatal("Expected the rows unchanged:", rows)
+	}
+}
@@ -0,0 +1,17 @@
+// postgres_data_operator
+package websql
+
+type PostgresDataOperator struct {
+	*MySqlDataOperator
+}
+
+// NewPostgresDataOperator shares the MySQL operator's implementation. All SQL
+// differences are handled by PostgresDialect, which is picked by DbType.
+func NewPostgresDataOperator(ds string) *PostgresDataOperator {
+	return &PostgresDataOperator{
+		MySqlDataOperator: &MySqlDataOperator{
+			Ds:     ds,
+			DbType: "postgres",
+		},
+	}
+}
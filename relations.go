@@ -0,0 +1,111 @@
+// relations
+package websql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Relation links the rows of a table to the rows of a related table whose
+// RefColumns equal the table's Columns. Many is true when a row has many
+// related rows.
+type Relation struct {
+	Name       string
+	Table      string
+	Columns    []string
+	RefColumns []string
+	Many       bool
+}
+
+// RegisterRelation declares a relation of a table that the database does not
+// know as a foreign key, e.g. between views. tableId is either the table name
+// or db.table.
+func (this *WebSQL) RegisterRelation(tableId string, relation *Relation) {
+	id := strings.ToUpper(unquoteIdentifier(tableId))
+	this.Relations[id] = append(this.Relations[id], relation)
+}
+
+func registeredRelation(tableId string, name string) *Relation {
+	id := strings.ToUpper(unquoteIdentifier(tableId))
+	_, table := splitTableId(id)
+	for _, relations := range [][]*Relation{Websql.Relations[id], Websql.Relations[table]} {
+		for _, relation := range relations {
+			if strings.EqualFold(relation.Name, name) {
+				return relation
+			}
+		}
+	}
+	return nil
+}
+
+// findRelation resolves a relation of a table by name: a registered relation,
+// else a foreign key of the table to the table of that name, else a foreign
+// key of the table of that name to the table. The returned relation has its
+// Table normalized.
+func (this *MySqlDataOperator) findRelation(q sqlQuerier, tableId string, name string) (*Relation, error) {
+	if registered := registeredRelation(tableId, name); registered != nil {
+		relation := *registered
+		relation.Table = normalizeTableId(relation.Table, this.DbType, this.Ds)
+		return &relation, nil
+	}
+	if !columnRegexp.MatchString(name) || strings.Contains(name, ".") {
+		return nil, newParamError("relation", name, "Invalid relation: "+name)
+	}
+	d := this.GetDialect()
+	relation := &Relation{
+		Name:       name,
+		Table:      normalizeTableId(name, this.DbType, this.Ds),
+		Columns:    []string{},
+		RefColumns: []string{},
+	}
+
+	// Many to one, this table references the related table.
+	query, args := d.ForeignKeysQuery(tableId)
+	_, foreignKeys, err := queryToTypedArray(q, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	constraint := ""
+	for _, row := range foreignKeys {
+		if strings.EqualFold(metaString(row[2]), name) && (constraint == "" || constraint == metaString(row[0])) {
+			constraint = metaString(row[0])
+			relation.Columns = append(relation.Columns, metaString(row[1]))
+			relation.RefColumns = append(relation.RefColumns, metaString(row[3]))
+		}
+	}
+	if constraint != "" {
+		return relation, nil
+	}
+
+	// One to many, the related table references this table.
+	_, table := splitTableId(tableId)
+	query, args = d.ForeignKeysQuery(relation.Table)
+	_, foreignKeys, err = queryToTypedArray(q, "", d.Rebind(query), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	for _, row := range foreignKeys {
+		if strings.EqualFold(metaString(row[2]), table) && (constraint == "" || constraint == metaString(row[0])) {
+			constraint = metaString(row[0])
+			relation.Columns = append(relation.Columns, metaString(row[3]))
+			relation.RefColumns = append(relation.RefColumns, metaString(row[1]))
+		}
+	}
+	if constraint == "" {
+		return nil, newParamError("relation", name, "Unknown relation: "+name)
+	}
+	relation.Many = true
+	return relation, nil
+}
+
+// join returns the predicate linking a row of the related table, aliased
+// alias, to a row of tableId.
+func (this *Relation) join(tableId string, alias string, d Dialect) string {
+	ret := []string{}
+	for i, c := range this.Columns {
+		ret = append(ret, fmt.Sprint(alias, ".", d.QuoteIdentifier(this.RefColumns[i]), "=", tableId, ".", d.QuoteIdentifier(c)))
+	}
+	return strings.Join(ret, " AND ")
+}
@@ -7,58 +7,65 @@ import (
 	"strings"
 )
 
+// handleRequest runs a request through the handler of its path, wrapped by the
+// global and path handler interceptors.
+func handleRequest(w http.ResponseWriter, r *http.Request) {
+	urlPath := r.URL.Path
+	var dataHandler func(w http.ResponseWriter, r *http.Request)
+	if strings.HasPrefix(urlPath, "/api/") {
+		dataHandler = Websql.handlers.GetHandler("/api")
+	} else {
+		dataHandler = Websql.handlers.GetHandler(urlPath)
+	}
+	if dataHandler == nil {
+		http.Error(w, "Not found.", http.StatusNotFound)
+		return
+	}
+	for _, globalHandlerInterceptor := range Websql.Interceptors.GlobalHandlerInterceptorRegistry {
+		err := globalHandlerInterceptor.BeforeHandle(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	handlerInterceptor := Websql.Interceptors.HandlerInterceptorRegistry[urlPath]
+	if handlerInterceptor != nil {
+		err := handlerInterceptor.BeforeHandle(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	dataHandler(w, r)
+	if handlerInterceptor != nil {
+		err := handlerInterceptor.AfterHandle(w, r)
+		if err != nil {
+			fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+	for _, globalHandlerInterceptor := range Websql.Interceptors.GlobalHandlerInterceptorRegistry {
+		err := globalHandlerInterceptor.AfterHandle(w, r)
+		if err != nil {
+			fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+}
+
 func serve(service *CliService) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
 		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if r.Method == "OPTIONS" {
 			return
 		}
 
-		urlPath := r.URL.Path
-		var dataHandler func(w http.ResponseWriter, r *http.Request)
-		if strings.HasPrefix(urlPath, "/api/") {
-			dataHandler = Websql.handlers.GetHandler("/api")
-		} else {
-			dataHandler = Websql.handlers.GetHandler(urlPath)
-		}
-		if dataHandler == nil {
-			http.Error(w, "Not found.", http.StatusNotFound)
-			return
-		}
-		for _, globalHandlerInterceptor := range Websql.Interceptors.GlobalHandlerInterceptorRegistry {
-			err := globalHandlerInterceptor.BeforeHandle(w, r)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-		handlerInterceptor := Websql.Interceptors.HandlerInterceptorRegistry[urlPath]
-		if handlerInterceptor != nil {
-			err := handlerInterceptor.BeforeHandle(w, r)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-		dataHandler(w, r)
-		if handlerInterceptor != nil {
-			err := handlerInterceptor.AfterHandle(w, r)
-			if err != nil {
-				fmt.Fprint(w, err.Error())
-				return
-			}
-		}
-		for _, globalHandlerInterceptor := range Websql.Interceptors.GlobalHandlerInterceptorRegistry {
-			err := globalHandlerInterceptor.AfterHandle(w, r)
-			if err != nil {
-				fmt.Fprint(w, err.Error())
-				return
-			}
-		}
+		handleRequest(w, r)
 	}
 
 	http.HandleFunc("/", handler)
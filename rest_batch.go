@@ -0,0 +1,301 @@
+// rest_batch
+package websql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elgs/gojq"
+)
+
+// batchRequest is one operation of a batch, run as if it were sent to
+// /api/<Table>/<Id>?<Query>.
+type batchRequest struct {
+	Method string
+	Table  string
+	// Id is given as it appears in the url, a composite key is its escaped
+	// values joined by commas.
+	Id    interface{}
+	Body  interface{}
+	Query map[string]interface{}
+}
+
+var batchMaxRequests = 100
+
+// ${n} is replaced by the data of the n-th result, ${n.path} by the value at
+// the gojq path of the n-th result body, e.g. ${0.data.[1]}.
+var batchRefRegexp = regexp.MustCompile(`\$\{(\d+)(?:\.([^}]+))?\}`)
+
+// resolveBatchRef looks up one reference to an earlier result.
+func resolveBatchRef(ref string, results []map[string]interface{}) (interface{}, error) {
+	m := batchRefRegexp.FindStringSubmatch(ref)
+	index, err := strconv.Atoi(m[1])
+	if err != nil || index >= len(results) {
+		return nil, newParamError("ref", ref, "Invalid reference, no earlier request: "+ref)
+	}
+	if status, _ := results[index]["status"].(int); status >= 400 {
+		return nil, newParamError("ref", ref, fmt.Sprint("Invalid reference, request ", index, " failed: ", ref))
+	}
+	path := m[2]
+	if path == "" {
+		path = "data"
+	}
+	v, err := gojq.NewQuery(results[index]["body"]).Query(path)
+	if err != nil {
+		return nil, newParamError("ref", ref, "Invalid reference: "+ref)
+	}
+	return v, nil
+}
+
+// resolveBatchRefs replaces the references in the strings of v. A string that
+// is a single reference takes the referenced value as is, so numbers and
+// objects keep their type.
+func resolveBatchRefs(v interface{}, results []map[string]interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		if loc := batchRefRegexp.FindStringIndex(x); loc != nil && loc[0] == 0 && loc[1] == len(x) {
+			return resolveBatchRef(x, results)
+		}
+		var err error
+		ret := batchRefRegexp.ReplaceAllStringFunc(x, func(ref string) string {
+			value, e := resolveBatchRef(ref, results)
+			if e != nil && err == nil {
+				err = e
+			}
+			return keyValueString(value)
+		})
+		return ret, err
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for i, item := range x {
+			resolved, err := resolveBatchRefs(item, results)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = resolved
+		}
+		return ret, nil
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for k, item := range x {
+			resolved, err := resolveBatchRefs(item, results)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = resolved
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+// newBatchSubRequest builds the http request of one batch operation. It
+// carries the tokens and the context of the batch request.
+func newBatchSubRequest(r *http.Request, br *batchRequest, txId string, results []map[string]interface{}) (*http.Request, error) {
+	method := strings.ToUpper(br.Method)
+	switch method {
+	case "GET", "POST", "PUT", "PATCH", "DELETE", "COPY":
+	default:
+		return nil, newParamError("method", br.Method, "Invalid method: "+br.Method)
+	}
+	if br.Table == "" || (strings.HasPrefix(br.Table, "_") && br.Table != "_meta") {
+		return nil, newParamError("table", br.Table, "Invalid table: "+br.Table)
+	}
+
+	path := "/api/" + url.PathEscape(br.Table)
+	if br.Id != nil {
+		id, err := resolveBatchRefs(br.Id, results)
+		if err != nil {
+			return nil, err
+		}
+		path += "/" + batchIdPath(keyValueString(id))
+	}
+	query := url.Values{}
+	for k, v := range br.Query {
+		resolved, err := resolveBatchRefs(v, results)
+		if err != nil {
+			return nil, err
+		}
+		if values, ok := resolved.([]interface{}); ok {
+			for _, value := range values {
+				query.Add(k, keyValueString(value))
+			}
+		} else {
+			query.Add(k, keyValueString(resolved))
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var body io.Reader
+	if br.Body != nil {
+		resolved, err := resolveBatchRefs(br.Body, results)
+		if err != nil {
+			return nil, err
+		}
+		jsonData, err := json.Marshal(resolved)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, newParamError("id", keyValueString(br.Id), err.Error())
+	}
+	req = req.WithContext(r.Context())
+	req.RemoteAddr = r.RemoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range []string{"api-token", "user-token"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if txId != "" {
+		req.Header.Set("tx-id", txId)
+	}
+	return req, nil
+}
+
+// batchIdPath escapes an id for the path of a sub-request. The values of a
+// composite key come escaped already, so each is unescaped first.
+func batchIdPath(id string) string {
+	parts := strings.Split(id, ",")
+	for i, part := range parts {
+		if v, err := url.PathUnescape(part); err == nil {
+			part = v
+		}
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// batchResponse keeps the response of a batch operation.
+type batchResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (this *batchResponse) Header() http.Header {
+	return this.header
+}
+
+func (this *batchResponse) WriteHeader(status int) {
+	if this.status == 0 {
+		this.status = status
+	}
+}
+
+func (this *batchResponse) Write(b []byte) (int, error) {
+	this.WriteHeader(http.StatusOK)
+	return this.body.Write(b)
+}
+
+// runBatchRequest runs one batch operation like any other request, through the
+// handler interceptors, and returns its status and response body. A body with
+// an err fails the operation whatever its status.
+func runBatchRequest(r *http.Request, br *batchRequest, txId string, results []map[string]interface{}) map[string]interface{} {
+	res := &batchResponse{header: http.Header{}}
+	req, err := newBatchSubRequest(r, br, txId, results)
+	if err != nil {
+		writeError(res, err)
+	} else {
+		handleRequest(res, req)
+	}
+	res.WriteHeader(http.StatusOK)
+	var body interface{}
+	if json.Unmarshal(res.body.Bytes(), &body) != nil {
+		body = strings.TrimSpace(res.body.String())
+	}
+	// Some errors are still answered with a 200 and an err in the body.
+	if m, ok := body.(map[string]interface{}); ok && res.status < 400 && m["err"] != nil && m["err"] != "" {
+		res.status = http.StatusInternalServerError
+	}
+	return map[string]interface{}{
+		"status": res.status,
+		"body":   body,
+	}
+}
+
+// restBatchFunc serves POST /api/_batch. The body is an array of operations,
+// each with a method, table, id, body and query. They run in order and their
+// results come back in order. With atomic=1 they run in one transaction, which
+// is rolled back when an operation fails.
+var restBatchFunc = func(w http.ResponseWriter, r *http.Request, dbo DataOperator, context map[string]interface{}) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	requests := []*batchRequest{}
+	err := json.NewDecoder(r.Body).Decode(&requests)
+	if err != nil {
+		writeError(w, newParamError("body", "", "Invalid batch: "+err.Error()))
+		return
+	}
+	if len(requests) > batchMaxRequests {
+		writeError(w, newParamError("body", strconv.Itoa(len(requests)), fmt.Sprint("Too many requests in batch, the limit is ", batchMaxRequests, ".")))
+		return
+	}
+
+	atomic := translateBoolParam(r.FormValue("atomic"), false)
+	// A batch sent in a transaction of its own runs in that transaction.
+	txId := r.Header.Get("tx-id")
+	var rt *restTx
+	if atomic && txId == "" {
+		rt, err = openRestTx(dbo, context["app_id"].(string), context["api_token"].(string), restTxDefaultTimeout)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		txId = rt.Id
+	}
+
+	results := []map[string]interface{}{}
+	for i, br := range requests {
+		result := runBatchRequest(r, br, txId, results)
+		results = append(results, result)
+		status := result["status"].(int)
+		if atomic && status >= 400 {
+			if rt != nil {
+				rt.abort()
+			}
+			jsonData, _ := json.Marshal(map[string]interface{}{
+				"err":   fmt.Sprint("Request ", i, " failed, the batch is rolled back."),
+				"index": i,
+				"data":  results,
+			})
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(status)
+			fmt.Fprint(w, string(jsonData))
+			return
+		}
+	}
+	if rt != nil {
+		rt.mutex.Lock()
+		err = rt.commit()
+		rt.mutex.Unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"data": results,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsonData))
+}
@@ -0,0 +1,114 @@
+// rest_batch
+package websql
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testRestAppId = "0123456789abcdef0123456789abcdef"
+
+// Requests through RestFunc also stamp the client address.
+const testRestAuditColumns = testAuditColumns + ", CREATED_FROM TEXT, UPDATED_FROM TEXT"
+
+// newTestRest serves the REST API of app from dbo. It returns the API token,
+// which starts with the app id as RestFunc expects.
+func newTestRest(t *testing.T, app *App, dbo DataOperator) string {
+	t.Helper()
+	apiToken := app.Id + "_token"
+	app.Tokens = append(app.Tokens, &Token{Id: apiToken, AppId: app.Id, Target: "*", Mode: "*"})
+	getDbo := Websql.getDbo
+	handler := Websql.handlers.GetHandler("/api")
+	Websql.getDbo = func(id string) (DataOperator, error) {
+		return dbo, nil
+	}
+	Websql.handlers.RegisterHandler("/api", RestFunc)
+	t.Cleanup(func() {
+		Websql.getDbo = getDbo
+		if handler == nil {
+			delete(Websql.handlers.handlerRegistry, "/api")
+		} else {
+			Websql.handlers.RegisterHandler("/api", handler)
+		}
+	})
+	return apiToken
+}
+
+// serveTestRest sends a request with the tokens of context and returns the
+// response status and JSON body.
+func serveTestRest(t *testing.T, method string, path string, body string, apiToken string,
+	context map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("api-token", apiToken)
+	r.Header.Set("user-token", context["user_token"].(string))
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatal("Expected a JSON response:", w.Code, w.Body.String())
+	}
+	return w.Code, m
+}
+
+func TestSqliteBatch(t *testing.T) {
+	app := newTestApp(t, &App{Id: testRestAppId})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testRestAuditColumns+")",
+		"CREATE TABLE LINE (ID INTEGER PRIMARY KEY, ORDER_ID INTEGER NOT NULL, "+testRestAuditColumns+")")
+	apiToken := newTestRest(t, app, dbo)
+
+	status, body := serveTestRest(t, "POST", "/api/_batch?atomic=1", `[
+		{"method": "POST", "table": "ORDERS", "body": {"NAME": "a"}},
+		{"method": "POST", "table": "LINE", "body": {"ORDER_ID": 1}}
+	]`, apiToken, testContext(t, app, nil))
+	if status != 200 {
+		t.Fatal("Expected the batch done:", status, body)
+	}
+	if ids := listIds(t, dbo, "LINE", []string{"ORDER_ID==1"}, "", testContext(t, app, nil)); len(ids) != 1 {
+		t.Fatal("Expected the line of the order:", ids)
+	}
+
+	// An atomic batch is rolled back when one request fails.
+	status, body = serveTestRest(t, "POST", "/api/_batch?atomic=1", `[
+		{"method": "POST", "table": "ORDERS", "body": {"NAME": "b"}},
+		{"method": "POST", "table": "LINE", "body": {"ORDER_ID": null}}
+	]`, apiToken, testContext(t, app, nil))
+	if status < 400 || body["index"] != float64(1) {
+		t.Fatal("Expected the second request failed:", status, body)
+	}
+	if ids := listIds(t, dbo, "ORDERS", nil, "", testContext(t, app, nil)); len(ids) != 1 {
+		t.Fatal("Expected the batch rolled back:", ids)
+	}
+}
+
+// A request answered with a 200 and an err fails the batch too.
+func TestSqliteBatchErrBody(t *testing.T) {
+	app := newTestApp(t, &App{Id: testRestAppId})
+	dbo := newTestDbo(t, "CREATE TABLE ORDERS (ID INTEGER PRIMARY KEY, NAME TEXT NOT NULL, "+testRestAuditColumns+")")
+	apiToken := newTestRest(t, app, dbo)
+	calls := 0
+	Websql.getDbo = func(id string) (DataOperator, error) {
+		calls++
+		if calls == 3 {
+			return nil, errors.New("Data node is down.")
+		}
+		return dbo, nil
+	}
+
+	status, body := serveTestRest(t, "POST", "/api/_batch?atomic=1", `[
+		{"method": "POST", "table": "ORDERS", "body": {"NAME": "a"}},
+		{"method": "POST", "table": "ORDERS", "body": {"NAME": "b"}}
+	]`, apiToken, testContext(t, app, nil))
+	if status != http.StatusInternalServerError || body["index"] != float64(1) {
+		t.Fatal("Expected the second request failed:", status, body)
+	}
+	if ids := listIds(t, dbo, "ORDERS", nil, "", testContext(t, app, nil)); len(ids) != 0 {
+		t.Fatal("Expected the batch rolled back:", ids)
+	}
+}
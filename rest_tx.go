@@ -0,0 +1,305 @@
+// rest_tx
+package websql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// restTx is a transaction that spans several REST requests. It is pinned to
+// one connection and rolled back by database/sql when its context ends, which
+// happens on commit, rollback, timeout or when a client using it goes away.
+type restTx struct {
+	Id       string
+	AppId    string
+	ApiToken string
+	Tx       *sql.Tx
+	Expires  time.Time
+	cancel   context.CancelFunc
+	// Requests in the same transaction run one at a time.
+	mutex sync.Mutex
+}
+
+var restTxDefaultTimeout = 30 * time.Second
+var restTxMaxTimeout = 5 * time.Minute
+
+// restTxMaxPerApp caps the open transactions of an app on this node, each
+// holds a connection of the app's pool until it ends.
+var restTxMaxPerApp = 10
+
+// restTxs holds the open transactions of this node. They are pinned to a
+// connection of this process, so behind a load balancer every request with
+// a tx-id header must be routed to the node that opened the transaction,
+// e.g. by hashing the header. Any other node answers tx_not_found.
+var restTxs = map[string]*restTx{}
+var restTxsMutex = &sync.Mutex{}
+
+func checkApiToken(appId string, apiToken string) error {
+	for _, app := range Websql.masterData.Apps {
+		if app.Id != appId {
+			continue
+		}
+		for _, t := range app.Tokens {
+			if t.AppId == app.Id && t.Id == apiToken {
+				return nil
+			}
+		}
+	}
+	return errors.New("Authentication failed.")
+}
+
+// openRestTx begins a transaction on the data operator's connection pool. The
+// timeout is capped at restTxMaxTimeout.
+func openRestTx(dbo DataOperator, appId string, apiToken string, timeout time.Duration) (*restTx, error) {
+	err := checkApiToken(appId, apiToken)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > restTxMaxTimeout {
+		timeout = restTxMaxTimeout
+	}
+	db, err := dbo.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rt := &restTx{
+		Id:       strings.Replace(uuid.NewV4().String(), "-", "", -1),
+		AppId:    appId,
+		ApiToken: apiToken,
+		Tx:       tx,
+		Expires:  time.Now().Add(timeout),
+		cancel:   cancel,
+	}
+	restTxsMutex.Lock()
+	open := 0
+	for _, t := range restTxs {
+		if t.AppId == appId {
+			open++
+		}
+	}
+	if open >= restTxMaxPerApp {
+		restTxsMutex.Unlock()
+		cancel()
+		return nil, &RequestError{
+			Status:  http.StatusTooManyRequests,
+			Code:    "too_many_tx",
+			Value:   appId,
+			Message: fmt.Sprint("Too many open transactions, the limit is ", restTxMaxPerApp, "."),
+		}
+	}
+	restTxs[rt.Id] = rt
+	restTxsMutex.Unlock()
+	go func() {
+		<-ctx.Done()
+		rt.forget()
+	}()
+	return rt, nil
+}
+
+// acquireRestTx finds an open transaction of the app and locks it for the
+// calling request.
+func acquireRestTx(txId string, appId string, apiToken string) (*restTx, error) {
+	restTxsMutex.Lock()
+	rt, ok := restTxs[txId]
+	restTxsMutex.Unlock()
+	if !ok || rt.AppId != appId || rt.ApiToken != apiToken {
+		return nil, &RequestError{
+			Status:  http.StatusNotFound,
+			Code:    "tx_not_found",
+			Value:   txId,
+			Message: "Transaction not found: " + txId,
+		}
+	}
+	rt.mutex.Lock()
+	return rt, nil
+}
+
+func (this *restTx) release() {
+	this.mutex.Unlock()
+}
+
+// abort rolls the transaction back, it cannot be used afterwards.
+func (this *restTx) abort() {
+	this.cancel()
+	this.forget()
+}
+
+// forget removes the transaction from the open transactions.
+func (this *restTx) forget() {
+	restTxsMutex.Lock()
+	delete(restTxs, this.Id)
+	restTxsMutex.Unlock()
+}
+
+func (this *restTx) commit() error {
+	defer this.abort()
+	err := this.Tx.Commit()
+	// A transaction aborted a moment ago may still be rolling back.
+	if err == sql.ErrTxDone || err == context.Canceled || err == context.DeadlineExceeded {
+		return &RequestError{
+			Status:  http.StatusConflict,
+			Code:    "tx_aborted",
+			Value:   this.Id,
+			Message: "Transaction was aborted: " + this.Id,
+		}
+	}
+	return err
+}
+
+// watchClient aborts the transaction if the client goes away before the
+// request is done. The returned function marks the request as done.
+func (this *restTx) watchClient(r *http.Request) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			select {
+			case <-done:
+			default:
+				this.abort()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// pinnedTx returns the multi-request transaction a request runs in, or nil.
+func pinnedTx(context map[string]interface{}) *sql.Tx {
+	if _, ok := context["tx_id"]; ok {
+		return ContextTx(context)
+	}
+	return nil
+}
+
+// commitContextTx commits the transaction in the context, unless it is a
+// multi-request transaction, which only its commit endpoint commits.
+func commitContextTx(context map[string]interface{}) error {
+	tx := ContextTx(context)
+	if tx == nil || pinnedTx(context) != nil {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// restTxFunc serves /api/_tx:
+// POST /api/_tx?timeout=<seconds> opens a transaction,
+// POST /api/_tx/<tx_id>/commit commits it,
+// POST /api/_tx/<tx_id>/rollback rolls it back.
+// Other requests join the transaction with the tx-id header.
+var restTxFunc = func(w http.ResponseWriter, r *http.Request, dbo DataOperator, context map[string]interface{}) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	appId := context["app_id"].(string)
+	apiToken := context["api_token"].(string)
+	urlPathData := strings.Split(r.URL.Path[1:], "/")
+	if len(urlPathData) == 2 || len(urlPathData[2]) == 0 {
+		timeout := restTxDefaultTimeout
+		if t := r.FormValue("timeout"); t != "" {
+			seconds, err := strconv.Atoi(t)
+			if err != nil || seconds <= 0 {
+				writeError(w, newParamError("timeout", t, "Invalid timeout: "+t))
+				return
+			}
+			timeout = restTxMaxTimeout
+			if time.Duration(seconds) < restTxMaxTimeout/time.Second {
+				timeout = time.Duration(seconds) * time.Second
+			}
+		}
+		rt, err := openRestTx(dbo, appId, apiToken, timeout)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		jsonData, _ := json.Marshal(map[string]interface{}{
+			"data": map[string]interface{}{
+				"tx_id":   rt.Id,
+				"expires": rt.Expires.UTC(),
+			},
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsonData))
+		return
+	}
+
+	if len(urlPathData) < 4 {
+		writeError(w, newParamError("action", "", "Missing action, commit or rollback."))
+		return
+	}
+	rt, err := acquireRestTx(urlPathData[2], appId, apiToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rt.release()
+	switch urlPathData[3] {
+	case "commit":
+		err = rt.commit()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	case "rollback":
+		rt.abort()
+	default:
+		writeError(w, newParamError("action", urlPathData[3], "Invalid action: "+urlPathData[3]))
+		return
+	}
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"data": rt.Id,
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsonData))
+}
+
+// rollbackContextTx rolls back the transaction in the context after a failed
+// request, unless it is a multi-request transaction. That one stays open for
+// the client to roll back or to go on with.
+func rollbackContextTx(context map[string]interface{}) {
+	if tx := ContextTx(context); tx != nil && pinnedTx(context) == nil {
+		tx.Rollback()
+	}
+}
+
+// beginRequestTx returns the multi-request transaction a request runs in, or
+// begins a new transaction.
+func beginRequestTx(db *sql.DB, context map[string]interface{}) (*sql.Tx, error) {
+	if tx := pinnedTx(context); tx != nil {
+		return tx, nil
+	}
+	return db.Begin()
+}
+
+// rollbackRequestTx rolls back a transaction begun by beginRequestTx.
+func rollbackRequestTx(tx *sql.Tx, context map[string]interface{}) {
+	if pinnedTx(context) == nil {
+		tx.Rollback()
+	}
+}
+
+// commitRequestTx commits a transaction begun by beginRequestTx.
+func commitRequestTx(tx *sql.Tx, context map[string]interface{}) {
+	if pinnedTx(context) == nil {
+		tx.Commit()
+	}
+}
@@ -0,0 +1,104 @@
+// rest_tx
+package websql
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func txContext(t *testing.T, app *App, rt *restTx) map[string]interface{} {
+	context := testContext(t, app, nil)
+	context["tx"] = rt.Tx
+	context["tx_id"] = rt.Id
+	return context
+}
+
+// Writes in a multi-request transaction are only seen once it commits, and
+// never if it rolls back.
+func TestSqliteRestTx(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, "+testAuditColumns+")")
+
+	rt, err := openRestTx(dbo, app.Id, testApiToken, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": name}}, txContext(t, app, rt)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", txContext(t, app, rt)); len(ids) != 2 {
+		t.Fatal("Expected the transaction to see its writes:", ids)
+	}
+	if err := rt.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 2 {
+		t.Fatal("Expected the writes committed:", ids)
+	}
+
+	rt, err = openRestTx(dbo, app.Id, testApiToken, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbo.Delete("ITEM", []string{"1"}, txContext(t, app, rt)); err != nil {
+		t.Fatal(err)
+	}
+	rt.abort()
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 2 {
+		t.Fatal("Expected the delete rolled back:", ids)
+	}
+	requireStatus(t, rt.commit(), 409)
+
+	_, err = acquireRestTx(rt.Id, app.Id, "other_token")
+	requireStatus(t, err, 404)
+}
+
+func TestSqliteRestTxLimits(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t)
+	maxPerApp := restTxMaxPerApp
+	restTxMaxPerApp = 1
+	t.Cleanup(func() {
+		restTxMaxPerApp = maxPerApp
+	})
+
+	w := httptest.NewRecorder()
+	context := map[string]interface{}{"app_id": app.Id, "api_token": testApiToken}
+	restTxFunc(w, httptest.NewRequest("POST", "/api/_tx?timeout=100000000000", nil), dbo, context)
+	if w.Code != 200 {
+		t.Fatal("Expected the transaction opened:", w.Code, w.Body.String())
+	}
+	var body struct {
+		Data struct {
+			TxId    string `json:"tx_id"`
+			Expires time.Time
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.Expires.After(time.Now().Add(restTxMaxTimeout)) {
+		t.Fatal("Expected the timeout capped:", body.Data.Expires)
+	}
+
+	w = httptest.NewRecorder()
+	restTxFunc(w, httptest.NewRequest("POST", "/api/_tx", nil), dbo, context)
+	if w.Code != 429 {
+		t.Fatal("Expected too many transactions:", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	restTxFunc(w, httptest.NewRequest("POST", "/api/_tx/"+body.Data.TxId+"/rollback", nil), dbo, context)
+	if w.Code != 200 {
+		t.Fatal("Expected the transaction rolled back:", w.Code, w.Body.String())
+	}
+	rt, err := openRestTx(dbo, app.Id, testApiToken, time.Minute)
+	if err != nil {
+		t.Fatal("Expected a transaction opened after the other ended:", err)
+	}
+	rt.abort()
+}
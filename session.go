@@ -0,0 +1,566 @@
+// session
+package websql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// A login opens a session. It returns a short lived access token and a
+// refresh token, <session id>.<secret>, of which the master only keeps the
+// hash. POST /api/_refresh trades a refresh token for a new access token and
+// a new refresh token. A refresh token that was already traded in ends the
+// session, as it was probably stolen. POST /api/_logout ends the session of
+// the refresh_token in the body, or of the access token in the user-token
+// header, and revokes its access token.
+//
+// Sessions are kept by the master, other nodes ask it. They are saved to the
+// sessions file next to the master data every sessionSaveInterval, and are
+// not replicated. A login still succeeds without a refresh token when the
+// master cannot be reached. Revoked access tokens are listed by jti in the
+// master data until they expire, and only that list is sent to the other
+// nodes when it changes, so that every node rejects them.
+var jwtAccessTtl = time.Minute * 15
+var jwtRefreshTtl = time.Hour * 24 * 30
+var sessionSaveInterval = "@every 1m"
+
+// The hashes of this many earlier refresh tokens of a session are kept, to
+// tell a reused one from a wrong one.
+var sessionReuseHashes = 20
+
+type Session struct {
+	Id        string
+	AppId     string
+	TokenHash string
+	// Claims go into every access token of the session.
+	Claims    map[string]interface{}
+	Created   time.Time
+	Expires   time.Time
+	AccessJti string
+	AccessExp time.Time
+	// PreviousHashes are the hashes of the refresh tokens already traded in.
+	PreviousHashes []string
+}
+
+// sessionRefresh is the data of CLI_SESSION_REFRESH.
+type sessionRefresh struct {
+	Session      *Session
+	NewTokenHash string
+}
+
+type RevokedToken struct {
+	Jti     string
+	Expires time.Time
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func splitRefreshToken(refreshToken string) (string, string, error) {
+	parts := strings.Split(refreshToken, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("Invalid refresh token.")
+	}
+	return parts[0], parts[1], nil
+}
+
+func newTokenId() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// signAccessToken signs an access token of a session with the claims of its
+// user. The token is only valid in the app of the session, its aud.
+func signAccessToken(appId string, claims map[string]interface{}, sessionId string, jti string, exp time.Time) (string, error) {
+	t := make(map[string]interface{}, len(claims)+5)
+	for k, v := range claims {
+		t[k] = v
+	}
+	t["aud"] = appId
+	t["jti"] = jti
+	t["sid"] = sessionId
+	t["iat"] = time.Now().Unix()
+	t["exp"] = exp.Unix()
+	tokenPayload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return createJwtToken(string(tokenPayload))
+}
+
+// openSession opens a session for the claims of a user who just logged in,
+// and returns its access and refresh tokens.
+func openSession(appId string, claims map[string]interface{}) (string, string, error) {
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now().UTC()
+	session := &Session{
+		Id:        newTokenId(),
+		AppId:     appId,
+		TokenHash: hashRefreshSecret(secret),
+		Claims:    claims,
+		Created:   now,
+		Expires:   now.Add(jwtRefreshTtl),
+		AccessJti: newTokenId(),
+		AccessExp: now.Add(jwtAccessTtl),
+	}
+	accessToken, err := signAccessToken(appId, claims, session.Id, session.AccessJti, session.AccessExp)
+	if err != nil {
+		return "", "", err
+	}
+	sessionJSONBytes, err := json.Marshal(session)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = masterCommand(&Command{
+		Type: "CLI_SESSION_ADD",
+		Data: string(sessionJSONBytes),
+	})
+	if err != nil {
+		// The access token works without the session, it cannot be refreshed.
+		log.Println(err)
+		return accessToken, "", nil
+	}
+	return accessToken, session.Id + "." + secret, nil
+}
+
+// refreshSession trades a refresh token in for new access and refresh tokens.
+func refreshSession(appId string, refreshToken string) (string, string, error) {
+	sessionId, secret, err := splitRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	newSecret, err := newRefreshSecret()
+	if err != nil {
+		return "", "", err
+	}
+	refresh := &Session{
+		Id:        sessionId,
+		AppId:     appId,
+		TokenHash: hashRefreshSecret(secret),
+		AccessJti: newTokenId(),
+		AccessExp: time.Now().UTC().Add(jwtAccessTtl),
+	}
+	refreshJSONBytes, err := json.Marshal(&sessionRefresh{
+		Session:      refresh,
+		NewTokenHash: hashRefreshSecret(newSecret),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	output, err := masterCommand(&Command{
+		Type: "CLI_SESSION_REFRESH",
+		Data: string(refreshJSONBytes),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	claims := map[string]interface{}{}
+	err = json.Unmarshal([]byte(output), &claims)
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err := signAccessToken(appId, claims, sessionId, refresh.AccessJti, refresh.AccessExp)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, sessionId + "." + newSecret, nil
+}
+
+// masterCommand runs a command on the master, here if this node is the master.
+// Commands that succeed return "" or JSON, anything else is an error.
+func masterCommand(command *Command) (string, error) {
+	var output string
+	if Websql.service.Master == "" {
+		command.Secret = Websql.service.Secret
+		message, err := json.Marshal(command)
+		if err != nil {
+			return "", err
+		}
+		output, err = Websql.processCliCommand(message)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		response, err := sendCliCommand(Websql.service.Master, command, true)
+		if err != nil {
+			return "", err
+		}
+		output = string(response)
+	}
+	if output != "" && !strings.HasPrefix(output, "{") {
+		return "", errors.New(strings.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// tokenRevoked tells if an access token was revoked before it expired. The
+// revoked tokens are part of the master data, and kept under its lock.
+func tokenRevoked(jti string) bool {
+	masterDataMutex.Lock()
+	defer masterDataMutex.Unlock()
+	for _, revoked := range Websql.masterData.RevokedTokens {
+		if revoked.Jti == jti {
+			return true
+		}
+	}
+	return false
+}
+
+// revokeTokens adds access tokens to the revoked ones, drops those that
+// expired, and sends the list to the other nodes.
+func revokeTokens(revoke ...*RevokedToken) {
+	now := time.Now()
+	masterDataMutex.Lock()
+	revokedTokens := []*RevokedToken{}
+	for _, revoked := range Websql.masterData.RevokedTokens {
+		if revoked.Expires.After(now) {
+			revokedTokens = append(revokedTokens, revoked)
+		}
+	}
+	for _, r := range revoke {
+		if r.Jti != "" && r.Expires.After(now) {
+			revokedTokens = append(revokedTokens, r)
+		}
+	}
+	Websql.masterData.RevokedTokens = revokedTokens
+	revokedBytes, err := json.Marshal(revokedTokens)
+	masterDataMutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	broadcastWsCommand(&Command{
+		Type: "WS_REVOKED_TOKENS",
+		Data: string(revokedBytes),
+	})
+}
+
+// setRevokedTokens replaces the revoked tokens with the master's.
+func setRevokedTokens(data string) error {
+	revokedTokens := []*RevokedToken{}
+	err := json.Unmarshal([]byte(data), &revokedTokens)
+	if err != nil {
+		return err
+	}
+	masterDataMutex.Lock()
+	Websql.masterData.RevokedTokens = revokedTokens
+	masterDataMutex.Unlock()
+	return nil
+}
+
+// sessionStore keeps the sessions on the master.
+type sessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	dirty    bool
+}
+
+var sessions = &sessionStore{sessions: map[string]*Session{}}
+
+// sessionsFile is where the master saves the sessions, next to the master
+// data.
+func sessionsFile() string {
+	return strings.TrimSuffix(Websql.service.DataFile, ".json") + "_sessions.json"
+}
+
+// prune drops the sessions that expired. The caller holds the lock.
+func (this *sessionStore) prune(now time.Time) {
+	for id, session := range this.sessions {
+		if !session.Expires.After(now) {
+			delete(this.sessions, id)
+			this.dirty = true
+		}
+	}
+}
+
+func (this *sessionStore) add(session *Session) error {
+	found := false
+	for _, vApp := range Websql.masterData.Apps {
+		found = found || vApp.Id == session.AppId
+	}
+	if !found {
+		return errors.New("App does not exist: " + session.AppId)
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.prune(time.Now())
+	session.PreviousHashes = nil
+	this.sessions[session.Id] = session
+	this.dirty = true
+	return nil
+}
+
+// refresh replaces the refresh token hash of a session and registers its new
+// access token. It returns the claims of the session. The hash of a refresh
+// token already traded in ends the session, any other hash is rejected.
+func (this *sessionStore) refresh(refresh *Session, newTokenHash string) (map[string]interface{}, error) {
+	this.mutex.Lock()
+	claims, revoked, err := this.trade(refresh, newTokenHash)
+	this.mutex.Unlock()
+	// The other nodes are told without holding up the sessions.
+	if revoked != nil {
+		revokeTokens(revoked)
+	}
+	return claims, err
+}
+
+// trade does the work of refresh, and returns the access token to revoke if
+// the session was closed. The caller holds the lock.
+func (this *sessionStore) trade(refresh *Session, newTokenHash string) (map[string]interface{}, *RevokedToken, error) {
+	this.prune(time.Now())
+	session, ok := this.sessions[refresh.Id]
+	if !ok || session.AppId != refresh.AppId {
+		return nil, nil, errors.New("Session not found.")
+	}
+	if subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(refresh.TokenHash)) != 1 {
+		for _, hash := range session.PreviousHashes {
+			if subtle.ConstantTimeCompare([]byte(hash), []byte(refresh.TokenHash)) == 1 {
+				delete(this.sessions, session.Id)
+				this.dirty = true
+				return nil, &RevokedToken{Jti: session.AccessJti, Expires: session.AccessExp},
+					errors.New("Refresh token was used before, the session is closed.")
+			}
+		}
+		return nil, nil, errors.New("Invalid refresh token.")
+	}
+	session.PreviousHashes = append(session.PreviousHashes, session.TokenHash)
+	if len(session.PreviousHashes) > sessionReuseHashes {
+		session.PreviousHashes = session.PreviousHashes[len(session.PreviousHashes)-sessionReuseHashes:]
+	}
+	session.TokenHash = newTokenHash
+	session.AccessJti = refresh.AccessJti
+	session.AccessExp = refresh.AccessExp
+	this.dirty = true
+	claims := session.Claims
+	if claims == nil {
+		claims = map[string]interface{}{}
+	}
+	return claims, nil, nil
+}
+
+// revoke closes a session and revokes its access token, and the access token
+// of the request if it is another one. A session closed by its refresh token,
+// revoke.TokenHash, must have that hash.
+func (this *sessionStore) revoke(revoke *Session) error {
+	this.mutex.Lock()
+	revoked, err := this.close(revoke)
+	this.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	revokeTokens(revoked...)
+	return nil
+}
+
+// close does the work of revoke, and returns the access tokens to revoke. The
+// caller holds the lock.
+func (this *sessionStore) close(revoke *Session) ([]*RevokedToken, error) {
+	this.prune(time.Now())
+	revoked := []*RevokedToken{{Jti: revoke.AccessJti, Expires: revoke.AccessExp}}
+	session, ok := this.sessions[revoke.Id]
+	if ok && session.AppId == revoke.AppId {
+		if revoke.TokenHash != "" && subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(revoke.TokenHash)) != 1 {
+			return nil, errors.New("Invalid refresh token.")
+		}
+		revoked = append(revoked, &RevokedToken{Jti: session.AccessJti, Expires: session.AccessExp})
+		delete(this.sessions, session.Id)
+		this.dirty = true
+	} else if revoke.TokenHash != "" {
+		return nil, errors.New("Session not found.")
+	}
+	return revoked, nil
+}
+
+// load reads the sessions the master saved.
+func (this *sessionStore) load() error {
+	data, err := ioutil.ReadFile(sessionsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	loaded := map[string]*Session{}
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sessions = loaded
+	return nil
+}
+
+// save writes the sessions if they changed.
+func (this *sessionStore) save() error {
+	this.mutex.Lock()
+	if !this.dirty {
+		this.mutex.Unlock()
+		return nil
+	}
+	this.prune(time.Now())
+	data, err := json.Marshal(this.sessions)
+	this.dirty = false
+	this.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sessionsFile(), data, 0600)
+}
+
+// startSessionJob loads the sessions on the master and saves them on
+// schedule.
+func (this *WebSQL) startSessionJob() error {
+	err := sessions.load()
+	if err != nil {
+		return err
+	}
+	_, err = this.Sched.AddFunc(sessionSaveInterval, func() {
+		err := sessions.save()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	return err
+}
+
+// restSessionFunc serves POST /api/_refresh and POST /api/_logout.
+var restSessionFunc = func(w http.ResponseWriter, r *http.Request, action string, context map[string]interface{}) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	appId := context["app_id"].(string)
+	err := checkApiToken(appId, context["api_token"].(string))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	m := map[string]interface{}{}
+	switch action {
+	case "_refresh":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		params := map[string]string{}
+		err = json.Unmarshal(body, &params)
+		if err != nil || params["refresh_token"] == "" {
+			writeError(w, newParamError("refresh_token", "", "Missing refresh token."))
+			return
+		}
+		accessToken, refreshToken, err := refreshSession(appId, params["refresh_token"])
+		if err != nil {
+			writeError(w, &RequestError{
+				Status:  http.StatusUnauthorized,
+				Code:    "invalid_refresh_token",
+				Message: err.Error(),
+			})
+			return
+		}
+		m["data"] = accessToken
+		m["refresh_token"] = refreshToken
+		m["expires_in"] = int64(jwtAccessTtl / time.Second)
+	case "_logout":
+		// The session is that of the refresh token in the body, if any, else
+		// that of the access token.
+		params := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(strings.TrimSpace(string(body))) > 0 && json.Unmarshal(body, &params) != nil {
+			writeError(w, newParamError("body", "", "Invalid JSON body."))
+			return
+		}
+		revoke := &Session{AppId: appId}
+		if params["refresh_token"] != "" {
+			sessionId, secret, err := splitRefreshToken(params["refresh_token"])
+			if err != nil {
+				writeError(w, newParamError("refresh_token", "", err.Error()))
+				return
+			}
+			revoke.Id = sessionId
+			revoke.TokenHash = hashRefreshSecret(secret)
+		}
+		// With a refresh token, an access token that is no longer valid is
+		// left alone.
+		err = checkUserToken(context)
+		if err != nil && revoke.Id == "" {
+			writeError(w, &RequestError{
+				Status:  http.StatusUnauthorized,
+				Code:    "invalid_user_token",
+				Message: err.Error(),
+			})
+			return
+		}
+		if err == nil {
+			claims, _ := context["user_claims"].(map[string]interface{})
+			exp, _ := claims["exp"].(float64)
+			revoke.AccessJti, _ = claims["jti"].(string)
+			revoke.AccessExp = time.Unix(int64(exp), 0).UTC()
+			if revoke.Id == "" {
+				revoke.Id, _ = claims["sid"].(string)
+			}
+		}
+		revokeJSONBytes, err := json.Marshal(revoke)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		_, err = masterCommand(&Command{
+			Type: "CLI_SESSION_REVOKE",
+			Data: string(revokeJSONBytes),
+		})
+		if err != nil && revoke.TokenHash != "" {
+			writeError(w, &RequestError{
+				Status:  http.StatusUnauthorized,
+				Code:    "invalid_refresh_token",
+				Message: err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		m["data"] = "OK"
+	}
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsonData))
+}
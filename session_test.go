@@ -0,0 +1,187 @@
+// session
+package websql
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestSessions makes this node the master of app, with no sessions yet.
+func newTestSessions(t *testing.T) *App {
+	t.Helper()
+	app := newTestApp(t, &App{Id: "app"})
+	service := *Websql.service
+	store := sessions
+	Websql.service.Master = ""
+	Websql.service.Secret = "secret"
+	sessions = &sessionStore{sessions: map[string]*Session{}}
+	t.Cleanup(func() {
+		*Websql.service = service
+		sessions = store
+	})
+	return app
+}
+
+// userTokenError returns why a user token is not valid, nil if it is.
+func userTokenError(app *App, userToken string) error {
+	return checkUserToken(map[string]interface{}{"app_id": app.Id, "user_token": userToken})
+}
+
+func TestSessionRefresh(t *testing.T) {
+	app := newTestSessions(t)
+
+	accessToken, refreshToken, err := openSession(app.Id, map[string]interface{}{"email": "user@example.com"})
+	if err != nil || refreshToken == "" {
+		t.Fatal("Expected a session opened:", refreshToken, err)
+	}
+	if err := userTokenError(app, accessToken); err != nil {
+		t.Fatal(err)
+	}
+	newAccessToken, newRefreshToken, err := refreshSession(app.Id, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := map[string]interface{}{"app_id": app.Id, "user_token": newAccessToken}
+	if err := checkUserToken(context); err != nil || context["user_email"] != "user@example.com" {
+		t.Fatal("Expected the claims of the session in the new token:", context["user_claims"], err)
+	}
+	if _, _, err := refreshSession(app.Id, strings.Split(newRefreshToken, ".")[0]+".wrong"); err == nil ||
+		err.Error() != "Invalid refresh token." {
+		t.Fatal("Expected a wrong refresh token rejected:", err)
+	}
+	if _, _, err := refreshSession("other", newRefreshToken); err == nil {
+		t.Fatal("Expected the session of another app not found.")
+	}
+
+	// A refresh token traded in before was probably stolen, it closes the
+	// session and revokes its access token.
+	_, _, err = refreshSession(app.Id, refreshToken)
+	if err == nil || err.Error() != "Refresh token was used before, the session is closed." {
+		t.Fatal("Expected the reused refresh token to close the session:", err)
+	}
+	if _, _, err := refreshSession(app.Id, newRefreshToken); err == nil || err.Error() != "Session not found." {
+		t.Fatal("Expected the session closed:", err)
+	}
+	if err := userTokenError(app, newAccessToken); err == nil || err.Error() != "User token revoked." {
+		t.Fatal("Expected the access token of the session revoked:", err)
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	app := newTestSessions(t)
+	logout := func(userToken string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/_logout", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		restSessionFunc(w, r, "_logout", map[string]interface{}{"app_id": app.Id, "api_token": testApiToken, "user_token": userToken})
+		return w
+	}
+
+	accessToken, refreshToken, err := openSession(app.Id, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := logout(accessToken, ""); w.Code != 200 {
+		t.Fatal("Expected the session closed by its access token:", w.Code, w.Body.String())
+	}
+	if err := userTokenError(app, accessToken); err == nil {
+		t.Fatal("Expected the access token revoked.")
+	}
+	if _, _, err := refreshSession(app.Id, refreshToken); err == nil {
+		t.Fatal("Expected the refresh token of the closed session rejected.")
+	}
+
+	accessToken, refreshToken, err = openSession(app.Id, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := logout("", `{"refresh_token": "`+refreshToken+`x"}`); w.Code != 401 {
+		t.Fatal("Expected a wrong refresh token rejected:", w.Code, w.Body.String())
+	}
+	if w := logout("", `{"refresh_token": "`+refreshToken+`"}`); w.Code != 200 {
+		t.Fatal("Expected the session closed by its refresh token:", w.Code, w.Body.String())
+	}
+	if err := userTokenError(app, accessToken); err == nil {
+		t.Fatal("Expected the access token of the session revoked.")
+	}
+	if w := logout("", ""); w.Code != 401 {
+		t.Fatal("Expected a logout without tokens rejected:", w.Code, w.Body.String())
+	}
+}
+
+// Revocations are sent to the api nodes, which take the master's list as is.
+func TestRevokeTokens(t *testing.T) {
+	newTestSessions(t)
+	received := make(chan *Command, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wsConnsMutex.Lock()
+		Websql.wsConns["node"] = conn
+		wsConnsMutex.Unlock()
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		command := &Command{}
+		if conn.ReadJSON(command) == nil {
+			received <- command
+		}
+	}()
+	t.Cleanup(func() {
+		wsConnsMutex.Lock()
+		if conn := Websql.wsConns["node"]; conn != nil {
+			conn.Close()
+		}
+		delete(Websql.wsConns, "node")
+		wsConnsMutex.Unlock()
+	})
+	for {
+		wsConnsMutex.Lock()
+		registered := Websql.wsConns["node"] != nil
+		wsConnsMutex.Unlock()
+		if registered {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	Websql.masterData.RevokedTokens = []*RevokedToken{{Jti: "old", Expires: time.Now().Add(-time.Minute)}}
+	revokeTokens(&RevokedToken{Jti: "a", Expires: time.Now().Add(time.Minute)}, &RevokedToken{Jti: "b", Expires: time.Now()})
+	if !tokenRevoked("a") || tokenRevoked("b") || tokenRevoked("old") {
+		t.Fatal("Expected only the token that has not expired revoked:", Websql.masterData.RevokedTokens)
+	}
+	var command *Command
+	select {
+	case command = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the revoked tokens sent to the api node.")
+	}
+	if command.Type != "WS_REVOKED_TOKENS" {
+		t.Fatal("Expected the revoked tokens sent:", command)
+	}
+
+	Websql.masterData.RevokedTokens = nil
+	if err := setRevokedTokens(command.Data); err != nil {
+		t.Fatal(err)
+	}
+	if !tokenRevoked("a") || len(Websql.masterData.RevokedTokens) != 1 {
+		t.Fatal("Expected the master's list taken:", Websql.masterData.RevokedTokens)
+	}
+	var revoked []*RevokedToken
+	if err := json.Unmarshal([]byte(command.Data), &revoked); err != nil || revoked[0].Jti != "a" {
+		t.Fatal("Expected the list of the master sent:", command.Data, err)
+	}
+}
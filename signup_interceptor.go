@@ -8,6 +8,8 @@ import (
 func init() {
 	tableId := "signup"
 	Websql.Interceptors.RegisterDataInterceptor(tableId, 0, &SginupInterceptor{Id: tableId})
+	// The verification code goes out by mail only, the password never.
+	Websql.RegisterColumnMask(tableId, "hide", "VERIFICATION_CODE", "PASSWORD")
 }
 
 type SginupInterceptor struct {
@@ -20,6 +22,5 @@ func (this *SginupInterceptor) AfterExec(resourceId string, script string, param
 	if userMap, ok := userInfo.([]map[string]string); ok {
 		SendMail("UpRun User Verification", userMap[0]["VERIFICATION_CODE"], userMap[0]["EMAIL"])
 	}
-	(*data)[0][5] = ""
 	return nil
 }
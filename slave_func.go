@@ -106,7 +106,16 @@ func processWsCommandSlave(conn *websocket.Conn, message []byte) error {
 			return err
 		}
 		log.Println("Master data updated.")
-		return json.Unmarshal([]byte(masterCommand.Data), Websql.masterData)
+		masterDataMutex.Lock()
+		err = json.Unmarshal([]byte(masterCommand.Data), Websql.masterData)
+		masterDataMutex.Unlock()
+		if err != nil {
+			return err
+		}
+		refreshDbos(Websql.masterData)
+		return nil
+	case "WS_REVOKED_TOKENS":
+		return setRevokedTokens(wsCommand.Data)
 	}
 	return nil
 }
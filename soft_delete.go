@@ -0,0 +1,323 @@
+// soft_delete
+package websql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elgs/gosqljson"
+)
+
+// Deleting a row of a soft delete table stamps its DELETED_ columns instead of
+// removing it. Lists and loads leave such rows out unless the client asks for
+// include_deleted=1. The purge job removes them for good after the number of
+// days the table is configured with.
+//
+// A table is put in soft delete mode per app in the master data with the
+// softdelete command, or for all apps with RegisterSoftDelete.
+var softDeletePurgeCron = "@daily"
+
+// RegisterSoftDelete puts a table in soft delete mode. The table needs a
+// DELETED_AT column, DELETED_BY and DELETED_FROM are stamped when present.
+// Deleted rows are purged after purgeDays, never if purgeDays is 0. tableId is
+// either the table name or db.table.
+func (this *WebSQL) RegisterSoftDelete(tableId string, purgeDays int) {
+	this.SoftDeleteTables[strings.ToUpper(unquoteIdentifier(tableId))] = &SoftDelete{
+		TableId:   unquoteIdentifier(tableId),
+		PurgeDays: purgeDays,
+	}
+}
+
+// softDeleteTable returns the soft delete mode of a table in the app of a
+// request, from the master data or else from RegisterSoftDelete.
+func softDeleteTable(tableId string, context map[string]interface{}) (*SoftDelete, bool) {
+	id := strings.ToUpper(unquoteIdentifier(tableId))
+	_, table := splitTableId(id)
+	if app := contextApp(context); app != nil {
+		for _, softDelete := range app.SoftDeletes {
+			if softDelete.AppId == app.Id && strings.EqualFold(softDelete.TableId, table) {
+				return softDelete, true
+			}
+		}
+	}
+	if softDelete, ok := Websql.SoftDeleteTables[id]; ok {
+		return softDelete, true
+	}
+	softDelete, ok := Websql.SoftDeleteTables[table]
+	return softDelete, ok
+}
+
+// softDeleteFilter returns the predicate hiding the deleted rows of a table,
+// prefixed by alias if not empty, or "" if the table is not in soft delete
+// mode or the client asked for deleted rows.
+func (this *MySqlDataOperator) softDeleteFilter(tableId string, alias string, context map[string]interface{}) string {
+	if _, ok := softDeleteTable(tableId, context); !ok {
+		return ""
+	}
+	if includeDeleted, _ := context["include_deleted"].(bool); includeDeleted {
+		return ""
+	}
+	// Unquoted, so that it matches deleted_at on Postgres as well.
+	if alias != "" {
+		return alias + ".DELETED_AT IS NULL"
+	}
+	return "DELETED_AT IS NULL"
+}
+
+// softDeleteSets returns the SET clause stamping the DELETED_ columns of a
+// table, or clearing them when restore is true.
+func (this *MySqlDataOperator) softDeleteSets(q sqlQuerier, tableId string, restore bool, context map[string]interface{}) (string, []interface{}, error) {
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, ok := columns["DELETED_AT"]; !ok {
+		return "", nil, errors.New("Soft delete table has no DELETED_AT column: " + unquoteIdentifier(tableId))
+	}
+	values := map[string]interface{}{
+		"DELETED_AT":   time.Now().UTC(),
+		"DELETED_BY":   context["user_email"],
+		"DELETED_FROM": context["client_ip"],
+	}
+	d := this.GetDialect()
+	sets := []string{}
+	args := []interface{}{}
+	for _, c := range []string{"DELETED_AT", "DELETED_BY", "DELETED_FROM"} {
+		name, ok := columns[c]
+		if !ok {
+			continue
+		}
+		if restore {
+			sets = append(sets, d.QuoteIdentifier(name)+"=NULL")
+		} else {
+			sets = append(sets, d.QuoteIdentifier(name)+"=?")
+			args = append(args, values[c])
+		}
+	}
+	return strings.Join(sets, ","), args, nil
+}
+
+// Restore undeletes soft deleted rows. It returns the number of rows restored
+// per id.
+func (this *MySqlDataOperator) Restore(tableId string, id []string, context map[string]interface{}) ([]int64, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return nil, err
+	}
+
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeRestore(tableId, db, context, id)
+		if err != nil {
+			rollbackContextTx(context)
+			return nil, err
+		}
+	}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.BeforeRestore(tableId, db, context, id)
+			if err != nil {
+				rollbackContextTx(context)
+				return nil, err
+			}
+		}
+	}
+
+	var q sqlQuerier = db
+	var e sqlExecer = db
+	if tx, ok := context["tx"].(*sql.Tx); ok {
+		q = tx
+		e = tx
+	}
+	rollback := func() {
+		rollbackContextTx(context)
+	}
+	if _, ok := softDeleteTable(tableId, context); !ok {
+		rollback()
+		return nil, &RequestError{
+			Status:  http.StatusBadRequest,
+			Code:    "not_soft_delete",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Table is not in soft delete mode: " + unquoteIdentifier(tableId),
+		}
+	}
+	key, err := this.tableKey(q, tableId)
+	if err == nil {
+		err = key.check(tableId)
+	}
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	sets, _, err := this.softDeleteSets(q, tableId, true, context)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	d := this.GetDialect()
+	rowWhere := key.where(d)
+	if policy != "" {
+		rowWhere = fmt.Sprint(rowWhere, " AND ", policy)
+	}
+	query := d.Rebind(fmt.Sprint("UPDATE ", tableId, " SET ", sets, " WHERE ", rowWhere, " AND DELETED_AT IS NOT NULL"))
+
+	ret := []int64{}
+	for _, id1 := range id {
+		keyValues, err := splitKey(id1, len(key.Columns))
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		result, err := e.Exec(query, append(append([]interface{}{}, keyValues...), policyArgs...)...)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		ret = append(ret, rowsAffected)
+	}
+
+	for _, k := range sortedKeys {
+		dataInterceptor := dataInterceptors[k]
+		if dataInterceptor != nil {
+			err := dataInterceptor.AfterRestore(tableId, db, context, id)
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+		}
+	}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.AfterRestore(tableId, db, context, id)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	err = commitContextTx(context)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// purgeDeleted removes the rows of a soft delete table deleted before the
+// given time.
+func (this *MySqlDataOperator) purgeDeleted(tableId string, before time.Time) (int64, error) {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	db, err := this.GetConn()
+	if err != nil {
+		return 0, err
+	}
+	columns, err := this.tableColumns(db, tableId)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := columns["DELETED_AT"]; !ok {
+		return 0, nil
+	}
+	d := this.GetDialect()
+	return gosqljson.ExecDb(db, d.Rebind(fmt.Sprint("DELETE FROM ", tableId, " WHERE DELETED_AT<?")), before)
+}
+
+type softDeletePurger interface {
+	purgeDeleted(tableId string, before time.Time) (int64, error)
+	dbName() string
+}
+
+func (this *MySqlDataOperator) dbName() string {
+	return extractDbNameFromDs(this.DbType, this.Ds)
+}
+
+// purgeSoftDeleted runs the purge of every soft delete table in every app
+// that has the table. A table registered as db.table is only purged in the
+// app of that database.
+func (this *WebSQL) purgeSoftDeleted() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println(err)
+		}
+	}()
+	// The tables of each app are copied, the master data may change while
+	// the purge runs.
+	masterDataMutex.Lock()
+	appIds := []string{}
+	appSoftDeletes := map[string][]SoftDelete{}
+	for _, app := range this.masterData.Apps {
+		appIds = append(appIds, app.Id)
+		for _, softDelete := range app.SoftDeletes {
+			if softDelete.AppId == app.Id {
+				appSoftDeletes[app.Id] = append(appSoftDeletes[app.Id], *softDelete)
+			}
+		}
+	}
+	masterDataMutex.Unlock()
+
+	for _, appId := range appIds {
+		dbo, err := this.getDbo(appId)
+		if err != nil || dbo == nil {
+			continue
+		}
+		if nd, ok := dbo.(*NdDataOperator); ok {
+			dbo = nd.DataOperator
+		}
+		purger, ok := dbo.(softDeletePurger)
+		if !ok {
+			continue
+		}
+		softDeletes := appSoftDeletes[appId]
+		for _, softDelete := range this.SoftDeleteTables {
+			softDeletes = append(softDeletes, *softDelete)
+		}
+		purged := map[string]bool{}
+		for _, softDelete := range softDeletes {
+			db, table := splitTableId(softDelete.TableId)
+			if db != "" && !strings.EqualFold(db, purger.dbName()) {
+				continue
+			}
+			// The setting of the app comes first and wins.
+			if purged[strings.ToUpper(table)] {
+				continue
+			}
+			purged[strings.ToUpper(table)] = true
+			if softDelete.PurgeDays <= 0 {
+				continue
+			}
+			_, err := purger.purgeDeleted(table, time.Now().UTC().AddDate(0, 0, -softDelete.PurgeDays))
+			if err != nil {
+				if re, ok := err.(*RequestError); !ok || re.Code != "not_found" {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// startPurgeJob schedules the purge on the master only, the other nodes
+// reach the same databases.
+func (this *WebSQL) startPurgeJob() error {
+	if strings.TrimSpace(this.service.Master) != "" {
+		return nil
+	}
+	_, err := this.Sched.AddFunc(softDeletePurgeCron, this.purgeSoftDeleted)
+	return err
+}
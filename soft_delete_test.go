@@ -0,0 +1,138 @@
+// soft_delete
+package websql
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSoftDeleteColumns = "DELETED_AT TEXT, DELETED_BY TEXT, " + testAuditColumns
+
+func TestSqliteSoftDelete(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", SoftDeletes: []*SoftDelete{{TableId: "ITEM", AppId: "app"}}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, "+testSoftDeleteColumns+")",
+		"CREATE TABLE LOG (ID INTEGER PRIMARY KEY, "+testSoftDeleteColumns+")",
+		"INSERT INTO ITEM (ID) VALUES (1), (2)",
+		"INSERT INTO LOG (ID) VALUES (1)")
+
+	deleted, err := dbo.Delete("ITEM", []string{"1"}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, []int64{1}) {
+		t.Fatal("Expected one row deleted:", deleted)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "ID", testContext(t, app, nil)); !reflect.DeepEqual(ids, []interface{}{int64(2)}) {
+		t.Fatal("Expected the deleted row hidden:", ids)
+	}
+	if row, err := dbo.LoadTyped("ITEM", "1", "*", testContext(t, app, nil)); err != nil || len(row) != 0 {
+		t.Fatal("Expected the deleted row not loaded:", row, err)
+	}
+	context := testContext(t, app, nil)
+	context["include_deleted"] = true
+	row, err := dbo.LoadTyped("ITEM", "1", "*", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["DELETED_AT"] == nil || row["DELETED_BY"] != "user@example.com" {
+		t.Fatal("Expected the row stamped as deleted:", row)
+	}
+
+	restored, err := dbo.Restore("ITEM", []string{"1", "2"}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, []int64{1, 0}) {
+		t.Fatal("Expected the deleted row restored only:", restored)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "ID", testContext(t, app, nil)); len(ids) != 2 {
+		t.Fatal("Expected the restored row listed:", ids)
+	}
+
+	// Tables not in soft delete mode are deleted for good.
+	_, err = dbo.Restore("LOG", []string{"1"}, testContext(t, app, nil))
+	requireStatus(t, err, 400)
+	if _, err := dbo.Delete("LOG", []string{"1"}, testContext(t, app, nil)); err != nil {
+		t.Fatal(err)
+	}
+	context = testContext(t, app, nil)
+	context["include_deleted"] = true
+	if ids := listIds(t, dbo, "LOG", nil, "", context); len(ids) != 0 {
+		t.Fatal("Expected the row removed:", ids)
+	}
+}
+
+func TestSqlitePurgeSoftDeleted(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app", SoftDeletes: []*SoftDelete{
+		{TableId: "ITEM", AppId: "app", PurgeDays: 30},
+		{TableId: "KEPT", AppId: "app"},
+	}})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, DELETED_AT TEXT)",
+		"CREATE TABLE KEPT (ID INTEGER PRIMARY KEY, DELETED_AT TEXT)",
+		"INSERT INTO ITEM VALUES (1, '2000-01-01 00:00:00'), (2, datetime('now')), (3, NULL)",
+		"INSERT INTO KEPT VALUES (1, '2000-01-01 00:00:00')")
+	getDbo := Websql.getDbo
+	Websql.getDbo = func(id string) (DataOperator, error) {
+		return dbo, nil
+	}
+	// The setting of the app wins over a registered one.
+	Websql.RegisterSoftDelete("KEPT", 1)
+	t.Cleanup(func() {
+		Websql.getDbo = getDbo
+		delete(Websql.SoftDeleteTables, "KEPT")
+	})
+
+	Websql.purgeSoftDeleted()
+	context := testContext(t, app, nil)
+	context["include_deleted"] = true
+	if ids := listIds(t, dbo, "ITEM", nil, "ID", context); !reflect.DeepEqual(ids, []interface{}{int64(2), int64(3)}) {
+		t.Fatal("Expected the old deleted row purged:", ids)
+	}
+	if ids := listIds(t, dbo, "KEPT", nil, "ID", context); len(ids) != 1 {
+		t.Fatal("Expected the rows of a table without purge days kept:", ids)
+	}
+}
+
+func TestSetSoftDelete(t *testing.T) {
+	newTestApp(t, &App{Id: "app"})
+	dataFile := Websql.service.DataFile
+	Websql.service.DataFile = filepath.Join(t.TempDir(), "data.json")
+	t.Cleanup(func() {
+		Websql.service.DataFile = dataFile
+	})
+	masterData := Websql.masterData
+
+	if err := masterData.SetSoftDelete(&SoftDelete{TableId: "ITEM", AppId: "app", PurgeDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := masterData.SetSoftDelete(&SoftDelete{TableId: "item", AppId: "app", PurgeDays: 30}); err != nil {
+		t.Fatal(err)
+	}
+	softDeletes := masterData.Apps[0].SoftDeletes
+	if len(softDeletes) != 1 || softDeletes[0].PurgeDays != 30 {
+		t.Fatal("Expected the purge days of the table changed:", softDeletes)
+	}
+	if softDelete, ok := softDeleteTable(`"ITEM"`, map[string]interface{}{"app_id": "app"}); !ok || softDelete.PurgeDays != 30 {
+		t.Fatal("Expected the table in soft delete mode.")
+	}
+	if _, ok := softDeleteTable("ITEM", map[string]interface{}{"app_id": "other"}); ok {
+		t.Fatal("Expected the table of another app not in soft delete mode.")
+	}
+	for _, softDelete := range []*SoftDelete{{TableId: "db.ITEM", AppId: "app"}, {TableId: "ITEM", AppId: "app", PurgeDays: -1}} {
+		if err := masterData.SetSoftDelete(softDelete); err == nil {
+			t.Fatal("Expected the soft delete rejected:", softDelete)
+		}
+	}
+	if err := masterData.RemoveSoftDelete("ITEM", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(masterData.Apps[0].SoftDeletes) != 0 {
+		t.Fatal("Expected the table out of soft delete mode.")
+	}
+	if err := masterData.RemoveSoftDelete("ITEM", "app"); err == nil {
+		t.Fatal("Expected a missing soft delete table reported.")
+	}
+}
@@ -0,0 +1,17 @@
+// sqlite_data_operator
+package websql
+
+type SqliteDataOperator struct {
+	*MySqlDataOperator
+}
+
+// NewSqliteDataOperator shares the MySQL operator's implementation. All SQL
+// differences are handled by SqliteDialect, which is picked by DbType.
+func NewSqliteDataOperator(ds string) *SqliteDataOperator {
+	return &SqliteDataOperator{
+		MySqlDataOperator: &MySqlDataOperator{
+			Ds:     ds,
+			DbType: "sqlite3",
+		},
+	}
+}
@@ -0,0 +1,213 @@
+// sqlite_data_operator
+package websql
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const testApiToken = "test_token"
+
+// The token interceptor stamps these on every row it writes.
+const testAuditColumns = "CREATED_AT TEXT, CREATED_BY TEXT, UPDATED_AT TEXT, UPDATED_BY TEXT"
+
+var testSigningKey *SigningKey
+
+// newTestDbo opens a SQLite data operator on a new database file and runs
+// the schema statements. The test is skipped where the sqlite3 driver is not
+// built in.
+func newTestDbo(t *testing.T, schema ...string) *SqliteDataOperator {
+	t.Helper()
+	dbo := NewSqliteDataOperator(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() {
+		dbo.Close()
+	})
+	db, err := dbo.GetConn()
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		t.Skip("sqlite3 is not available:", err)
+	}
+	for _, statement := range schema {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatal(statement, err)
+		}
+	}
+	return dbo
+}
+
+// newTestApp makes app the only app of the master data, with an API token
+// that grants everything, and a key to sign its user tokens with.
+func newTestApp(t *testing.T, app *App) *App {
+	t.Helper()
+	if testSigningKey == nil {
+		key, err := newSigningKey("ES256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSigningKey = key
+	}
+	app.Tokens = append(app.Tokens, &Token{Id: testApiToken, AppId: app.Id, Target: "*", Mode: "*"})
+	masterData := Websql.masterData
+	Websql.masterData = &MasterData{Apps: []*App{app}, SigningKeys: []*SigningKey{testSigningKey}}
+	t.Cleanup(func() {
+		Websql.masterData = masterData
+	})
+	return app
+}
+
+// testContext returns the context of a request to app by a user with claims
+// and roles.
+func testContext(t *testing.T, app *App, claims map[string]interface{}, roles ...string) map[string]interface{} {
+	t.Helper()
+	userClaims := map[string]interface{}{"email": "user@example.com", "roles": roles}
+	for k, v := range claims {
+		userClaims[k] = v
+	}
+	token, err := signAccessToken(app.Id, userClaims, "", uuid.NewV4().String(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"app_id":     app.Id,
+		"api_token":  testApiToken,
+		"user_token": token,
+		"case":       "upper",
+	}
+}
+
+// requireStatus fails the test unless err is a RequestError with status.
+func requireStatus(t *testing.T, err error, status int) *RequestError {
+	t.Helper()
+	requestError, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("Expected a %d error, got: %v", status, err)
+	}
+	if requestError.Status != status {
+		t.Fatalf("Expected a %d error, got %d: %v", status, requestError.Status, requestError.Message)
+	}
+	return requestError
+}
+
+// listIds lists the rows of a table and returns their ID column in order.
+func listIds(t *testing.T, dbo DataOperator, tableId string, filter []string, sort string,
+	context map[string]interface{}) []interface{} {
+	t.Helper()
+	rows, _, err := dbo.ListMapTyped(tableId, "*", filter, sort, "", 0, 100, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := []interface{}{}
+	for _, row := range rows {
+		ret = append(ret, row["ID"])
+	}
+	return ret
+}
+
+func TestSqliteCrud(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY AUTOINCREMENT, NAME TEXT NOT NULL, "+testAuditColumns+")")
+
+	ids, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}, {"NAME": "b"}}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] == nil || ids[1] == nil {
+		t.Fatal("Expected the ids of the created rows:", ids)
+	}
+
+	row, err := dbo.LoadTyped("ITEM", keyValueString(ids[0]), "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["NAME"] != "a" {
+		t.Fatal("Expected the created row:", row)
+	}
+
+	data := []map[string]interface{}{{"ID": ids[0], "NAME": "c"}}
+	updated, err := dbo.Update("ITEM", data, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0] != 1 {
+		t.Fatal("Expected one row updated:", updated)
+	}
+	row, err = dbo.LoadTyped("ITEM", keyValueString(ids[0]), "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["NAME"] != "c" {
+		t.Fatal("Expected the row updated:", row)
+	}
+
+	deleted, err := dbo.Delete("ITEM", []string{keyValueString(ids[1])}, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatal("Expected one row deleted:", deleted)
+	}
+	rows, total, err := dbo.ListMapTyped("ITEM", "*", nil, "", "", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(rows) != 1 {
+		t.Fatal("Expected one row left:", total, rows)
+	}
+}
+
+// An app on a sqlite3 data node gets its own database file in the data node's
+// directory.
+func TestSqliteApp(t *testing.T) {
+	newTestDbo(t)
+	dir := t.TempDir()
+	app := newTestApp(t, &App{Id: "app", DbName: "test", DataNodeId: "dn"})
+	Websql.masterData.DataNodes = []*DataNode{{Id: "dn", Name: "dn", Type: "sqlite3", Host: dir}}
+	file := filepath.Join(dir, "nd_test.db")
+
+	if err := app.OnAppCreateOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("Expected the database file of the app:", err)
+	}
+	dbo, err := MakeGetDbo("mysql", Websql.masterData)(app.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		delete(Websql.handlers.DboRegistry, app.Id)
+		delete(Websql.handlers.dboFingerprints, app.Id)
+		dbo.Close()
+	})
+	if _, ok := dbo.GetDialect().(*SqliteDialect); !ok {
+		t.Fatal("Expected the SQLite dialect.")
+	}
+	db, err := dbo.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, " + testAuditColumns + ")"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}}, testContext(t, app, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 1 {
+		t.Fatal("Expected the created row:", ids)
+	}
+
+	dbo.Close()
+	if err := app.OnAppRemove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("Expected the database file removed:", err)
+	}
+}
@@ -0,0 +1,459 @@
+// stream
+package websql
+
+import (
+	stdcontext "context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/elgs/gosplitargs"
+)
+
+// A streamed list or query result is written to the client row by row as the
+// rows are scanned, either as {"data":[...]} or as NDJSON, one row per line.
+// Memory use stays the same whatever the size of the result. A streamed
+// result has no total, and an error after the first row can no longer change
+// the status, so it ends the stream with an "err" entry instead.
+var streamFlushRows = 100
+
+// Streamed lists are not paged unless the client sets a limit.
+var streamDefaultLimit int64 = math.MaxInt64
+
+// RowWriter receives the rows of a streamed result as they are scanned.
+type RowWriter interface {
+	// Headers starts a result set with its column names.
+	Headers(headers []string) error
+	Row(row []interface{}) error
+}
+
+// streamFormat returns the stream format the client asks for, with format=,
+// stream= or the Accept header, or "" for a whole JSON response. NDJSON, CSV
+// and TSV are always streamed. It only reads the url, the body of a PATCH is
+// the query params.
+func streamFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+	case "ndjson", "csv", "tsv":
+		return format, nil
+	default:
+		return "", newParamError("format", format, "Invalid format: "+format)
+	}
+	stream := r.URL.Query().Get("stream")
+	switch stream {
+	case "":
+	case "json", "ndjson":
+		return stream, nil
+	case "1", "true":
+		return "json", nil
+	default:
+		return "", newParamError("stream", stream, "Invalid stream format: "+stream)
+	}
+	if format == "" {
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+			for f, contentType := range exportContentTypes {
+				if strings.HasPrefix(contentType, mediaType+";") {
+					return f, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// streamWriter is a RowWriter that writes to the response.
+type streamWriter interface {
+	RowWriter
+	// Started tells if anything was written, after which errors can only be
+	// reported by Close.
+	Started() bool
+	// Close ends the stream, with err if the result could not be written to
+	// the end.
+	Close(err error) error
+}
+
+// newStreamWriter returns the writer of a stream format for the rows of the
+// table or query name.
+func newStreamWriter(r *http.Request, w http.ResponseWriter, format string, array bool, name string) (streamWriter, error) {
+	if format == "csv" || format == "tsv" {
+		return newCsvRowWriter(r, w, format, name)
+	}
+	rw := newJsonRowWriter(w, format, array)
+	rw.filename = r.URL.Query().Get("filename")
+	return rw, nil
+}
+
+// requestCtx returns the context of the request being served, which is done
+// when the client goes away.
+func requestCtx(context map[string]interface{}) stdcontext.Context {
+	if ctx, ok := context["request_ctx"].(stdcontext.Context); ok {
+		return ctx
+	}
+	return stdcontext.Background()
+}
+
+type jsonRowWriter struct {
+	w        http.ResponseWriter
+	ndjson   bool
+	array    bool
+	headers  []string
+	filename string
+	started  bool
+	rows     int
+}
+
+// newJsonRowWriter writes rows as objects keyed by column, or as arrays with
+// the header row first in each result set when array is true.
+func newJsonRowWriter(w http.ResponseWriter, format string, array bool) *jsonRowWriter {
+	return &jsonRowWriter{
+		w:      w,
+		ndjson: format == "ndjson",
+		array:  array,
+	}
+}
+
+func (this *jsonRowWriter) start() error {
+	if this.started {
+		return nil
+	}
+	this.started = true
+	if this.filename != "" {
+		if this.ndjson {
+			this.w.Header().Set("Content-Disposition", contentDisposition(this.filename, "ndjson"))
+		} else {
+			this.w.Header().Set("Content-Disposition", contentDisposition(this.filename, "json"))
+		}
+	}
+	if this.ndjson {
+		this.w.Header().Set("Content-Type", exportContentTypes["ndjson"])
+		return nil
+	}
+	this.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, err := fmt.Fprint(this.w, `{"data":[`)
+	return err
+}
+
+func (this *jsonRowWriter) Started() bool {
+	return this.started
+}
+
+func (this *jsonRowWriter) flush() {
+	if flusher, ok := this.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *jsonRowWriter) Headers(headers []string) error {
+	err := this.start()
+	if err != nil {
+		return err
+	}
+	this.headers = headers
+	if this.array {
+		row := make([]interface{}, len(headers))
+		for i, h := range headers {
+			row[i] = h
+		}
+		return this.Row(row)
+	}
+	return nil
+}
+
+func (this *jsonRowWriter) Row(row []interface{}) error {
+	err := this.start()
+	if err != nil {
+		return err
+	}
+	var v interface{} = row
+	if !this.array {
+		m := make(map[string]interface{}, len(this.headers))
+		for i, h := range this.headers {
+			if i < len(row) {
+				m[h] = row[i]
+			}
+		}
+		v = m
+	}
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if this.ndjson {
+		jsonData = append(jsonData, '\n')
+	} else if this.rows > 0 {
+		jsonData = append([]byte{','}, jsonData...)
+	}
+	_, err = this.w.Write(jsonData)
+	if err != nil {
+		return err
+	}
+	this.rows++
+	if this.rows%streamFlushRows == 0 {
+		this.flush()
+	}
+	return nil
+}
+
+func (this *jsonRowWriter) Close(err error) error {
+	errStart := this.start()
+	if errStart != nil {
+		return errStart
+	}
+	tail := ""
+	if err != nil {
+		errData, _ := json.Marshal(err.Error())
+		if this.ndjson {
+			tail = `{"err":` + string(errData) + "}\n"
+		} else {
+			tail = `],"err":` + string(errData) + "}"
+		}
+	} else if !this.ndjson {
+		tail = "]}"
+	}
+	_, errWrite := fmt.Fprint(this.w, tail)
+	this.flush()
+	return errWrite
+}
+
+// sqlContextQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqlContextQuerier interface {
+	QueryContext(ctx stdcontext.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// streamTypedRows scans the rows of a query one at a time into rw. The query
+// is cancelled when ctx is done.
+func streamTypedRows(ctx stdcontext.Context, q sqlContextQuerier, theCase string, rw RowWriter, sqlStatement string, sqlParams ...interface{}) error {
+	rows, err := q.QueryContext(ctx, sqlStatement, sqlParams...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = convertColumnCase(column, theCase)
+	}
+	err = rw.Headers(headers)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	row := make([]interface{}, len(columns))
+	for rows.Next() {
+		err = rows.Scan(valuePtrs...)
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			row[i] = typedValue(v, columnTypes[i])
+		}
+		err = rw.Row(row)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// checkStreamable refuses to stream a resource with interceptors of its own,
+// their After hooks expect the whole result.
+func checkStreamable(resourceId string) error {
+	_, sortedKeys := Websql.Interceptors.GetDataInterceptors(resourceId)
+	if len(sortedKeys) > 0 {
+		return newParamError("stream", unquoteIdentifier(resourceId), "Results of "+unquoteIdentifier(resourceId)+" cannot be streamed.")
+	}
+	return nil
+}
+
+// ListStream writes the typed rows of a list to rw as they are scanned. It does
+// not count, expand or page by cursor. The global After list hooks run with
+// no rows.
+func (this *MySqlDataOperator) ListStream(tableId string, fields string, filter []string, sort string, group string,
+	start int64, limit int64, rw RowWriter, context map[string]interface{}) error {
+	tableId = normalizeTableId(tableId, this.DbType, this.Ds)
+	err := checkStreamable(tableId)
+	if err != nil {
+		return err
+	}
+	db, err := this.GetConn()
+	if err != nil {
+		return err
+	}
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return err
+	}
+	rollback := func() {
+		if pinnedTx(context) == nil {
+			tx.Rollback()
+		}
+	}
+
+	context["count"] = "none"
+	where := this.listWhere(tableId, context)
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeListMap(tableId, db, fields, context, &where, &sort, &group, start, limit)
+		if err != nil {
+			rollback()
+			return err
+		}
+	}
+	// The schema is only looked at once the hooks let the caller in, so
+	// that the errors do not tell tables and columns apart.
+	fields, sort, group, keyset, err := this.parseListParams(tx, tableId, fields, sort, group, context)
+	if err != nil {
+		rollback()
+		return err
+	}
+	where, err = this.parseFilters(tx, tableId, where, filter, context)
+	if err != nil {
+		rollback()
+		return err
+	}
+	where, err = policyFilter(where, tableId, context)
+	if err != nil {
+		rollback()
+		return err
+	}
+	rw = maskRows(rw, tableId, context)
+	c := context["case"].(string)
+	sqlQuery, args, err := this.listQuery(tableId, fields, where, group, sort, start, limit, keyset, context)
+	if err != nil {
+		rollback()
+		return err
+	}
+	err = streamTypedRows(requestCtx(context), tx, c, rw, sqlQuery, args...)
+	if err != nil {
+		rollback()
+		return err
+	}
+
+	m := []map[string]interface{}{}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterListMapTyped(tableId, db, fields, context, &m, -1)
+	}
+	commitRequestTx(tx, context)
+	return nil
+}
+
+// ExecStream runs a named query like Exec, but writes the rows of each select
+// to rw as they are scanned instead of returning them. The rows affected by
+// other statements are not reported.
+func (this *NdDataOperator) ExecStream(tableId string, params [][]interface{}, queryParams map[string]string, rw RowWriter, context map[string]interface{}) error {
+	projectId := context["app_id"].(string)
+	theCase := context["case"].(string)
+	err := checkStreamable(tableId)
+	if err != nil {
+		return err
+	}
+	sqlScript, err := Websql.getQueryText(projectId, tableId)
+	if err != nil {
+		return err
+	}
+	scripts := sqlScript
+
+	db, err := this.GetConn()
+	if err != nil {
+		return err
+	}
+
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		err := globalDataInterceptor.BeforeExec(tableId, scripts, &params, queryParams, true, db, context)
+		if err != nil {
+			return err
+		}
+	}
+
+	rw = maskRows(rw, tableId, context)
+	tx, err := beginRequestTx(db, context)
+	if err != nil {
+		return err
+	}
+	replaceContext := buildReplaceContext(context)
+	err = streamExecuteTx(requestCtx(context), tx, &scripts, queryParams, params, theCase, replaceContext, this.GetDialect(), rw)
+	if err != nil {
+		if pinnedTx(context) == nil {
+			tx.Rollback()
+		}
+		return err
+	}
+
+	retArray := [][]interface{}{}
+	for _, k := range globalSortedKeys {
+		globalDataInterceptor := globalDataInterceptors[k]
+		globalDataInterceptor.AfterExec(tableId, scripts, &params, queryParams, true, db, context, &retArray)
+	}
+
+	commitRequestTx(tx, context)
+	return nil
+}
+
+// streamExecuteTx runs a script like batchExecuteTx, streaming the rows of its
+// selects to rw.
+func streamExecuteTx(ctx stdcontext.Context, tx *sql.Tx, script *string, scriptParams map[string]string, params [][]interface{}, theCase string,
+	replaceContext map[string]string, dialect Dialect, rw RowWriter) error {
+	for k, v := range scriptParams {
+		*script = strings.Replace(*script, k, v, -1)
+	}
+	for k, v := range replaceContext {
+		*script = strings.Replace(*script, k, v, -1)
+	}
+
+	scriptsArray, err := gosplitargs.SplitArgs(*script, ";", true)
+	if err != nil {
+		return err
+	}
+	for _, params1 := range params {
+		totalCount := 0
+		for _, s := range scriptsArray {
+			sqlNormalize(&s)
+			if len(s) == 0 {
+				continue
+			}
+			count, err := gosplitargs.CountSeparators(s, "\\?")
+			if err != nil {
+				return err
+			}
+			if len(params1) < totalCount+count {
+				return errors.New(fmt.Sprintln("Incorrect param count. Expected: ", totalCount+count, " actual: ", len(params1)))
+			}
+			if dialect != nil {
+				s = dialect.Rebind(s)
+			}
+			if isQuery(s) {
+				err = streamTypedRows(ctx, tx, theCase, rw, s, params1[totalCount:totalCount+count]...)
+			} else {
+				_, err = tx.ExecContext(ctx, s, params1[totalCount:totalCount+count]...)
+			}
+			if err != nil {
+				return err
+			}
+			totalCount += count
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// stream
+package websql
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rowCollector keeps the rows it is given, failing once it has failAt rows.
+type rowCollector struct {
+	headers []string
+	rows    [][]interface{}
+	failAt  int
+}
+
+func (this *rowCollector) Headers(headers []string) error {
+	this.headers = headers
+	return nil
+}
+
+func (this *rowCollector) Row(row []interface{}) error {
+	if this.failAt > 0 && len(this.rows) == this.failAt {
+		return errors.New("Client went away.")
+	}
+	this.rows = append(this.rows, append([]interface{}{}, row...))
+	return nil
+}
+
+// getTestRest sends a GET with the tokens of context and returns the raw
+// response.
+func getTestRest(t *testing.T, path string, apiToken string, context map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("api-token", apiToken)
+	r.Header.Set("user-token", context["user_token"].(string))
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	return w
+}
+
+func TestSqliteListStream(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT)",
+		"INSERT INTO ITEM VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+
+	rw := &rowCollector{}
+	err := dbo.ListStream("ITEM", "ID,NAME", []string{"ID>1"}, "ID", "", 0, streamDefaultLimit, rw, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rw.headers, []string{"ID", "NAME"}) {
+		t.Fatal("Expected the selected columns:", rw.headers)
+	}
+	if !reflect.DeepEqual(rw.rows, [][]interface{}{{int64(2), "b"}, {int64(3), "c"}}) {
+		t.Fatal("Expected the filtered rows in order:", rw.rows)
+	}
+
+	rw = &rowCollector{}
+	err = dbo.ListStream("ITEM", "ID", nil, "ID", "", 1, 1, rw, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rw.rows, [][]interface{}{{int64(2)}}) {
+		t.Fatal("Expected the page of rows:", rw.rows)
+	}
+
+	// A writer that fails stops the stream with its error.
+	rw = &rowCollector{failAt: 1}
+	err = dbo.ListStream("ITEM", "ID", nil, "ID", "", 0, streamDefaultLimit, rw, testContext(t, app, nil))
+	if err == nil || len(rw.rows) != 1 {
+		t.Fatal("Expected the stream stopped:", err, rw.rows)
+	}
+
+	// The user must be let in before anything is read.
+	err = dbo.ListStream("ITEM", "ID", nil, "", "", 0, streamDefaultLimit, &rowCollector{}, map[string]interface{}{"app_id": "app"})
+	if err == nil {
+		t.Fatal("Expected a stream without user token refused.")
+	}
+}
+
+func TestSqliteRestStream(t *testing.T) {
+	app := newTestApp(t, &App{Id: testRestAppId})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT)",
+		"INSERT INTO ITEM VALUES (1, 'a'), (2, 'b')")
+	apiToken := newTestRest(t, app, dbo)
+
+	w := getTestRest(t, "/api/ITEM?format=ndjson&sort=ID", apiToken, testContext(t, app, nil))
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-ndjson") {
+		t.Fatal("Expected NDJSON:", w.Code, w.Header(), w.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"ID":1,"NAME":"a"}` {
+		t.Fatal("Expected a row per line:", lines)
+	}
+
+	w = getTestRest(t, "/api/ITEM?stream=1&sort=ID", apiToken, testContext(t, app, nil))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if data, _ := body["data"].([]interface{}); len(data) != 2 || body["total"] != nil {
+		t.Fatal("Expected the rows without total:", body)
+	}
+
+	w = getTestRest(t, "/api/ITEM?stream=1&cursor=", apiToken, testContext(t, app, nil))
+	if w.Code != 400 {
+		t.Fatal("Expected cursor paging refused with stream:", w.Code, w.Body.String())
+	}
+	w = getTestRest(t, "/api/ITEM?format=xml", apiToken, testContext(t, app, nil))
+	if w.Code != 400 {
+		t.Fatal("Expected an unknown format refused:", w.Code, w.Body.String())
+	}
+}
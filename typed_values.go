@@ -0,0 +1,319 @@
+// typed_values
+package websql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02",
+	"15:04:05",
+}
+
+// typedValue normalizes a scanned column value into one of int64, uint64,
+// float64, json.Number, bool, time.Time, []byte, string or nil, based on the
+// column's database type. Drivers hand back []byte for most columns in text
+// mode, so those are parsed.
+func typedValue(v interface{}, columnType *sql.ColumnType) interface{} {
+	if v == nil {
+		return nil
+	}
+	b, isBytes := v.([]byte)
+	if !isBytes {
+		switch x := v.(type) {
+		case int:
+			return int64(x)
+		case int32:
+			return int64(x)
+		case uint64:
+			return unsignedValue(x)
+		case float32:
+			return float64(x)
+		}
+		return v
+	}
+	return parseTypedValue(b, strings.ToUpper(columnType.DatabaseTypeName()))
+}
+
+// parseTypedValue parses the text of a value of a database type. DECIMAL and
+// NUMERIC are exact, so they are kept as a json.Number rather than rounded to
+// a float64.
+func parseTypedValue(b []byte, dbType string) interface{} {
+	s := string(b)
+	switch dbType {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8", "SERIAL", "BIGSERIAL", "YEAR":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case "UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED BIGINT":
+		if i, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return unsignedValue(i)
+		}
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "DECIMAL", "NUMERIC":
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(s)
+		}
+	case "BOOL", "BOOLEAN":
+		if t, err := strconv.ParseBool(s); err == nil {
+			return t
+		}
+	case "DATE", "DATETIME", "TIMESTAMP", "TIMESTAMPTZ", "TIME":
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BYTEA", "BIT":
+		return b
+	}
+	return s
+}
+
+// unsignedValue returns an unsigned value as an int64 if it fits, so that
+// most integers have the same type whatever their column.
+func unsignedValue(i uint64) interface{} {
+	if i > math.MaxInt64 {
+		return i
+	}
+	return int64(i)
+}
+
+func convertColumnCase(column string, theCase string) string {
+	switch strings.ToLower(theCase) {
+	case "upper":
+		return strings.ToUpper(column)
+	case "lower":
+		return strings.ToLower(column)
+	case "camel":
+		parts := strings.Split(strings.ToLower(column), "_")
+		for i := 1; i < len(parts); i++ {
+			if len(parts[i]) > 0 {
+				parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+			}
+		}
+		return strings.Join(parts, "")
+	}
+	return column
+}
+
+func queryToTypedArray(q sqlQuerier, theCase string, sqlStatement string, sqlParams ...interface{}) ([]string, [][]interface{}, error) {
+	rows, err := q.Query(sqlStatement, sqlParams...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = convertColumnCase(column, theCase)
+	}
+
+	data := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		err = rows.Scan(valuePtrs...)
+		if err != nil {
+			return nil, nil, err
+		}
+		row := make([]interface{}, len(columns))
+		for i, v := range values {
+			row[i] = typedValue(v, columnTypes[i])
+		}
+		data = append(data, row)
+	}
+	return headers, data, rows.Err()
+}
+
+func queryToTypedMap(q sqlQuerier, theCase string, sqlStatement string, sqlParams ...interface{}) ([]map[string]interface{}, error) {
+	headers, data, err := queryToTypedArray(q, theCase, sqlStatement, sqlParams...)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]map[string]interface{}, 0, len(data))
+	for _, row := range data {
+		m := make(map[string]interface{}, len(headers))
+		for i, header := range headers {
+			m[header] = row[i]
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// legacyString formats a typed value the way the string form of a row has it.
+func legacyString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999999")
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func legacyStringMap(row map[string]interface{}) map[string]string {
+	ret := make(map[string]string, len(row))
+	for k, v := range row {
+		ret[k] = legacyString(v)
+	}
+	return ret
+}
+
+// mergeStringRow carries what a string hook changed in a row over to its typed
+// form. Values the hook left alone keep their types.
+func mergeStringRow(row map[string]interface{}, before map[string]string, after map[string]string) map[string]interface{} {
+	if row == nil {
+		row = map[string]interface{}{}
+	}
+	for k := range row {
+		if _, ok := after[k]; !ok {
+			delete(row, k)
+		}
+	}
+	for k, v := range after {
+		if b, ok := before[k]; !ok || b != v {
+			row[k] = v
+		}
+	}
+	return row
+}
+
+// The string After hooks run on typed rows too, before the typed hooks, so
+// that interceptors written for the string form keep working. They see the
+// rows as strings while context["typed"] is set.
+func runStringLoadHooks(tableId string, db *sql.DB, fields string, context map[string]interface{}, row map[string]interface{},
+	interceptors []DataInterceptor) {
+	if len(interceptors) == 0 {
+		return
+	}
+	context["typed"] = true
+	defer delete(context, "typed")
+	before := legacyStringMap(row)
+	after := legacyStringMap(row)
+	for _, interceptor := range interceptors {
+		interceptor.AfterLoad(tableId, db, fields, context, after)
+	}
+	mergeStringRow(row, before, after)
+}
+
+func runStringListMapHooks(tableId string, db *sql.DB, fields string, context map[string]interface{}, rows *[]map[string]interface{},
+	total int64, interceptors []DataInterceptor) {
+	if len(interceptors) == 0 {
+		return
+	}
+	context["typed"] = true
+	defer delete(context, "typed")
+	before := make([]map[string]string, len(*rows))
+	after := make([]map[string]string, len(*rows))
+	for i, row := range *rows {
+		before[i] = legacyStringMap(row)
+		after[i] = legacyStringMap(row)
+	}
+	for _, interceptor := range interceptors {
+		interceptor.AfterListMap(tableId, db, fields, context, &after, total)
+	}
+	ret := make([]map[string]interface{}, len(after))
+	for i, row := range after {
+		if i < len(before) {
+			ret[i] = mergeStringRow((*rows)[i], before[i], row)
+		} else {
+			ret[i] = mergeStringRow(nil, nil, row)
+		}
+	}
+	*rows = ret
+}
+
+func runStringListArrayHooks(tableId string, db *sql.DB, fields string, context map[string]interface{}, headers *[]string,
+	rows *[][]interface{}, total int64, interceptors []DataInterceptor) {
+	if len(interceptors) == 0 {
+		return
+	}
+	context["typed"] = true
+	defer delete(context, "typed")
+	beforeHeaders := append([]string{}, (*headers)...)
+	afterHeaders := append([]string{}, (*headers)...)
+	before := make([][]string, len(*rows))
+	after := make([][]string, len(*rows))
+	for i, row := range *rows {
+		before[i] = make([]string, len(row))
+		for j, v := range row {
+			before[i][j] = legacyString(v)
+		}
+		after[i] = append([]string{}, before[i]...)
+	}
+	for _, interceptor := range interceptors {
+		interceptor.AfterListArray(tableId, db, fields, context, &afterHeaders, &after, total)
+	}
+	index := map[string]int{}
+	for j, h := range beforeHeaders {
+		index[h] = j
+	}
+	ret := make([][]interface{}, len(after))
+	for i, row := range after {
+		ret[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			ret[i][j] = v
+			if j >= len(afterHeaders) || i >= len(before) {
+				continue
+			}
+			if k, ok := index[afterHeaders[j]]; ok && k < len(before[i]) && before[i][k] == v {
+				ret[i][j] = (*rows)[i][k]
+			}
+		}
+	}
+	*headers = afterHeaders
+	*rows = ret
+}
+
+// afterInterceptors lists the interceptors of a table in the order the After
+// hooks run, the table's own first.
+func afterInterceptors(tableId string) []DataInterceptor {
+	ret := []DataInterceptor{}
+	dataInterceptors, sortedKeys := Websql.Interceptors.GetDataInterceptors(tableId)
+	for _, k := range sortedKeys {
+		if dataInterceptor := dataInterceptors[k]; dataInterceptor != nil {
+			ret = append(ret, dataInterceptor)
+		}
+	}
+	globalDataInterceptors, globalSortedKeys := Websql.Interceptors.GetGlobalDataInterceptors()
+	for _, k := range globalSortedKeys {
+		ret = append(ret, globalDataInterceptors[k])
+	}
+	return ret
+}
@@ -0,0 +1,70 @@
+// typed_values
+package websql
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTypedValue(t *testing.T) {
+	cases := []struct {
+		s        string
+		dbType   string
+		expected interface{}
+	}{
+		{"-42", "BIGINT", int64(-42)},
+		{"42", "UNSIGNED BIGINT", int64(42)},
+		{"18446744073709551615", "UNSIGNED BIGINT", uint64(math.MaxUint64)},
+		{"1.5", "DOUBLE", float64(1.5)},
+		{"12345678901234567.89", "DECIMAL", json.Number("12345678901234567.89")},
+		{"0.10", "NUMERIC", json.Number("0.10")},
+		{"true", "BOOL", true},
+		{"2024-03-15 10:20:30", "DATETIME", time.Date(2024, 3, 15, 10, 20, 30, 0, time.UTC)},
+		{"abc", "VARCHAR", "abc"},
+		{"abc", "INT", "abc"},
+	}
+	for _, c := range cases {
+		v := parseTypedValue([]byte(c.s), c.dbType)
+		if !reflect.DeepEqual(v, c.expected) {
+			t.Errorf("Expected %#v for %s %s, got %#v", c.expected, c.dbType, c.s, v)
+		}
+	}
+	if v := typedValue(uint64(math.MaxInt64)+1, nil); v != uint64(math.MaxInt64)+1 {
+		t.Errorf("Expected a large unsigned value kept, got %#v", v)
+	}
+	if v := typedValue(uint64(7), nil); v != int64(7) {
+		t.Errorf("Expected a small unsigned value as int64, got %#v", v)
+	}
+	b, err := json.Marshal([]interface{}{json.Number("12345678901234567.89"), uint64(math.MaxUint64)})
+	if err != nil || string(b) != "[12345678901234567.89,18446744073709551615]" {
+		t.Error("Expected exact numbers in JSON, got", string(b), err)
+	}
+}
+
+func TestSqliteTypedValues(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, PRICE REAL, NAME TEXT, DATA BLOB, NOTE TEXT)",
+		"INSERT INTO ITEM VALUES (1, 2.5, 'a', X'0102', NULL)")
+
+	row, err := dbo.LoadTyped("ITEM", "1", "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"ID": int64(1), "PRICE": 2.5, "NAME": "a", "DATA": []byte{1, 2}, "NOTE": nil}
+	if !reflect.DeepEqual(row, expected) {
+		t.Fatalf("Expected %#v, got %#v", expected, row)
+	}
+
+	// The string form of a row is kept for legacy clients.
+	rows, _, err := dbo.ListMap("ITEM", "*", nil, "", "", 0, 10, testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["ID"] != "1" || rows[0]["PRICE"] != "2.5" || rows[0]["NOTE"] != "" {
+		t.Fatal("Expected the row as strings:", rows)
+	}
+}
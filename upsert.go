@@ -0,0 +1,414 @@
+// upsert
+package websql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type upsertOptions struct {
+	// Conflict holds the column names the conflicting row is matched by.
+	Conflict []string
+	// Update holds the column names to update on conflict, nil for every
+	// column of the row except the conflict and key columns.
+	Update  []string
+	Columns map[string]string
+	Key     *tableKey
+	// Guard is the predicate, with its arguments, the conflicting row must
+	// meet to be updated: not soft deleted and within the write policy.
+	Guard     string
+	GuardArgs []interface{}
+}
+
+// Columns stamped by BeforeCreate that an upsert keeps from the original row.
+var upsertKeepColumns = map[string]bool{
+	"CREATED_AT":   true,
+	"CREATED_BY":   true,
+	"CREATED_FROM": true,
+}
+
+// parseUpsertOptions reads the conflict and update columns of an upsert from
+// context["conflict"] and context["update"], both comma separated. The
+// conflict columns default to the table key.
+func (this *MySqlDataOperator) parseUpsertOptions(q sqlQuerier, tableId string, key *tableKey, context map[string]interface{}) (*upsertOptions, error) {
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return nil, err
+	}
+	d := this.GetDialect()
+	ret := &upsertOptions{
+		Conflict: []string{},
+		Columns:  columns,
+		Key:      key,
+	}
+	conflict, _ := context["conflict"].(string)
+	if strings.TrimSpace(conflict) == "" {
+		if len(key.Columns) == 0 {
+			return nil, newParamError("conflict", "", "Conflict columns are required, the table has no key.")
+		}
+		conflict = strings.Join(key.Columns, ",")
+	}
+	for _, c := range strings.Split(conflict, ",") {
+		name, _, err := resolveColumn("conflict", c, tableId, columns, d)
+		if err != nil {
+			return nil, err
+		}
+		ret.Conflict = append(ret.Conflict, name)
+	}
+	if !d.UpsertConflictTarget() && !ret.uniqueKey(ret.Conflict) {
+		// The upsert would only fire on some other unique key.
+		return nil, newParamError("conflict", conflict, "Conflict columns are not a unique key: "+conflict)
+	}
+	// The conflicting row must not be deleted and must meet the write policy.
+	guard := []string{}
+	if deleted := this.softDeleteFilter(tableId, "", context); deleted != "" {
+		guard = append(guard, deleted)
+	}
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		return nil, err
+	}
+	if policy != "" {
+		guard = append(guard, policy)
+		ret.GuardArgs = policyArgs
+	}
+	ret.Guard = strings.Join(guard, " AND ")
+	if update, ok := context["update"].(string); ok && strings.TrimSpace(update) != "" {
+		ret.Update = []string{}
+		for _, u := range strings.Split(update, ",") {
+			name, _, err := resolveColumn("update", u, tableId, columns, d)
+			if err != nil {
+				return nil, err
+			}
+			ret.Update = append(ret.Update, name)
+		}
+	}
+	return ret, nil
+}
+
+// uniqueKey tells if columns are the columns of a unique index of the table.
+func (this *upsertOptions) uniqueKey(columns []string) bool {
+	for _, unique := range this.Key.Unique {
+		if sameColumnSet(unique, columns) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameColumnSet(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if strings.EqualFold(x, y) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConflictKey makes sure a row can only conflict on the conflict columns,
+// where the upsert fires on any unique key. A unique key the row has no value
+// for, or a new key made up for it, cannot conflict.
+func (this *upsertOptions) checkConflictKey(data1 map[string]interface{}, newKey bool) error {
+	for _, unique := range this.Key.Unique {
+		if sameColumnSet(unique, this.Conflict) || (newKey && sameColumnSet(unique, this.Key.Columns)) {
+			continue
+		}
+		fires := true
+		for _, c := range unique {
+			if v, ok := data1[strings.ToUpper(c)]; !ok || v == nil {
+				fires = false
+			}
+		}
+		if fires {
+			return newParamError("conflict", strings.Join(this.Conflict, ","), "Row may conflict on another unique key: "+strings.Join(unique, ","))
+		}
+	}
+	return nil
+}
+
+// updateColumns returns the columns of data1 to update on conflict. The
+// UPDATED_ audit columns are always included when present.
+func (this *upsertOptions) updateColumns(data1 map[string]interface{}) []string {
+	ret := []string{}
+	isConflict := func(name string) bool {
+		for _, c := range append(this.Conflict, this.Key.Columns...) {
+			if strings.EqualFold(c, name) {
+				return true
+			}
+		}
+		return false
+	}
+	if this.Update != nil {
+		update := map[string]bool{}
+		for _, name := range this.Update {
+			if _, ok := data1[strings.ToUpper(name)]; ok && !isConflict(name) && !update[name] {
+				update[name] = true
+				ret = append(ret, name)
+			}
+		}
+		for _, k := range []string{"UPDATED_AT", "UPDATED_BY", "UPDATED_FROM"} {
+			if name, ok := this.Columns[k]; ok && data1[k] != nil && !update[name] {
+				ret = append(ret, name)
+			}
+		}
+		return ret
+	}
+	for k := range data1 {
+		name, ok := this.Columns[strings.ToUpper(k)]
+		if ok && !isConflict(name) && !upsertKeepColumns[strings.ToUpper(k)] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// upsertRow inserts data1, or updates the row it conflicts with. It returns
+// the key of the row and whether it was created, updated or left unchanged,
+// as the database reports it for this very statement. generated names the
+// auto increment column the database fills, if any, newKey tells that the
+// key of data1 was made up for a new row.
+func (this *MySqlDataOperator) upsertRow(q sqlQuerier, e sqlExecer, tableId string, fields string, qms string, values []interface{},
+	data1 map[string]interface{}, key *tableKey, generated string, newKey bool, upsert *upsertOptions) (map[string]interface{}, error) {
+	d := this.GetDialect()
+	if db, ok := e.(*sql.DB); ok && upsert.Guard != "" && !d.UpsertConflictTarget() {
+		// The guard is checked before the upsert, both see the same row.
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		ret, err := this.upsertRow(tx, tx, tableId, fields, qms, values, data1, key, generated, newKey, upsert)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return ret, tx.Commit()
+	}
+	conflict := []string{}
+	conflictWhere := []string{}
+	conflictArgs := []interface{}{}
+	for _, name := range upsert.Conflict {
+		isKey := false
+		for _, c := range key.Columns {
+			isKey = isKey || strings.EqualFold(c, name)
+		}
+		if newKey && isKey {
+			// A row with a new key cannot conflict on it.
+			return this.insertRow(q, e, tableId, fields, qms, values, data1, key, generated)
+		}
+		if v, ok := data1[strings.ToUpper(name)]; !ok || v == nil {
+			return nil, newParamError("conflict", name, "Missing value of conflict column: "+name)
+		}
+		conflict = append(conflict, d.QuoteIdentifier(name))
+		conflictWhere = append(conflictWhere, d.QuoteIdentifier(name)+"=?")
+		conflictArgs = append(conflictArgs, data1[strings.ToUpper(name)])
+	}
+	where := strings.Join(conflictWhere, " AND ")
+	if !d.UpsertConflictTarget() {
+		err := upsert.checkConflictKey(data1, newKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	update := upsert.updateColumns(data1)
+	quotedUpdate := []string{}
+	for _, u := range update {
+		quotedUpdate = append(quotedUpdate, d.QuoteIdentifier(u))
+	}
+	quotedKey := []string{}
+	for _, c := range key.Columns {
+		quotedKey = append(quotedKey, d.QuoteIdentifier(c))
+	}
+
+	result := "unchanged"
+	var id interface{}
+	if !d.UpsertConflictTarget() {
+		quotedGenerated := ""
+		if generated != "" {
+			quotedGenerated = d.QuoteIdentifier(generated)
+		}
+		if upsert.Guard != "" && len(update) > 0 {
+			_, rows, err := queryToTypedArray(q, "", d.Rebind(fmt.Sprint("SELECT ", upsert.Guard, " FROM ", tableId, " WHERE ", where, d.LockClause())),
+				append(append([]interface{}{}, upsert.GuardArgs...), conflictArgs...)...)
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) > 0 && !metaBool(rows[0][0]) {
+				return nil, upsertGuardError(conflictArgs)
+			}
+		}
+		res, err := e.Exec(d.Rebind(d.UpsertQuery(tableId, fields, qms, conflict, quotedUpdate, quotedGenerated, "")), values...)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		switch affected {
+		case 1:
+			result = "created"
+		case 2:
+			result = "updated"
+		}
+		if generated != "" {
+			newId, err := res.LastInsertId()
+			if err != nil {
+				return nil, err
+			}
+			data1[strings.ToUpper(generated)] = newId
+		}
+	} else if returning := d.UpsertReturning(quotedKey); returning != "" {
+		args := values
+		if len(update) > 0 {
+			args = append(append([]interface{}{}, values...), upsert.GuardArgs...)
+		}
+		_, rows, err := queryToTypedArray(q, "", d.Rebind(d.UpsertQuery(tableId, fields, qms, conflict, quotedUpdate, "", upsert.Guard)+returning), args...)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 && len(update) > 0 {
+			// The conflicting row did not meet the guard.
+			return nil, upsertGuardError(conflictArgs)
+		}
+		if len(rows) > 0 {
+			result = "updated"
+			if inserted, _ := rows[0][0].(bool); inserted {
+				result = "created"
+			}
+			if len(rows[0]) > 1 {
+				id = rows[0][1]
+				if len(rows[0]) > 2 {
+					id = joinKey(rows[0][1:])
+				}
+			}
+		}
+	} else {
+		// Insert unless there is a conflict, then update the conflicting row.
+		res, err := e.Exec(d.Rebind(d.UpsertQuery(tableId, fields, qms, conflict, nil, "", "")), values...)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if affected > 0 {
+			result = "created"
+			if generated != "" {
+				newId, err := res.LastInsertId()
+				if err != nil {
+					return nil, err
+				}
+				data1[strings.ToUpper(generated)] = newId
+			}
+		} else if len(update) > 0 {
+			sets := []string{}
+			args := []interface{}{}
+			for i, u := range update {
+				sets = append(sets, quotedUpdate[i]+"=?")
+				args = append(args, data1[strings.ToUpper(u)])
+			}
+			updateWhere := where
+			args = append(args, conflictArgs...)
+			if upsert.Guard != "" {
+				updateWhere = fmt.Sprint(where, " AND ", upsert.Guard)
+				args = append(args, upsert.GuardArgs...)
+			}
+			res, err = e.Exec(d.Rebind(fmt.Sprint("UPDATE ", tableId, " SET ", strings.Join(sets, ","), " WHERE ", updateWhere)), args...)
+			if err != nil {
+				return nil, err
+			}
+			affected, err = res.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if affected == 0 {
+				// The conflicting row did not meet the guard, or was removed
+				// in the meantime.
+				return nil, upsertGuardError(conflictArgs)
+			}
+			result = "updated"
+		}
+	}
+
+	// The key of data1 is the key of the row if it was inserted, or if the
+	// conflict was on the key. MySQL reports the generated key of an updated
+	// row through LAST_INSERT_ID too.
+	if id == nil && (result == "created" || (generated != "" && !d.UpsertConflictTarget()) || (!newKey && upsert.coversKey())) {
+		id = key.id(data1)
+	}
+	if id == nil && len(key.Columns) > 0 {
+		// Read the key of the conflicting row by its conflict columns.
+		_, rows, err := queryToTypedArray(q, "", d.Rebind(fmt.Sprint("SELECT ", strings.Join(quotedKey, ","), " FROM ", tableId, " WHERE ", where)), conflictArgs...)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) > 0 {
+			id = rows[0][0]
+			if len(rows[0]) > 1 {
+				id = joinKey(rows[0])
+			}
+		}
+	}
+	return map[string]interface{}{
+		"id":     id,
+		"result": result,
+	}, nil
+}
+
+// upsertGuardError reports a conflicting row that may not be updated.
+func upsertGuardError(conflictValues []interface{}) error {
+	return &RequestError{
+		Status:  http.StatusConflict,
+		Code:    "conflict_not_writable",
+		Value:   joinKey(conflictValues),
+		Message: "Conflicting row cannot be updated: " + joinKey(conflictValues),
+	}
+}
+
+// coversKey tells if the conflict columns include the key, so that the key of
+// a conflicting row is the one given.
+func (this *upsertOptions) coversKey() bool {
+	for _, c := range this.Key.Columns {
+		found := false
+		for _, name := range this.Conflict {
+			found = found || strings.EqualFold(c, name)
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(this.Key.Columns) > 0
+}
+
+// insertRow inserts data1 with no upsert, for a row that cannot conflict.
+func (this *MySqlDataOperator) insertRow(q sqlQuerier, e sqlExecer, tableId string, fields string, qms string, values []interface{},
+	data1 map[string]interface{}, key *tableKey, generated string) (map[string]interface{}, error) {
+	newId, err := this.execInsert(q, e, fmt.Sprint("INSERT INTO ", tableId, " (", fields, ") VALUES (", qms, ")"), values, generated)
+	if err != nil {
+		return nil, err
+	}
+	if generated != "" {
+		data1[strings.ToUpper(generated)] = newId
+	}
+	return map[string]interface{}{
+		"id":     key.id(data1),
+		"result": "created",
+	}, nil
+}
@@ -0,0 +1,85 @@
+// upsert
+package websql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func upsertContext(t *testing.T, app *App, conflict string, update string) map[string]interface{} {
+	context := testContext(t, app, nil)
+	context["mode"] = "upsert"
+	context["conflict"] = conflict
+	context["update"] = update
+	return context
+}
+
+func TestSqliteUpsert(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY AUTOINCREMENT, CODE TEXT NOT NULL UNIQUE, "+
+		"NAME TEXT, QTY INTEGER, "+testAuditColumns+")")
+
+	results, err := dbo.Create("ITEM", []map[string]interface{}{{"CODE": "a", "NAME": "x", "QTY": 1}},
+		upsertContext(t, app, "CODE", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := results[0].(map[string]interface{}); result["result"] != "created" || result["id"] != int64(1) {
+		t.Fatal("Expected the row created:", result)
+	}
+
+	// Only the update columns of a conflicting row are written.
+	results, err = dbo.Create("ITEM", []map[string]interface{}{
+		{"CODE": "a", "NAME": "y", "QTY": 2},
+		{"CODE": "b", "NAME": "z", "QTY": 3},
+	}, upsertContext(t, app, "CODE", "NAME"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, created := results[0].(map[string]interface{}), results[1].(map[string]interface{})
+	if !reflect.DeepEqual(updated, map[string]interface{}{"id": int64(1), "result": "updated"}) ||
+		created["result"] != "created" || created["id"] == nil {
+		t.Fatal("Expected one row updated and one created:", results)
+	}
+	row, err := dbo.LoadTyped("ITEM", "1", "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["NAME"] != "y" || row["QTY"] != int64(1) {
+		t.Fatal("Expected the name updated and the quantity kept:", row)
+	}
+
+	// The conflict columns default to the key.
+	results, err = dbo.Create("ITEM", []map[string]interface{}{{"ID": created["id"], "CODE": "b", "QTY": 4}},
+		upsertContext(t, app, "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := results[0].(map[string]interface{}); result["result"] != "updated" {
+		t.Fatal("Expected the row updated by its key:", result)
+	}
+	row, err = dbo.LoadTyped("ITEM", keyValueString(created["id"]), "*", testContext(t, app, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["NAME"] != "z" || row["QTY"] != int64(4) {
+		t.Fatal("Expected the columns given updated:", row)
+	}
+}
+
+func TestSqliteUpsertParams(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t, "CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, CODE TEXT UNIQUE, NAME TEXT, "+testAuditColumns+")")
+
+	_, err := dbo.Create("ITEM", []map[string]interface{}{{"CODE": "a"}}, upsertContext(t, app, "NOPE", ""))
+	requireStatus(t, err, 400)
+	_, err = dbo.Create("ITEM", []map[string]interface{}{{"CODE": "a"}}, upsertContext(t, app, "CODE", "NOPE"))
+	requireStatus(t, err, 400)
+	_, err = dbo.Create("ITEM", []map[string]interface{}{{"NAME": "a"}}, upsertContext(t, app, "CODE", ""))
+	if requireStatus(t, err, 400).Param != "conflict" {
+		t.Fatal("Expected the missing conflict value reported:", err)
+	}
+	if ids := listIds(t, dbo, "ITEM", nil, "", testContext(t, app, nil)); len(ids) != 0 {
+		t.Fatal("Expected nothing written:", ids)
+	}
+}
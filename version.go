@@ -0,0 +1,212 @@
+// version
+package websql
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A row's version is read from the first of these columns the table has. A
+// VERSION column is incremented on every update, UPDATED_AT is stamped by
+// the token interceptor. A VERSION column is preferred, UPDATED_AT only has
+// the precision of its column, so an update moves it at least a second on.
+var versionColumns = []string{"VERSION", "UPDATED_AT"}
+
+// versionColumn returns the version column of a table, "" if it has none.
+func (this *MySqlDataOperator) versionColumn(q sqlQuerier, tableId string) (string, error) {
+	columns, err := this.tableColumns(q, tableId)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range versionColumns {
+		if name, ok := columns[c]; ok {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// canonicalVersion formats a version value the same way whether it comes from
+// the database or from a client, e.g. a timestamp in any zone.
+func canonicalVersion(v interface{}) string {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return metaString(v)
+}
+
+func versionETag(version string) string {
+	sum := sha1.Sum([]byte(version))
+	return `"` + hex.EncodeToString(sum[:10]) + `"`
+}
+
+// sameVersion tells if a client's expected version, either the ETag or the
+// value of the version column, matches the current version of a row.
+func sameVersion(expected interface{}, current string) bool {
+	if s, ok := expected.(string); ok {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "W/")
+		if s == "*" || s == versionETag(current) || `"`+s+`"` == versionETag(current) {
+			return true
+		}
+	}
+	return canonicalVersion(expected) == current
+}
+
+// rowVersion reads the version of a row, both canonical and as the database
+// returns it. In a transaction the row stays locked until the transaction
+// ends, where the database supports it.
+func (this *MySqlDataOperator) rowVersion(q sqlQuerier, tableId string, column string, key *tableKey, keyValues []interface{},
+	lock bool) (string, interface{}, bool, error) {
+	d := this.GetDialect()
+	query := fmt.Sprint("SELECT ", d.QuoteIdentifier(column), " FROM ", tableId, " WHERE ", key.where(d))
+	if lock {
+		query += d.LockClause()
+	}
+	_, rows, err := queryToTypedArray(q, "", d.Rebind(query), keyValues...)
+	if err != nil {
+		fmt.Println(err)
+		return "", nil, false, err
+	}
+	if len(rows) == 0 {
+		return "", nil, false, nil
+	}
+	return canonicalVersion(rows[0][0]), rows[0][0], true, nil
+}
+
+// rowETag returns the ETag of a row, "" if the table has no version column or
+// the row does not exist.
+func (this *MySqlDataOperator) rowETag(q sqlQuerier, tableId string, key *tableKey, keyValues []interface{}) (string, error) {
+	column, err := this.versionColumn(q, tableId)
+	if err != nil || column == "" {
+		return "", err
+	}
+	version, _, found, err := this.rowVersion(q, tableId, column, key, keyValues, false)
+	if err != nil || !found {
+		return "", err
+	}
+	return versionETag(version), nil
+}
+
+// expectedVersion returns the version the i-th row or id of a write is
+// expected to have, from context["if_match"], or nil if there is none.
+func expectedVersion(context map[string]interface{}, i int) interface{} {
+	if ifMatch, ok := context["if_match"].([]interface{}); ok && i < len(ifMatch) {
+		return ifMatch[i]
+	}
+	return nil
+}
+
+// checkVersion makes sure a row still has the version a client expects. It
+// returns the version as the database has it, for the write to match on, or
+// nil if nothing is expected. If the row has another version, the error
+// carries the current row, so that the client can merge.
+func (this *MySqlDataOperator) checkVersion(q sqlQuerier, tableId string, key *tableKey, keyValues []interface{}, expected interface{},
+	context map[string]interface{}) (interface{}, error) {
+	if expected == nil {
+		return nil, nil
+	}
+	column, err := this.versionColumn(q, tableId)
+	if err != nil {
+		return nil, err
+	}
+	if column == "" {
+		return nil, &RequestError{
+			Status:  http.StatusBadRequest,
+			Code:    "no_version",
+			Value:   unquoteIdentifier(tableId),
+			Message: "Table has no version column: " + unquoteIdentifier(tableId),
+		}
+	}
+	version, current, found, err := this.rowVersion(q, tableId, column, key, keyValues, ContextTx(context) != nil)
+	if err != nil {
+		return nil, err
+	}
+	d := this.GetDialect()
+	// A row the user may not write counts as removed, it is not returned.
+	policy, policyArgs, err := policyPredicate(tableId, true, context)
+	if err != nil {
+		return nil, err
+	}
+	if found && policy != "" {
+		_, rows, err := queryToTypedArray(q, "", d.Rebind(fmt.Sprint("SELECT 1 FROM ", tableId, " WHERE ", key.where(d), " AND ", policy)),
+			append(append([]interface{}{}, keyValues...), policyArgs...)...)
+		if err != nil {
+			fmt.Println(err)
+			return nil, err
+		}
+		found = len(rows) > 0
+	}
+	if found && sameVersion(expected, version) {
+		return current, nil
+	}
+	return nil, this.versionMismatch(q, tableId, key, keyValues, found, context)
+}
+
+// versionMismatch returns the error of a write whose row was changed or
+// removed, with the current row if there is one.
+func (this *MySqlDataOperator) versionMismatch(q sqlQuerier, tableId string, key *tableKey, keyValues []interface{}, found bool,
+	context map[string]interface{}) error {
+	var current interface{}
+	if found {
+		d := this.GetDialect()
+		c, _ := context["case"].(string)
+		rows, err := queryToTypedMap(q, c, d.Rebind(fmt.Sprint("SELECT * FROM ", tableId, " WHERE ", key.where(d))), keyValues...)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if len(rows) > 0 {
+			if masker := newColumnMasker(tableId, context); masker != nil {
+				masker.maskMap(rows[0])
+			}
+			current = rows[0]
+		}
+	}
+	return &RequestError{
+		Status:  http.StatusPreconditionFailed,
+		Code:    "version_mismatch",
+		Value:   joinKey(keyValues),
+		Message: "Record was changed or removed: " + joinKey(keyValues),
+		Data:    current,
+	}
+}
+
+// versionWhere returns the predicate a write adds so that it only changes the
+// row if it still has the checked version, "" if there is none.
+func (this *MySqlDataOperator) versionWhere(column string, current interface{}) string {
+	if column == "" || current == nil {
+		return ""
+	}
+	return " AND " + this.GetDialect().QuoteIdentifier(column) + "=?"
+}
+
+// nextUpdatedAt returns the UPDATED_AT to stamp over current, at least a
+// second later, so that the version changes even if the column only keeps
+// seconds.
+func nextUpdatedAt(stamp interface{}, current interface{}) interface{} {
+	t, ok := stamp.(time.Time)
+	if !ok {
+		return stamp
+	}
+	c, ok := current.(time.Time)
+	if !ok {
+		return stamp
+	}
+	if t.Truncate(time.Second).After(c) {
+		return stamp
+	}
+	return c.Truncate(time.Second).Add(time.Second).UTC()
+}
@@ -0,0 +1,110 @@
+// version
+package websql
+
+import (
+	"testing"
+)
+
+func TestSqliteVersionMismatch(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, VERSION INTEGER NOT NULL DEFAULT 1, "+testAuditColumns+")",
+		"INSERT INTO ITEM (ID, NAME) VALUES (1, 'a')")
+
+	context := testContext(t, app, nil)
+	context["if_match"] = []interface{}{float64(2)}
+	_, err := dbo.Update("ITEM", []map[string]interface{}{{"ID": "1", "NAME": "b"}}, context)
+	requireStatus(t, err, 412)
+
+	context = testContext(t, app, nil)
+	context["if_match"] = []interface{}{float64(1)}
+	_, err = dbo.Update("ITEM", []map[string]interface{}{{"ID": "1", "NAME": "b"}}, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The version moved on, the same If-Match no longer applies.
+	context = testContext(t, app, nil)
+	context["if_match"] = []interface{}{float64(1)}
+	_, err = dbo.Delete("ITEM", []string{"1"}, context)
+	requireStatus(t, err, 412)
+}
+
+func TestSqliteETag(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, VERSION INTEGER NOT NULL DEFAULT 1, "+testAuditColumns+")",
+		"INSERT INTO ITEM (ID, NAME) VALUES (1, 'a')")
+
+	context := testContext(t, app, nil)
+	if _, err := dbo.LoadTyped("ITEM", "1", "*", context); err != nil {
+		t.Fatal(err)
+	}
+	etag, _ := context["etag"].(string)
+	if etag == "" {
+		t.Fatal("Expected the ETag of the row.")
+	}
+
+	// The ETag itself is accepted as If-Match.
+	context = testContext(t, app, nil)
+	context["if_match"] = []interface{}{etag}
+	if _, err := dbo.Update("ITEM", []map[string]interface{}{{"ID": "1", "NAME": "b"}}, context); err != nil {
+		t.Fatal(err)
+	}
+	if context["etag"] == "" || context["etag"] == etag {
+		t.Fatal("Expected the ETag changed by the update:", context["etag"])
+	}
+	context = testContext(t, app, nil)
+	context["if_match"] = []interface{}{etag}
+	_, err := dbo.Update("ITEM", []map[string]interface{}{{"ID": "1", "NAME": "c"}}, context)
+	requireStatus(t, err, 412)
+}
+
+// A write that loads the old row first reports a missing row, in a
+// transaction or not.
+func TestSqliteLoadMissingRow(t *testing.T) {
+	app := newTestApp(t, &App{Id: "app"})
+	dbo := newTestDbo(t,
+		"CREATE TABLE ITEM (ID INTEGER PRIMARY KEY, NAME TEXT, "+testAuditColumns+")",
+		"INSERT INTO ITEM (ID, NAME) VALUES (1, 'a')")
+	db, err := dbo.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inTx := range []bool{false, true} {
+		context := testContext(t, app, nil)
+		context["load"] = true
+		if inTx {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			context["tx"] = tx
+		}
+		_, err := dbo.Update("ITEM", []map[string]interface{}{{"ID": "2", "NAME": "b"}}, context)
+		requireStatus(t, err, 404)
+
+		context = testContext(t, app, nil)
+		context["load"] = true
+		if inTx {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			context["tx"] = tx
+		}
+		_, err = dbo.Delete("ITEM", []string{"2"}, context)
+		requireStatus(t, err, 404)
+	}
+
+	context := testContext(t, app, nil)
+	context["load"] = true
+	if _, err := dbo.Update("ITEM", []map[string]interface{}{{"ID": "1", "NAME": "b"}}, context); err != nil {
+		t.Fatal(err)
+	}
+	if old, _ := context["old_data"].(map[string]string); old["NAME"] != "a" {
+		t.Fatal("Expected the old row loaded:", context["old_data"])
+	}
+}
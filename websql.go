@@ -2,6 +2,7 @@ package websql
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -15,6 +16,8 @@ import (
 	"github.com/elgs/cron"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/websocket"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/satori/go.uuid"
 	"github.com/urfave/cli"
 )
@@ -32,6 +35,7 @@ var Websql *WebSQL = &WebSQL{
 	handlers: &Handlers{
 		handlerRegistry: make(map[string]func(w http.ResponseWriter, r *http.Request)),
 		DboRegistry:     make(map[string]DataOperator),
+		dboFingerprints: make(map[string]string),
 	},
 	service: &CliService{
 		EnableHttp: true,
@@ -41,6 +45,11 @@ var Websql *WebSQL = &WebSQL{
 	jobStatus:  make(map[string]int),
 	masterData: &MasterData{},
 	Sched:      cron.New(),
+	TableKeys:  make(map[string][]string),
+	Relations:  make(map[string][]*Relation),
+
+	SoftDeleteTables: make(map[string]*SoftDelete),
+	ColumnMasks:      make(map[string]map[string]string),
 }
 
 type WebSQL struct {
@@ -57,6 +66,11 @@ type WebSQL struct {
 	Interceptors   *Interceptors
 	handlers       *Handlers
 	getDbo         func(id string) (DataOperator, error)
+	TableKeys      map[string][]string
+	Relations      map[string][]*Relation
+
+	SoftDeleteTables map[string]*SoftDelete
+	ColumnMasks      map[string]map[string]string
 }
 
 //var slaveConn *websocket.Conn
@@ -165,12 +179,7 @@ func Run(appName string, appVersion string) {
 												log.Println(err)
 											}
 											log.Println(c.RemoteAddr(), "dropped.")
-											for k, v := range Websql.wsConns {
-												if v == c {
-													delete(Websql.wsConns, k)
-													break
-												}
-											}
+											removeWsConn(c)
 											break
 										}
 										// Master to process command from client web socket channels.
@@ -222,6 +231,7 @@ func Run(appName string, appVersion string) {
 						})
 
 						Websql.handlers.RegisterHandler("/api", RestFunc)
+						Websql.handlers.RegisterHandler("/.well-known/jwks.json", JwksFunc)
 
 						// serve
 						serve(Websql.service)
@@ -319,13 +329,29 @@ func Run(appName string, appVersion string) {
 						},
 						cli.StringFlag{
 							Name:  "host, H",
-							Usage: "hostname of the data node",
+							Usage: "hostname of the data node, or the database directory of a sqlite3 data node",
 						},
 						cli.IntFlag{
 							Name:  "port, P",
 							Value: 3306,
 							Usage: "port number of the data node",
 						},
+						cli.StringFlag{
+							Name:  "type, T",
+							Usage: "database type of the data node, mysql, postgres or sqlite3. mysql if empty",
+						},
+						cli.IntFlag{
+							Name:  "max_open, O",
+							Usage: "maximum number of open connections per app, unlimited if 0",
+						},
+						cli.IntFlag{
+							Name:  "max_idle, I",
+							Usage: "maximum number of idle connections per app, driver default if 0",
+						},
+						cli.IntFlag{
+							Name:  "max_lifetime, L",
+							Usage: "maximum lifetime of a connection in seconds, unlimited if 0",
+						},
 						cli.StringFlag{
 							Name:  "user, u",
 							Usage: "username of the data node",
@@ -348,12 +374,16 @@ func Run(appName string, appVersion string) {
 						Websql.service.LoadSecrets(c)
 						node := c.String("node")
 						dataNode := &DataNode{
-							Name:     c.String("name"),
-							Host:     c.String("host"),
-							Port:     c.Int("port"),
-							Username: c.String("user"),
-							Password: c.String("pass"),
-							Note:     c.String("note"),
+							Name:            c.String("name"),
+							Host:            c.String("host"),
+							Port:            c.Int("port"),
+							Type:            c.String("type"),
+							MaxOpenConns:    c.Int("max_open"),
+							MaxIdleConns:    c.Int("max_idle"),
+							ConnMaxLifetime: c.Int("max_lifetime"),
+							Username:        c.String("user"),
+							Password:        c.String("pass"),
+							Note:            c.String("note"),
 						}
 						dataNodeJSONBytes, err := json.Marshal(dataNode)
 						if err != nil {
@@ -395,13 +425,29 @@ func Run(appName string, appVersion string) {
 						},
 						cli.StringFlag{
 							Name:  "host, H",
-							Usage: "hostname of the data node",
+							Usage: "hostname of the data node, or the database directory of a sqlite3 data node",
 						},
 						cli.IntFlag{
 							Name:  "port, P",
 							Value: 3306,
 							Usage: "port number of the data node",
 						},
+						cli.StringFlag{
+							Name:  "type, T",
+							Usage: "database type of the data node, mysql, postgres or sqlite3. mysql if empty",
+						},
+						cli.IntFlag{
+							Name:  "max_open, O",
+							Usage: "maximum number of open connections per app, unlimited if 0",
+						},
+						cli.IntFlag{
+							Name:  "max_idle, I",
+							Usage: "maximum number of idle connections per app, driver default if 0",
+						},
+						cli.IntFlag{
+							Name:  "max_lifetime, L",
+							Usage: "maximum lifetime of a connection in seconds, unlimited if 0",
+						},
 						cli.StringFlag{
 							Name:  "user, u",
 							Usage: "username of the data node",
@@ -424,13 +470,17 @@ func Run(appName string, appVersion string) {
 						Websql.service.LoadSecrets(c)
 						node := c.String("node")
 						dataNode := &DataNode{
-							Id:       c.String("id"),
-							Name:     c.String("name"),
-							Host:     c.String("host"),
-							Port:     c.Int("port"),
-							Username: c.String("user"),
-							Password: c.String("pass"),
-							Note:     c.String("note"),
+							Id:              c.String("id"),
+							Name:            c.String("name"),
+							Host:            c.String("host"),
+							Port:            c.Int("port"),
+							Type:            c.String("type"),
+							MaxOpenConns:    c.Int("max_open"),
+							MaxIdleConns:    c.Int("max_idle"),
+							ConnMaxLifetime: c.Int("max_lifetime"),
+							Username:        c.String("user"),
+							Password:        c.String("pass"),
+							Note:            c.String("note"),
 						}
 						if !c.IsSet("name") {
 							dataNode.Name = "__not_set__"
@@ -441,6 +491,18 @@ func Run(appName string, appVersion string) {
 						if !c.IsSet("port") {
 							dataNode.Port = -1
 						}
+						if !c.IsSet("type") {
+							dataNode.Type = "__not_set__"
+						}
+						if !c.IsSet("max_open") {
+							dataNode.MaxOpenConns = -1
+						}
+						if !c.IsSet("max_idle") {
+							dataNode.MaxIdleConns = -1
+						}
+						if !c.IsSet("max_lifetime") {
+							dataNode.ConnMaxLifetime = -1
+						}
 						if !c.IsSet("user") {
 							dataNode.Username = "__not_set__"
 						}
@@ -1581,6 +1643,790 @@ func Run(appName string, appVersion string) {
 				},
 			},
 		},
+		{
+			Name:    "role",
+			Aliases: []string{"r"},
+			Usage:   "role commands",
+			Subcommands: []cli.Command{
+				{
+					Name:  "add",
+					Usage: "add a new role",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "name of the role",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "note, t",
+							Usage: "note for the role",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						appId := c.String("app")
+						id := appId + strings.Replace(uuid.NewV4().String(), "-", "", -1)
+						role := &Role{
+							Id:    id,
+							Name:  c.String("name"),
+							AppId: appId,
+							Note:  c.String("note"),
+						}
+						roleJSONBytes, err := json.Marshal(role)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliRoleAddCommand := &Command{
+							Type: "CLI_ROLE_ADD",
+							Data: string(roleJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliRoleAddCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "update",
+					Usage: "update an existing role",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "name of the role",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the role",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "note, t",
+							Usage: "a note for the role",
+						},
+						cli.StringFlag{
+							Name:  "status, s",
+							Usage: "status of the role, disabled roles grant nothing",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						role := &Role{
+							Id:     c.String("id"),
+							Name:   c.String("name"),
+							AppId:  c.String("app"),
+							Note:   c.String("note"),
+							Status: c.String("status"),
+						}
+						if !c.IsSet("name") {
+							role.Name = "__not_set__"
+						}
+						if !c.IsSet("note") {
+							role.Note = "__not_set__"
+						}
+						if !c.IsSet("status") {
+							role.Status = "__not_set__"
+						}
+						roleJSONBytes, err := json.Marshal(role)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliRoleUpdateCommand := &Command{
+							Type: "CLI_ROLE_UPDATE",
+							Data: string(roleJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliRoleUpdateCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "remove an existing role",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the role",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						role := &Role{
+							Id:    c.String("id"),
+							AppId: c.String("app"),
+						}
+						roleJSONBytes, err := json.Marshal(role)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliRoleRemoveCommand := &Command{
+							Type: "CLI_ROLE_REMOVE",
+							Data: string(roleJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliRoleRemoveCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "grant",
+					Usage: "grant a role operations on tables and queries, an empty mode revokes the target",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the role",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "mode, o",
+							Usage: "operations to grant, e.g. list,load or *",
+						},
+						cli.StringFlag{
+							Name:  "target, g",
+							Usage: "tables and queries to grant on, e.g. orders,order_report or *",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						role := &Role{
+							Id:    c.String("id"),
+							AppId: c.String("app"),
+							Permissions: []*Permission{
+								&Permission{
+									Mode:   c.String("mode"),
+									Target: c.String("target"),
+								},
+							},
+						}
+						roleJSONBytes, err := json.Marshal(role)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliRoleGrantCommand := &Command{
+							Type: "CLI_ROLE_GRANT",
+							Data: string(roleJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliRoleGrantCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "columns",
+					Usage: "limit the columns a role can read and write, no allow, deny or mask removes the limit",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the role",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "target, g",
+							Usage: "tables and queries the columns are of, e.g. users or *",
+						},
+						cli.StringFlag{
+							Name:  "allow, l",
+							Usage: "the only columns the role can see, comma separated",
+						},
+						cli.StringFlag{
+							Name:  "deny, d",
+							Usage: "columns the role cannot see, comma separated",
+						},
+						cli.StringFlag{
+							Name:  "mask, m",
+							Usage: "masked columns, e.g. PHONE:partial,SSN:hash, masks are hide, null, partial and hash",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						mask := map[string]string{}
+						for _, m := range strings.Split(c.String("mask"), ",") {
+							if strings.TrimSpace(m) == "" {
+								continue
+							}
+							parts := strings.Split(m, ":")
+							if len(parts) != 2 {
+								err := errors.New("Invalid mask: " + m)
+								fmt.Println(err)
+								return err
+							}
+							mask[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+						}
+						role := &Role{
+							Id:    c.String("id"),
+							AppId: c.String("app"),
+							Columns: []*ColumnRule{
+								&ColumnRule{
+									Target: c.String("target"),
+									Allow:  c.String("allow"),
+									Deny:   c.String("deny"),
+									Mask:   mask,
+								},
+							},
+						}
+						roleJSONBytes, err := json.Marshal(role)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliRoleColumnsCommand := &Command{
+							Type: "CLI_ROLE_COLUMNS",
+							Data: string(roleJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliRoleColumnsCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "policy",
+			Usage: "row policy commands",
+			Subcommands: []cli.Command{
+				{
+					Name:  "add",
+					Usage: "add a new row policy",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "name of the policy",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "target, g",
+							Usage: "tables the policy applies to, e.g. orders,invoices or *",
+						},
+						cli.StringFlag{
+							Name:  "read, r",
+							Usage: "predicate rows must match to be listed or loaded, e.g. OWNER = :user_email",
+						},
+						cli.StringFlag{
+							Name:  "write, w",
+							Usage: "predicate rows must match to be updated, deleted or duplicated",
+						},
+						cli.StringFlag{
+							Name:  "exempt, e",
+							Usage: "roles the policy does not apply to, comma separated",
+						},
+						cli.StringFlag{
+							Name:  "note, t",
+							Usage: "note for the policy",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						appId := c.String("app")
+						id := appId + strings.Replace(uuid.NewV4().String(), "-", "", -1)
+						policy := &Policy{
+							Id:     id,
+							Name:   c.String("name"),
+							AppId:  appId,
+							Target: c.String("target"),
+							Read:   c.String("read"),
+							Write:  c.String("write"),
+							Exempt: c.String("exempt"),
+							Note:   c.String("note"),
+						}
+						policyJSONBytes, err := json.Marshal(policy)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliPolicyAddCommand := &Command{
+							Type: "CLI_POLICY_ADD",
+							Data: string(policyJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliPolicyAddCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "update",
+					Usage: "update an existing row policy",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "name of the policy",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the policy",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "target, g",
+							Usage: "tables the policy applies to, e.g. orders,invoices or *",
+						},
+						cli.StringFlag{
+							Name:  "read, r",
+							Usage: "predicate rows must match to be listed or loaded, e.g. OWNER = :user_email",
+						},
+						cli.StringFlag{
+							Name:  "write, w",
+							Usage: "predicate rows must match to be updated, deleted or duplicated",
+						},
+						cli.StringFlag{
+							Name:  "exempt, e",
+							Usage: "roles the policy does not apply to, comma separated",
+						},
+						cli.StringFlag{
+							Name:  "note, t",
+							Usage: "a note for the policy",
+						},
+						cli.StringFlag{
+							Name:  "status, s",
+							Usage: "status of the policy, disabled policies do not apply",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						policy := &Policy{
+							Id:     c.String("id"),
+							Name:   c.String("name"),
+							AppId:  c.String("app"),
+							Target: c.String("target"),
+							Read:   c.String("read"),
+							Write:  c.String("write"),
+							Exempt: c.String("exempt"),
+							Note:   c.String("note"),
+							Status: c.String("status"),
+						}
+						if !c.IsSet("name") {
+							policy.Name = "__not_set__"
+						}
+						if !c.IsSet("target") {
+							policy.Target = "__not_set__"
+						}
+						if !c.IsSet("read") {
+							policy.Read = "__not_set__"
+						}
+						if !c.IsSet("write") {
+							policy.Write = "__not_set__"
+						}
+						if !c.IsSet("exempt") {
+							policy.Exempt = "__not_set__"
+						}
+						if !c.IsSet("note") {
+							policy.Note = "__not_set__"
+						}
+						if !c.IsSet("status") {
+							policy.Status = "__not_set__"
+						}
+						policyJSONBytes, err := json.Marshal(policy)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliPolicyUpdateCommand := &Command{
+							Type: "CLI_POLICY_UPDATE",
+							Data: string(policyJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliPolicyUpdateCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "remove an existing row policy",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the policy",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						policy := &Policy{
+							Id:    c.String("id"),
+							AppId: c.String("app"),
+						}
+						policyJSONBytes, err := json.Marshal(policy)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliPolicyRemoveCommand := &Command{
+							Type: "CLI_POLICY_REMOVE",
+							Data: string(policyJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliPolicyRemoveCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "softdelete",
+			Usage: "soft delete table commands",
+			Subcommands: []cli.Command{
+				{
+					Name:  "set",
+					Usage: "put a table in soft delete mode, or change its purge days",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "table, t",
+							Usage: "table name, the table needs a DELETED_AT column",
+						},
+						cli.IntFlag{
+							Name:  "purge, p",
+							Usage: "days after which deleted rows are purged, never if 0",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						softDelete := &SoftDelete{
+							TableId:   c.String("table"),
+							AppId:     c.String("app"),
+							PurgeDays: c.Int("purge"),
+						}
+						softDeleteJSONBytes, err := json.Marshal(softDelete)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliSoftDeleteSetCommand := &Command{
+							Type: "CLI_SOFT_DELETE_SET",
+							Data: string(softDeleteJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliSoftDeleteSetCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "take a table out of soft delete mode",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app id",
+						},
+						cli.StringFlag{
+							Name:  "table, t",
+							Usage: "table name",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						softDelete := &SoftDelete{
+							TableId: c.String("table"),
+							AppId:   c.String("app"),
+						}
+						softDeleteJSONBytes, err := json.Marshal(softDelete)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliSoftDeleteRemoveCommand := &Command{
+							Type: "CLI_SOFT_DELETE_REMOVE",
+							Data: string(softDeleteJSONBytes),
+						}
+						response, err := sendCliCommand(node, cliSoftDeleteRemoveCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "key",
+			Usage: "user token signing key commands",
+			Subcommands: []cli.Command{
+				{
+					Name:  "rotate",
+					Usage: "add a new signing key, the current one only verifies the tokens it signed until they expire",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "alg, g",
+							Value: "ES256",
+							Usage: "algorithm of the key, RS256 or ES256",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						cliKeyRotateCommand := &Command{
+							Type: "CLI_KEY_ROTATE",
+							Data: c.String("alg"),
+						}
+						response, err := sendCliCommand(node, cliKeyRotateCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "remove a signing key at once, the tokens it signed are no longer valid",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "id, i",
+							Usage: "id of the key, the kid of the tokens it signed",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &Websql.service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						Websql.service.LoadSecrets(c)
+						node := c.String("node")
+						cliKeyRemoveCommand := &Command{
+							Type: "CLI_KEY_REMOVE",
+							Data: c.String("id"),
+						}
+						response, err := sendCliCommand(node, cliKeyRemoveCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:  "li",
 			Usage: "local interceptor commands",